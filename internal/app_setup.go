@@ -1,28 +1,38 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/farberg/cloud-self-service-api/internal/auth"
 	"github.com/farberg/cloud-self-service-api/internal/config"
+	"github.com/farberg/cloud-self-service-api/internal/generated_docs"
+	"github.com/farberg/cloud-self-service-api/internal/health"
 	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"github.com/farberg/cloud-self-service-api/internal/metrics"
+	"github.com/farberg/cloud-self-service-api/internal/notifier"
 	"github.com/farberg/cloud-self-service-api/internal/routes"
 	"github.com/farberg/cloud-self-service-api/internal/storage"
+	"github.com/farberg/cloud-self-service-api/internal/tracing"
 	"github.com/gin-contrib/cors"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
 )
 
 func CreateAppLogger(appConfig config.AppConfig) (*zap.Logger, *zap.SugaredLogger) {
-	logger, log := helper.InitLogger(appConfig.DevMode)
+	logger, log := helper.InitLogger(appConfig.DevMode, appConfig.RedactEmailsInLogs)
 	if appConfig.DevMode {
 		log.Warn("app.SetupComponents: Running in development mode. This is not secure for production!")
 	} else {
@@ -36,64 +46,231 @@ func CreateAppLogger(appConfig config.AppConfig) (*zap.Logger, *zap.SugaredLogge
 }
 
 func RunApplication() {
+	// Exit non-zero after all deferred cleanup ran (os.Exit skips deferred functions)
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	// Load environment variables from .env file
 	if err := godotenv.Load(); err != nil {
 		fmt.Printf("app.SetupComponents: Failed to load the env vars: %v", err)
 	}
 
-	// Get application configuration from environment variables
-	appConfig, err := config.GetAppConfigFromEnvironment()
+	// Get application configuration from the configuration file (if given) and environment variables,
+	// which take precedence over the file
+	configFile := os.Getenv("CONFIG_FILE")
+	var appConfig config.AppConfig
+	var err error
+	if configFile != "" {
+		appConfig, err = config.GetAppConfigFromFile(configFile)
+	} else {
+		appConfig, err = config.GetAppConfigFromEnvironment()
+	}
 	if err != nil {
 		log.Fatal("Error loading application configuration: ", err)
 	}
 
+	// Load application configuration and create logger
+	logger, log := CreateAppLogger(appConfig)
+	defer logger.Sync()
+
+	if configFile != "" {
+		log.Infof("app.RunApp: Loaded the configuration file '%s' (overridden by environment variables)", configFile)
+	}
+
+	// Report likely misconfigurations
+	for _, warning := range appConfig.Warnings() {
+		log.Warnf("app.RunApp: %s", warning)
+	}
+
+	// Export traces (if an endpoint is configured); the remaining spans are flushed on shutdown
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:       appConfig.WebServer.TracingOTLPEndpoint,
+		SampleRatio:    appConfig.WebServer.TracingSampleRatio,
+		ServiceVersion: generated_docs.Version,
+	})
+	if err != nil {
+		log.Fatalf("app.RunApp: Failed to set up tracing: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Warnf("app.RunApp: Failed to flush traces: %v", err)
+		}
+	}()
+	if appConfig.WebServer.TracingOTLPEndpoint != "" {
+		log.Infof("app.RunApp: Exporting traces to '%s' (sample ratio %g)", appConfig.WebServer.TracingOTLPEndpoint, appConfig.WebServer.TracingSampleRatio)
+	}
+
 	// Create storage component
-	storage, err := storage.NewStorage(appConfig.Storage.DbType, appConfig.Storage.DbConnectionString)
+	storageOptions := appConfig.Storage.StorageOptions()
+	storageOptions.Log = log
+	storage, err := storage.NewStorage(appConfig.Storage.DbType, appConfig.Storage.DbConnectionString, storageOptions)
 	if err != nil {
 		log.Fatalf("Failed to connect to the database: %v", err)
 	}
 
 	// If requested, insert dummy data into the database
 	if appConfig.Storage.AddDummyData {
-		err = storage.PolicyInsertDummyData()
+		inserted, skipped, err := storage.PolicyInsertDummyData()
 		if err != nil {
 			log.Fatalf("Failed to insert dummy data into the database: %v", err)
 		}
+		log.Infof("app.RunApp: Inserted %d dummy rules (%d already existed and were skipped)", inserted, skipped)
 	}
 
-	// Load application configuration and create logger
-	logger, log := CreateAppLogger(appConfig)
-	defer logger.Sync()
+	// Create the notifier for policy changes (if a sink is configured)
+	var policyNotifier *notifier.Notifier
+	if appConfig.DnsPolicyConfig.NotifierURL != "" {
+		notifierTimeout := time.Duration(appConfig.DnsPolicyConfig.NotifierTimeoutSeconds) * time.Second
+		policyNotifier = notifier.NewNotifier(appConfig.DnsPolicyConfig.NotifierURL, appConfig.WebServer.PublicBaseUrl(), notifierTimeout, log)
+	}
+
+	// The level is validated with the configuration
+	accessLogLevel, err := zapcore.ParseLevel(appConfig.WebServer.AccessLogLevel)
+	if err != nil {
+		log.Fatalf("app.RunApp: Invalid access log level: %v", err)
+	}
 
 	appData := config.AppData{
-		Config:  appConfig,
-		Storage: storage,
-		Logger:  logger,
-		Log:     log,
+		Config:       appConfig,
+		Storage:      storage,
+		Notifier:     policyNotifier,
+		AccessLogger: auth.NewAccessLogger(log, accessLogLevel),
+		Logger:       logger,
+		Log:          log,
+	}
+
+	// Collect Prometheus metrics (if enabled)
+	if appConfig.WebServer.MetricsEnabled {
+		appData.Metrics = metrics.New(storage.PoolStats)
+		appData.Metrics.ObserveNotifierFailures(policyNotifier.FailedCount)
+	}
+
+	// Restore the paused state of the webhook (if persisted)
+	if appConfig.DnsPolicyConfig.WebhookPausedPersist {
+		paused, err := routes.LoadWebhookPaused(storage)
+		if err != nil {
+			log.Fatalf("app.RunApp: Failed to load the paused state of the webhook: %v", err)
+		}
+		appData.WebhookPaused.Store(paused)
+		if paused {
+			log.Warn("app.RunApp: Webhook evaluation is paused (restored from the database).")
+		}
 	}
 
-	// Create and run the web server server forever
-	router := setupGinWebserver(&appData)
-	err = router.Run(appConfig.WebServer.GinBindString)
+	// Monitor the database health (and keep idle connections alive) in the background
+	healthSweepInterval := time.Duration(appConfig.Storage.DbHealthSweepSeconds) * time.Second
+	appData.Health = health.NewMonitor(storage, healthSweepInterval, 5*time.Second, log)
+	healthCtx, stopHealth := context.WithCancel(context.Background())
+	defer stopHealth()
+	appData.Health.Start(healthCtx)
+
+	// Update the rule expiry metrics in the background (if metrics are enabled)
+	expiryInterval := time.Duration(appConfig.WebServer.MetricsRuleExpiryIntervalSeconds) * time.Second
+	expiryWindow := time.Duration(appConfig.WebServer.MetricsRuleExpiryWindowHours) * time.Hour
+	appData.Metrics.ObserveRuleExpiries(healthCtx, ruleExpiries(storage), expiryInterval, expiryWindow, log)
+
+	// Create the hook to an external authorization service (if configured)
+	if appConfig.DnsPolicyConfig.AuthorizationHookURL != "" {
+		hookTimeout := time.Duration(appConfig.DnsPolicyConfig.AuthorizationHookTimeoutSeconds) * time.Second
+		appData.AuthorizationHook = auth.NewHTTPAuthorizationHook(appConfig.DnsPolicyConfig.AuthorizationHookURL, hookTimeout)
+		log.Infof("app.RunApp: Consulting authorization service '%s' during webhook evaluation (fail-open: %v)", appConfig.DnsPolicyConfig.AuthorizationHookURL, appConfig.DnsPolicyConfig.AuthorizationHookFailOpen)
+	}
+
+	// Create the web server
+	router, oidcAuthVerifier := setupGinWebserver(&appData)
+	defer oidcAuthVerifier.Close()
+	tlsConfig, err := appConfig.WebServer.TLSConfig()
 	if err != nil {
+		log.Fatalf("app.RunApp: Invalid TLS configuration: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:      appConfig.WebServer.GinBindString,
+		Handler:   router,
+		TLSConfig: tlsConfig,
+	}
+
+	// Serve in the background until SIGINT or SIGTERM is received
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if appConfig.WebServer.TLSEnabled() {
+			log.Infof("app.RunApp: Serving HTTPS on '%s' (minimum TLS version %s)", server.Addr, appConfig.WebServer.TLSMinVersion)
+			serverErr <- server.ListenAndServeTLS(appConfig.WebServer.TLSCertFile, appConfig.WebServer.TLSKeyFile)
+		} else {
+			log.Infof("app.RunApp: Serving HTTP on '%s'", server.Addr)
+			serverErr <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serverErr:
+		// The server only returns on its own if it failed, e.g. because the address is in use
 		log.Fatalf("app.RunApp: Failed to start server: %v", err)
+	case <-signalCtx.Done():
+	}
+
+	// Restore the default signal handling, so a second signal terminates immediately
+	stopSignals()
+
+	// Stop accepting connections and let the in-flight requests complete within the grace period
+	shutdownTimeout := appConfig.WebServer.ShutdownTimeout()
+	log.Infof("app.RunApp: Shutting down, waiting up to %s for in-flight requests to complete.", shutdownTimeout)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("app.RunApp: In-flight requests did not complete in time, aborting them: %v", err)
+		server.Close()
+		exitCode = 1
+	} else {
+		log.Info("app.RunApp: All in-flight requests completed.")
+	}
+
+	// Stop the background checks before closing the database connection
+	stopHealth()
+	if err := storage.Close(); err != nil {
+		log.Errorf("app.RunApp: Failed to close the database connection: %v", err)
+		exitCode = 1
+	} else {
+		log.Info("app.RunApp: Closed the database connection.")
 	}
 
 	log.Info("app.RunApp: Application stopped.")
 }
 
-func setupGinWebserver(app *config.AppData) (router *gin.Engine) {
-	// Determine the Gin mode based on the dev_mode variable
+func setupGinWebserver(app *config.AppData) (router *gin.Engine, oidcAuthVerifier *auth.OIDCAuthVerifier) {
+	// Determine the Gin mode based on the dev_mode variable unless it is configured explicitly
 	gin_mode := gin.ReleaseMode
 	if app.Config.DevMode {
 		gin_mode = gin.TestMode // Or gin.TestMode or gin.DebugMode
 	}
+	if app.Config.WebServer.GinMode != "" {
+		gin_mode = app.Config.WebServer.GinMode
+	}
+	gin.SetMode(gin_mode)
 
-	app.Log.Debugf("Running Gin web server in '%s' mode.", gin_mode)
+	app.Log.Infof("Running Gin web server in '%s' mode.", gin_mode)
 
 	// Set up the Gin router
 	router = gin.New()
 
+	// Only take the client IP of the per-IP limits from X-Forwarded-For if a trusted proxy sent the request,
+	// so clients cannot evade the limits by rotating the header
+	if err := router.SetTrustedProxies(app.Config.WebServer.TrustedProxies); err != nil {
+		app.Log.Fatalf("Invalid API_TRUSTED_PROXIES: %v", err)
+	}
+	if len(app.Config.WebServer.TrustedProxies) > 0 {
+		app.Log.Infof("Trusting X-Forwarded-For from the proxies %v.", app.Config.WebServer.TrustedProxies)
+	}
+
 	if app.Config.DevMode {
 		app.Log.Debugf("Completely disabling caching in development mode.")
 		router.Use(disableCachingMiddleware())
@@ -105,10 +282,33 @@ func setupGinWebserver(app *config.AppData) (router *gin.Engine) {
 	gin.DefaultErrorWriter = ginLogWriter
 	router.Use(ginzap.RecoveryWithZap(app.Logger, true))
 
+	// Assign every request an ID and log it with each request (at debug level to keep production logs lean)
+	router.Use(helper.RequestIDMiddleware(app.Config.WebServer.RequestIDHeader, app.Config.WebServer.RequestIDPolicy))
+	router.Use(ginzap.GinzapWithConfig(app.Logger, &ginzap.Config{
+		TimeFormat:   time.RFC3339,
+		UTC:          true,
+		DefaultLevel: zapcore.DebugLevel,
+		Context: func(c *gin.Context) []zapcore.Field {
+			return []zapcore.Field{zap.String("request_id", helper.RequestID(c))}
+		},
+	}))
+
+	// Trace every request (spans are discarded unless tracing is configured)
+	router.Use(tracing.Middleware())
+
+	// Record the request count and latency per route
+	if app.Metrics != nil {
+		router.Use(app.Metrics.Middleware())
+	}
+
 	// Create OIDC Auth Verifier
 	oidcConfig := auth.OIDCVerifierConfig{
-		IssuerURL: app.Config.WebServer.OIDCIssuerURL,
-		ClientID:  app.Config.WebServer.OIDCClientID,
+		IssuerURL:           app.Config.WebServer.OIDCIssuerURL,
+		ClientIDs:           app.Config.WebServer.OIDCClientIDs,
+		GroupsClaim:         app.Config.WebServer.OIDCGroupsClaim,
+		ClockSkew:           app.Config.WebServer.OIDCClockSkew(),
+		JWKSRefreshInterval: app.Config.WebServer.OIDCJWKSRefreshInterval(),
+		FailureDelay:        app.Config.WebServer.AuthFailureDelay(),
 	}
 
 	oidcAuthVerifier, err := auth.NewOIDCAuthVerifier(oidcConfig, app.Log)
@@ -116,22 +316,104 @@ func setupGinWebserver(app *config.AppData) (router *gin.Engine) {
 		app.Log.Fatalf("Failed to initialize OIDCAuthVerifier: %v", err)
 	}
 
+	// Accept session cookies in addition to bearer tokens if enabled
+	authMiddleware := oidcAuthVerifier.BearerTokenAuthMiddleware()
+	var sessions *auth.SessionManager
+	if app.Config.WebServer.SessionsEnabled {
+		sessions, err = auth.NewSessionManager(app.Config.WebServer.SessionConfig())
+		if err != nil {
+			app.Log.Fatalf("Failed to initialize SessionManager: %v", err)
+		}
+		app.Log.Info("Session cookies are enabled.")
+		authMiddleware = oidcAuthVerifier.SessionOrBearerTokenAuthMiddleware(sessions)
+	}
+
+	// Limit the request rate per user (policy routes) and per client IP (webhook) if configured
+	rateLimit := auth.RateLimitMiddleware(app.Config.WebServer.RateLimitRPS, app.Config.WebServer.RateLimitBurst)
+	if app.Config.WebServer.RateLimitEnabled() {
+		app.Log.Infof("Limiting requests to %g per second (burst %d) per user or client IP.", app.Config.WebServer.RateLimitRPS, app.Config.WebServer.RateLimitBurst)
+	}
+
+	// Register all routes below the (optional) base path, e.g. when mounted behind a reverse proxy
+	basePath := app.Config.WebServer.BasePath
+	if basePath != "" {
+		app.Log.Infof("Serving all routes under the base path '%s'.", basePath)
+	}
+	rootGroup := router.Group(basePath)
+
 	// Create static file server
-	homeGroup := router.Group("/")
+	homeGroup := rootGroup.Group("/")
 	homeGroup.Use(cors.Default())
 	routes.CreateStaticFiles(homeGroup, app)
 
+	// Create (unauthenticated) health probe routes
+	healthGroup := rootGroup.Group("/")
+	routes.CreateHealthRoutes(healthGroup, app)
+
+	// Expose the (unauthenticated) Prometheus metrics
+	if app.Metrics != nil {
+		app.Log.Infof("Exposing Prometheus metrics at '%s'.", basePath+app.Config.WebServer.MetricsPath)
+		rootGroup.GET(app.Config.WebServer.MetricsPath, gin.WrapH(app.Metrics.Handler()))
+	}
+
+	// Create router group for the (unauthenticated) auth configuration routes
+	authApiV1Group := rootGroup.Group("/v1/auth")
+	enableCorsOriginReflectionConfig(authApiV1Group, app.Config.WebServer)
+	routes.CreateAuthApiGroup(authApiV1Group, app)
+	if sessions != nil {
+		routes.CreateSessionRoutes(authApiV1Group, app, oidcAuthVerifier, sessions)
+	}
+
 	// Create router group for  API routes for v1
-	policyApiV1Group := router.Group("/v1/policies")
-	enableCorsOriginReflectionConfig(policyApiV1Group)
-	policyApiV1Group.Use(oidcAuthVerifier.BearerTokenAuthMiddleware())
+	policyApiV1Group := rootGroup.Group("/v1/policies")
+	enableCorsOriginReflectionConfig(policyApiV1Group, app.Config.WebServer)
+	policyApiV1Group.Use(authMiddleware, app.AccessLogger.Middleware(), rateLimit)
 	routes.CreatePolicyApiGroup(policyApiV1Group, app)
 
-	// Create webhook routes
-	webhookApiV1Group := router.Group("/v1/webhook")
-	enableCorsOriginReflectionConfig(webhookApiV1Group)
-	routes.CreateWebhookApiGroup(webhookApiV1Group, app)
-	return router
+	// Create router group for diagnostics routes
+	diagnosticsApiV1Group := rootGroup.Group("/v1/diagnostics")
+	enableCorsOriginReflectionConfig(diagnosticsApiV1Group, app.Config.WebServer)
+	diagnosticsApiV1Group.Use(authMiddleware, app.AccessLogger.Middleware())
+	routes.CreateDiagnosticsApiGroup(diagnosticsApiV1Group, app)
+
+	// Create router group for debug routes
+	debugApiV1Group := rootGroup.Group("/v1/debug")
+	enableCorsOriginReflectionConfig(debugApiV1Group, app.Config.WebServer)
+	debugApiV1Group.Use(authMiddleware, app.AccessLogger.Middleware())
+	routes.CreateDebugApiGroup(debugApiV1Group, app, oidcAuthVerifier)
+
+	// Create webhook routes (unless the webhook is disabled)
+	if app.Config.DnsPolicyConfig.WebhookEnabled {
+		app.Log.Info("Webhook is enabled.")
+		webhookApiV1Group := rootGroup.Group("/v1/webhook")
+		enableCorsOriginReflectionConfig(webhookApiV1Group, app.Config.WebServer)
+		if maxPerIP := app.Config.DnsPolicyConfig.WebhookMaxConcurrentPerIP; maxPerIP > 0 {
+			app.Log.Debugf("Limiting webhook to %d concurrent requests per client IP.", maxPerIP)
+			webhookApiV1Group.Use(helper.NewIPConcurrencyLimiter(maxPerIP).Middleware())
+		}
+		webhookApiV1Group.Use(rateLimit)
+		routes.CreateWebhookApiGroup(webhookApiV1Group, app)
+	} else {
+		app.Log.Info("Webhook is disabled; not registering the webhook routes.")
+	}
+
+	return router, oidcAuthVerifier
+}
+
+// ruleExpiries returns the function reading the expiry times of the rules for the rule expiry metrics.
+func ruleExpiries(s *storage.Storage) metrics.RuleExpiriesFunc {
+	return func(ctx context.Context, after time.Time) ([]metrics.RuleExpiry, error) {
+		rules, err := s.PolicyGetExpiringAfterCtx(ctx, after)
+		if err != nil {
+			return nil, err
+		}
+
+		expiries := make([]metrics.RuleExpiry, len(rules))
+		for i, rule := range rules {
+			expiries[i] = metrics.RuleExpiry{ID: rule.ID, Soa: strings.ToLower(rule.ZoneSoa), ExpiresAt: *rule.ExpiresAt}
+		}
+		return expiries, nil
+	}
 }
 
 func logAppConfig(appConfig config.AppConfig, log *zap.SugaredLogger) {
@@ -163,16 +445,17 @@ func disableCachingMiddleware() gin.HandlerFunc {
 	}
 }
 
-func enableCorsOriginReflectionConfig(router *gin.RouterGroup) {
-	allowedHeaders := []string{"Origin", "Content-Type", "Authorization"}
+// enableCorsOriginReflectionConfig allows credentialed cross-origin requests by reflecting the origin
+// of the request, if the origin is allowed by the configuration (without an allowlist, every origin).
+func enableCorsOriginReflectionConfig(router *gin.RouterGroup, webServerConfig config.WebServerConfig) {
+	allowedHeaders := []string{"Origin", "Content-Type", "Authorization", "If-Match"}
 
 	corsConfig := cors.Config{
-		AllowOriginFunc: func(origin string) bool {
-			return true
-		},
+		AllowOriginFunc:  webServerConfig.CorsOriginAllowed,
 		AllowCredentials: true,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     allowedHeaders,
+		ExposeHeaders:    []string{"ETag"},
 		MaxAge:           1 * time.Hour,
 	}
 
@@ -180,7 +463,7 @@ func enableCorsOriginReflectionConfig(router *gin.RouterGroup) {
 
 	router.OPTIONS("/*path", func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
-		if origin != "" {
+		if origin != "" && webServerConfig.CorsOriginAllowed(origin) {
 			c.Header("Access-Control-Allow-Origin", origin)
 		}
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/farberg/cloud-self-service-api/internal/storage"
+)
+
+// AuthorizationHook is consulted for every rule matching a user to confirm or veto
+// the zones the rule grants. Returning false removes the zones of the rule for the user.
+type AuthorizationHook interface {
+	Authorize(ctx context.Context, user *UserClaims, rule *storage.PolicyRule) (bool, error)
+}
+
+// AuthorizationHookRequest is the payload sent to an HTTP authorization service.
+type AuthorizationHookRequest struct {
+	User *UserClaims         `json:"user"`
+	Rule *storage.PolicyRule `json:"rule"`
+}
+
+// AuthorizationHookResponse is the decision returned by an HTTP authorization service.
+type AuthorizationHookResponse struct {
+	Allowed bool `json:"allowed"`
+}
+
+// HTTPAuthorizationHook asks an external HTTP service whether a matched rule applies to a user.
+type HTTPAuthorizationHook struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPAuthorizationHook creates an authorization hook posting to the given URL.
+// Each call is bounded by the given timeout.
+func NewHTTPAuthorizationHook(url string, timeout time.Duration) *HTTPAuthorizationHook {
+	return &HTTPAuthorizationHook{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Authorize posts the user and the rule to the authorization service and returns its decision.
+// Any status other than 2xx is treated as an error, not as a denial.
+func (h *HTTPAuthorizationHook) Authorize(ctx context.Context, user *UserClaims, rule *storage.PolicyRule) (bool, error) {
+	body, err := json.Marshal(AuthorizationHookRequest{User: user, Rule: rule})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal authorization request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to call authorization service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, fmt.Errorf("authorization service responded with status %d", resp.StatusCode)
+	}
+
+	var decision AuthorizationHookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return false, fmt.Errorf("failed to decode authorization response: %w", err)
+	}
+	return decision.Allowed, nil
+}
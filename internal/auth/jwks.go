@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	jose "gopkg.in/go-jose/go-jose.v2"
+)
+
+const (
+	// MaxJWKSStaleness is how long the last successfully fetched keys are used while the identity
+	// provider cannot be reached. Afterwards the keys are considered expired and tokens are rejected.
+	MaxJWKSStaleness = 24 * time.Hour
+	// minJWKSOnDemandRefresh limits how often a token signed with an unknown key triggers a refresh,
+	// so tokens with made-up key IDs cannot flood the identity provider with requests.
+	minJWKSOnDemandRefresh = time.Minute
+	// jwksFetchTimeout bounds a single fetch of the key set.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+var (
+	// ErrJWKSUnavailable is returned if no keys have been fetched yet or the last fetched keys have expired.
+	ErrJWKSUnavailable = errors.New("no valid signing keys of the identity provider available")
+	// ErrUnknownSigningKey is returned if no key of the key set verifies the token signature.
+	ErrUnknownSigningKey = errors.New("token is not signed by a known key of the identity provider")
+)
+
+// CachedKeySet is an OIDC key set (JWKS) that is refreshed in the background instead of on demand.
+// If a refresh fails, the last successfully fetched keys are used until they are older than
+// MaxJWKSStaleness, so a brief outage of the identity provider does not fail every request.
+type CachedKeySet struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+	log      *zap.SugaredLogger
+
+	mu          sync.RWMutex
+	keys        []jose.JSONWebKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+	failures    int
+
+	// Serializes refreshes, so concurrent requests with an unknown key trigger only one fetch
+	refreshMu sync.Mutex
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// NewCachedKeySet creates a key set fetching the keys from the given JWKS URL every interval.
+// The keys are not fetched before Refresh or Start is called.
+func NewCachedKeySet(jwksURL string, interval time.Duration, log *zap.SugaredLogger) *CachedKeySet {
+	return &CachedKeySet{
+		url:      jwksURL,
+		interval: interval,
+		client:   &http.Client{Timeout: jwksFetchTimeout},
+		log:      log,
+	}
+}
+
+// Start fetches the keys and keeps refreshing them in the background until Stop is called.
+// A failed initial fetch is logged and retried with the next refresh (or by the first token with an unknown key).
+func (s *CachedKeySet) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stop = cancel
+	s.done = make(chan struct{})
+
+	if err := s.Refresh(ctx); err != nil {
+		s.log.Warnf("auth.CachedKeySet: Initial fetch of the OIDC signing keys failed: %v", err)
+	}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Failures are logged by Refresh, the last known keys remain in use
+				_ = s.Refresh(ctx)
+			}
+		}
+	}()
+}
+
+// Stop ends the background refresh and waits for it to finish. It does nothing if the key set was not started.
+func (s *CachedKeySet) Stop() {
+	if s.stop == nil {
+		return
+	}
+	s.stop()
+	<-s.done
+}
+
+// Refresh fetches the keys now. On failure, the previously fetched keys are kept.
+func (s *CachedKeySet) Refresh(ctx context.Context) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	return s.refreshLocked(ctx)
+}
+
+func (s *CachedKeySet) refreshLocked(ctx context.Context) error {
+	now := time.Now()
+	keys, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	s.lastAttempt = now
+	if err != nil {
+		s.failures++
+		failures, fetchedAt := s.failures, s.fetchedAt
+		s.mu.Unlock()
+
+		// Log every failure, so prolonged outages of the identity provider can be alerted on
+		if fetchedAt.IsZero() {
+			s.log.Errorf("auth.CachedKeySet: Failed to fetch the OIDC signing keys (%d consecutive failures, no keys available): %v", failures, err)
+		} else if age := now.Sub(fetchedAt); age > MaxJWKSStaleness {
+			s.log.Errorf("auth.CachedKeySet: Failed to refresh the OIDC signing keys (%d consecutive failures), the last keys expired %s ago: %v",
+				failures, (age - MaxJWKSStaleness).Round(time.Second), err)
+		} else {
+			s.log.Warnf("auth.CachedKeySet: Failed to refresh the OIDC signing keys (%d consecutive failures), using the keys fetched %s ago: %v",
+				failures, age.Round(time.Second), err)
+		}
+		return err
+	}
+
+	recovered := s.failures > 0
+	s.keys = keys
+	s.fetchedAt = now
+	s.failures = 0
+	s.mu.Unlock()
+
+	if recovered {
+		s.log.Info("auth.CachedKeySet: Fetched the OIDC signing keys again")
+	}
+	s.log.Debugf("auth.CachedKeySet: Fetched %d OIDC signing keys", len(keys))
+	return nil
+}
+
+// fetch downloads and parses the key set.
+func (s *CachedKeySet) fetch(ctx context.Context) ([]jose.JSONWebKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys from '%s': %w", s.url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keys from '%s': %w", s.url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching keys from '%s' failed with status %d", s.url, resp.StatusCode)
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.Unmarshal(body, &keySet); err != nil {
+		return nil, fmt.Errorf("failed to decode keys from '%s': %w", s.url, err)
+	}
+	if len(keySet.Keys) == 0 {
+		return nil, fmt.Errorf("key set from '%s' contains no keys", s.url)
+	}
+	return keySet.Keys, nil
+}
+
+// VerifySignature verifies the signature of a JWT with the cached keys and returns its payload
+// (implementing oidc.KeySet). A token signed with an unknown key triggers a refresh at most
+// every minute, so rotated keys are picked up before the next scheduled refresh.
+func (s *CachedKeySet) VerifySignature(ctx context.Context, jwt string) ([]byte, error) {
+	jws, err := jose.ParseSigned(jwt)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %w", err)
+	}
+	keyID := ""
+	if len(jws.Signatures) > 0 {
+		keyID = jws.Signatures[0].Header.KeyID
+	}
+
+	payload, err := s.verify(jws, keyID)
+	if !errors.Is(err, ErrUnknownSigningKey) && !errors.Is(err, ErrJWKSUnavailable) {
+		return payload, err
+	}
+
+	// Verify again even if no refresh was due, another request may just have refreshed the keys
+	s.refreshOnDemand(ctx)
+	return s.verify(jws, keyID)
+}
+
+// verify checks the signature with all unexpired keys matching the key ID (or all keys if the token has none).
+func (s *CachedKeySet) verify(jws *jose.JSONWebSignature, keyID string) ([]byte, error) {
+	s.mu.RLock()
+	keys, fetchedAt := s.keys, s.fetchedAt
+	s.mu.RUnlock()
+
+	if fetchedAt.IsZero() || time.Since(fetchedAt) > MaxJWKSStaleness {
+		return nil, ErrJWKSUnavailable
+	}
+
+	for _, key := range keys {
+		if keyID != "" && key.KeyID != keyID {
+			continue
+		}
+		if payload, err := jws.Verify(&key); err == nil {
+			return payload, nil
+		}
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+// refreshOnDemand refreshes the keys unless they were fetched (or attempted to) within the last minute.
+func (s *CachedKeySet) refreshOnDemand(ctx context.Context) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	s.mu.RLock()
+	recent := time.Since(s.lastAttempt) < minJWKSOnDemandRefresh
+	s.mu.RUnlock()
+	if recent {
+		return
+	}
+
+	// Failures are logged by refreshLocked
+	_ = s.refreshLocked(ctx)
+}
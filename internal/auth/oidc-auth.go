@@ -2,12 +2,16 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/coreos/go-oidc"
+	"github.com/farberg/cloud-self-service-api/internal/helper"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -17,7 +21,17 @@ const UserDataKey = "__api_userData"
 // OIDCVerifierConfig holds the minimal configuration for OIDC token verification.
 type OIDCVerifierConfig struct {
 	IssuerURL string
-	ClientID  string
+	// The client IDs of all applications whose tokens are accepted. A token is valid if its
+	// audience (aud claim) contains any of them.
+	ClientIDs []string
+	// The ID token claim holding the groups of the user ("groups" if empty)
+	GroupsClaim string
+	// The tolerated clock difference to the identity provider when checking the exp, iat, and nbf claims
+	ClockSkew time.Duration
+	// How often the signing keys (JWKS) of the identity provider are refreshed in the background
+	JWKSRefreshInterval time.Duration
+	// The maximum random delay before responding to a failed authentication
+	FailureDelay time.Duration
 }
 
 // OIDCAuthVerifier manages the OIDC token verification process.
@@ -25,11 +39,17 @@ type OIDCAuthVerifier struct {
 	Config   OIDCVerifierConfig
 	Verifier *oidc.IDTokenVerifier
 	Logger   *zap.SugaredLogger
+	// The cached signing keys used by the verifier (refreshed until Close is called)
+	KeySet *CachedKeySet
 }
 
 // NewOIDCAuthVerifier initializes a new OIDCAuthVerifier.
-// It sets up the ID token verifier using the issuer URL and client ID.
+// It sets up the ID token verifier using the issuer URL and client IDs.
 func NewOIDCAuthVerifier(cfg OIDCVerifierConfig, log *zap.SugaredLogger) (*OIDCAuthVerifier, error) {
+	if len(cfg.ClientIDs) == 0 {
+		return nil, errors.New("at least one OIDC client ID is required")
+	}
+
 	ctx := context.Background()
 	// Discover the OIDC provider's configuration from the issuer URL
 	// This fetches the JWKS endpoint and other metadata needed for verification.
@@ -38,37 +58,65 @@ func NewOIDCAuthVerifier(cfg OIDCVerifierConfig, log *zap.SugaredLogger) (*OIDCA
 		return nil, fmt.Errorf("failed to create OIDC provider for issuer '%s': %w", cfg.IssuerURL, err)
 	}
 
+	// Cache the signing keys instead of relying on the on-demand fetching of the OIDC library,
+	// so tokens can still be verified during a brief outage of the identity provider
+	var discovery struct {
+		JWKSURL string `json:"jwks_uri"`
+	}
+	if err := provider.Claims(&discovery); err != nil {
+		return nil, fmt.Errorf("failed to read the discovery document of issuer '%s': %w", cfg.IssuerURL, err)
+	}
+	if discovery.JWKSURL == "" {
+		return nil, fmt.Errorf("the discovery document of issuer '%s' has no jwks_uri", cfg.IssuerURL)
+	}
+	keySet := NewCachedKeySet(discovery.JWKSURL, cfg.JWKSRefreshInterval, log)
+	keySet.Start()
+
 	// Configure the ID token verifier.
-	// The ClientID here acts as the expected audience (aud claim) for the token.
+	// The verifier only supports a single expected audience and checks the expiry without any
+	// clock skew tolerance, so the audience and the token times are checked in VerifyToken instead.
 	oidcConfig := &oidc.Config{
-		ClientID: cfg.ClientID,
-		// If you have multiple audiences, you can specify them here:
-		// ExpectedAudience: []string{"your-api-audience", "another-audience"},
+		SkipClientIDCheck: true,
+		SkipExpiryCheck:   true,
 	}
-	verifier := provider.Verifier(oidcConfig)
+	verifier := oidc.NewVerifier(cfg.IssuerURL, keySet, oidcConfig)
 
 	return &OIDCAuthVerifier{
 		Config:   cfg,
 		Verifier: verifier,
 		Logger:   log,
+		KeySet:   keySet,
 	}, nil
 }
 
+// Close stops the background refresh of the signing keys.
+func (m *OIDCAuthVerifier) Close() {
+	if m.KeySet != nil {
+		m.KeySet.Stop()
+	}
+}
+
 // BearerTokenAuthMiddleware is a Gin middleware to verify OIDC bearer tokens.
 // It expects the token in the "Authorization: Bearer <token>" header.
 func (m *OIDCAuthVerifier) BearerTokenAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Deny access after a random delay to slow down token guessing
+		denyAccess := func(message string) {
+			helper.AuthFailureDelay(c.Request.Context(), m.Config.FailureDelay)
+			helper.RespondError(c, http.StatusUnauthorized, helper.ErrorCodeUnauthorized, message)
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			m.Logger.Debug("Authorization header missing. Denying access.")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			denyAccess("Authorization header required")
 			return
 		}
 
 		// Check if the header starts with "Bearer "
 		if !strings.HasPrefix(authHeader, "Bearer ") {
 			m.Logger.Debug("Authorization header does not start with 'Bearer '. Denying access.")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unsupported authorization type. Use Bearer token."})
+			denyAccess("Unsupported authorization type. Use Bearer token.")
 			return
 		}
 
@@ -76,38 +124,130 @@ func (m *OIDCAuthVerifier) BearerTokenAuthMiddleware() gin.HandlerFunc {
 		rawIDToken := strings.TrimPrefix(authHeader, "Bearer ")
 		if rawIDToken == "" {
 			m.Logger.Debug("Bearer token is empty. Denying access.")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Bearer token missing"})
+			denyAccess("Bearer token missing")
 			return
 		}
 
-		ctx := context.Background()
-		// Verify the ID token's signature, issuer, audience, and expiry
-		idToken, err := m.Verifier.Verify(ctx, rawIDToken)
+		// Verify the ID token and extract the claims
+		claims, err := m.VerifyToken(context.Background(), rawIDToken)
 		if err != nil {
 			m.Logger.Warnf("Failed to verify ID token from Authorization header: %v. Denying access.", err)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("Invalid or expired token: %v", err)})
+			denyAccess(err.Error())
 			return
 		}
 
-		// Optional: Explicitly check for token expiry, though oidc.Verifier usually handles this.
-		if idToken.Expiry.Before(time.Now()) {
-			m.Logger.Warnf("ID token expired for user '%s'. Denying access.", idToken.Subject)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token expired"})
+		// Store user claims in Gin context for access in subsequent handlers
+		c.Set(UserDataKey, claims)
+		//m.Logger.Debugf("Token verified for user '%s' (sub: %s, email: %s).", claims.PreferredUsername, claims.Subject, claims.Email)
+
+		c.Next() // Continue to the next handler in the chain
+	}
+}
+
+// SessionOrBearerTokenAuthMiddleware is a Gin middleware accepting either a bearer token or a session
+// cookie issued by the session manager. Requests with an Authorization header are always verified as
+// bearer token requests, so API clients are unaffected by the session cookie.
+func (m *OIDCAuthVerifier) SessionOrBearerTokenAuthMiddleware(sessions *SessionManager) gin.HandlerFunc {
+	bearerAuth := m.BearerTokenAuthMiddleware()
+
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			bearerAuth(c)
 			return
 		}
 
-		// Extract claims from the verified ID token
-		var claims UserClaims
-		if err := idToken.Claims(&claims); err != nil {
-			m.Logger.Errorf("Failed to parse ID token claims: %v. Denying access.", err)
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user claims from token."})
+		claims, err := sessions.Validate(c)
+		if errors.Is(err, ErrNoSession) {
+			// Respond like the bearer token authentication for requests without any credentials
+			bearerAuth(c)
+			return
+		}
+		if err != nil {
+			m.Logger.Debugf("Session cookie rejected: %v. Denying access.", err)
+			helper.AuthFailureDelay(c.Request.Context(), m.Config.FailureDelay)
+			helper.RespondError(c, http.StatusUnauthorized, helper.ErrorCodeUnauthorized, err.Error())
+			return
+		}
+		if err := sessions.CheckOrigin(c); err != nil {
+			m.Logger.Warnf("%v. Denying access.", err)
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, err.Error())
 			return
 		}
 
-		// Store user claims in Gin context for access in subsequent handlers
-		c.Set(UserDataKey, &claims)
-		//m.Logger.Debugf("Token verified for user '%s' (sub: %s, email: %s).", claims.PreferredUsername, claims.Subject, claims.Email)
+		c.Set(UserDataKey, claims)
+		c.Next()
+	}
+}
 
-		c.Next() // Continue to the next handler in the chain
+// VerifyToken verifies a raw ID token (signature, issuer, audience, and expiry)
+// and extracts the user claims from it.
+func (m *OIDCAuthVerifier) VerifyToken(ctx context.Context, rawIDToken string) (*UserClaims, error) {
+	idToken, err := m.Verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid or expired token: %w", err)
+	}
+
+	if !m.acceptsAudience(idToken.Audience) {
+		return nil, fmt.Errorf("Invalid token: audience %v does not match any configured client ID", idToken.Audience)
+	}
+
+	// Check the token times allowing for the clock skew between the identity provider and us
+	var timeClaims struct {
+		NotBefore *float64 `json:"nbf"`
+	}
+	if err := idToken.Claims(&timeClaims); err != nil {
+		return nil, fmt.Errorf("Failed to parse user claims from token: %w", err)
+	}
+	var notBefore time.Time
+	if timeClaims.NotBefore != nil {
+		notBefore = time.Unix(int64(*timeClaims.NotBefore), 0)
+	}
+	if err := checkTokenTimes(time.Now(), idToken.Expiry, idToken.IssuedAt, notBefore, m.Config.ClockSkew); err != nil {
+		return nil, fmt.Errorf("Invalid token for user '%s': %w", idToken.Subject, err)
+	}
+
+	// Extract claims from the verified ID token
+	var claims UserClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("Failed to parse user claims from token: %w", err)
+	}
+
+	// The "groups" claim is parsed with the other claims, any other groups claim is read separately
+	if m.Config.GroupsClaim != "" && m.Config.GroupsClaim != "groups" {
+		var rawClaims map[string]json.RawMessage
+		if err := idToken.Claims(&rawClaims); err != nil {
+			return nil, fmt.Errorf("Failed to parse user claims from token: %w", err)
+		}
+		claims.Groups = ParseGroupsClaim(rawClaims[m.Config.GroupsClaim])
+	}
+
+	return &claims, nil
+}
+
+// checkTokenTimes checks that a token has not expired and was not issued (or becomes valid) in the future,
+// tolerating the given clock skew in both directions. Zero issuedAt and notBefore times are not checked.
+func checkTokenTimes(now time.Time, expiry time.Time, issuedAt time.Time, notBefore time.Time, skew time.Duration) error {
+	if expiry.IsZero() {
+		return errors.New("token has no expiry")
+	}
+	if now.Add(-skew).After(expiry) {
+		return fmt.Errorf("token expired at %s", expiry.UTC().Format(time.RFC3339))
+	}
+	if !issuedAt.IsZero() && now.Add(skew).Before(issuedAt) {
+		return fmt.Errorf("token issued in the future (at %s)", issuedAt.UTC().Format(time.RFC3339))
+	}
+	if !notBefore.IsZero() && now.Add(skew).Before(notBefore) {
+		return fmt.Errorf("token not valid before %s", notBefore.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// acceptsAudience reports whether the audience of a token contains any of the configured client IDs.
+func (m *OIDCAuthVerifier) acceptsAudience(audience []string) bool {
+	for _, aud := range audience {
+		if slices.Contains(m.Config.ClientIDs, aud) {
+			return true
+		}
 	}
+	return false
 }
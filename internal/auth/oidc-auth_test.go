@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	jose "gopkg.in/go-jose/go-jose.v2"
+)
+
+// testIdentityProvider is an OIDC identity provider serving a discovery document and the signing key
+// of the tokens it issues.
+type testIdentityProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+// newTestIdentityProvider starts an identity provider that is stopped when the test ends.
+func newTestIdentityProvider(t *testing.T) *testIdentityProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	idp := &testIdentityProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": idp.server.URL, "jwks_uri": idp.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}}})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// newVerifier creates a verifier accepting the tokens of the identity provider for the client IDs.
+func (idp *testIdentityProvider) newVerifier(t *testing.T, cfg OIDCVerifierConfig) *OIDCAuthVerifier {
+	t.Helper()
+	cfg.IssuerURL = idp.server.URL
+	if cfg.JWKSRefreshInterval == 0 {
+		cfg.JWKSRefreshInterval = time.Hour
+	}
+	verifier, err := NewOIDCAuthVerifier(cfg, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+	t.Cleanup(verifier.Close)
+	return verifier
+}
+
+// issueToken signs a token of the identity provider. The issuer, the subject, and an expiry in an hour
+// are added unless given in claims.
+func (idp *testIdentityProvider) issueToken(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	token := map[string]any{"iss": idp.server.URL, "sub": "jane", "email": "jane@example.org", "exp": time.Now().Add(time.Hour).Unix()}
+	for name, value := range claims {
+		token[name] = value
+	}
+	payload, err := json.Marshal(token)
+	if err != nil {
+		t.Fatalf("failed to encode claims: %v", err)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: idp.key, KeyID: "test"}}, nil)
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+	signed, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	raw, err := signed.CompactSerialize()
+	if err != nil {
+		t.Fatalf("failed to serialize token: %v", err)
+	}
+	return raw
+}
+
+func TestVerifyTokenAudiences(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	verifier := idp.newVerifier(t, OIDCVerifierConfig{ClientIDs: []string{"web", "cli", "mobile"}})
+
+	tests := []struct {
+		name     string
+		audience any
+		valid    bool
+	}{
+		{"web", "web", true},
+		{"cli", "cli", true},
+		{"mobile", "mobile", true},
+		{"several audiences including a client", []string{"other", "mobile"}, true},
+		{"other client", "other", false},
+		{"several other clients", []string{"other", "unknown"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := idp.issueToken(t, map[string]any{"aud": test.audience})
+			claims, err := verifier.VerifyToken(context.Background(), token)
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got: %v", test.valid, err)
+			}
+			if err == nil && claims.Email != "jane@example.org" {
+				t.Fatalf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenGroupsClaim(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	token := idp.issueToken(t, map[string]any{"aud": "web", "groups": []string{"staff"}, "roles": []string{"dns-admins", "staff"}, "role": "dns-admins"})
+
+	tests := []struct {
+		claim string
+		want  []string
+	}{
+		{"", []string{"staff"}},
+		{"groups", []string{"staff"}},
+		{"roles", []string{"dns-admins", "staff"}},
+		{"role", []string{"dns-admins"}},
+		{"missing", nil},
+	}
+	for _, test := range tests {
+		verifier := idp.newVerifier(t, OIDCVerifierConfig{ClientIDs: []string{"web"}, GroupsClaim: test.claim})
+		claims, err := verifier.VerifyToken(context.Background(), token)
+		if err != nil {
+			t.Fatalf("%s: VerifyToken failed: %v", test.claim, err)
+		}
+		if !slices.Equal(claims.Groups, test.want) {
+			t.Fatalf("%s: expected groups %v, got %v", test.claim, test.want, claims.Groups)
+		}
+	}
+}
+
+func TestVerifyTokenClockSkew(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	now := time.Now()
+	seconds := func(offset int) int64 { return now.Add(time.Duration(offset) * time.Second).Unix() }
+
+	tests := []struct {
+		name   string
+		skew   time.Duration
+		claims map[string]any
+		valid  bool
+	}{
+		{"issued now", 30 * time.Second, map[string]any{"iat": seconds(0)}, true},
+		{"issued a few seconds in the future", 30 * time.Second, map[string]any{"iat": seconds(5)}, true},
+		{"issued beyond the tolerance", 30 * time.Second, map[string]any{"iat": seconds(60)}, false},
+		{"issued in the future without tolerance", 0, map[string]any{"iat": seconds(5)}, false},
+		{"valid a few seconds in the future", 30 * time.Second, map[string]any{"iat": seconds(0), "nbf": seconds(5)}, true},
+		{"valid beyond the tolerance", 30 * time.Second, map[string]any{"iat": seconds(0), "nbf": seconds(60)}, false},
+		{"expired within the tolerance", 30 * time.Second, map[string]any{"iat": seconds(-3600), "exp": seconds(-5)}, true},
+		{"expired beyond the tolerance", 30 * time.Second, map[string]any{"iat": seconds(-3600), "exp": seconds(-60)}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := idp.newVerifier(t, OIDCVerifierConfig{ClientIDs: []string{"web"}, ClockSkew: test.skew})
+			test.claims["aud"] = "web"
+			if _, err := verifier.VerifyToken(context.Background(), idp.issueToken(t, test.claims)); (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got: %v", test.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitMiddleware returns a Gin middleware allowing rps requests per second (with bursts of up to burst
+// requests) per authenticated user, or per client IP for requests without an authenticated user (e.g. the
+// webhook). It must run after the auth middleware to see the user. A rate or burst of zero disables the limit.
+func RateLimitMiddleware(rps float64, burst int) gin.HandlerFunc {
+	if rps <= 0 || burst <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	limiter := helper.NewRateLimiter(rps, burst)
+	return limiter.Middleware(rateLimitKey)
+}
+
+// rateLimitKey identifies the client a request is counted for.
+func rateLimitKey(c *gin.Context) string {
+	if value, exists := c.Get(UserDataKey); exists {
+		if user, ok := value.(*UserClaims); ok {
+			return "user:" + strings.ToLower(userPrincipal(user))
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newRateLimitedRouter limits the requests of the users given in the X-Test-User header (or of the
+// client IP for requests without the header). X-Forwarded-For is trusted only from the given proxies.
+func newRateLimitedRouter(rps float64, burst int, trustedProxies ...string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		panic(err)
+	}
+	router.Use(func(c *gin.Context) {
+		if email := c.GetHeader("X-Test-User"); email != "" {
+			c.Set(UserDataKey, &UserClaims{Email: email})
+		}
+	}, RateLimitMiddleware(rps, burst))
+	router.GET("/", func(c *gin.Context) { c.Status(200) })
+	return router
+}
+
+// rateLimitedRequest sends a request from the client IP on behalf of the user (if not empty), optionally
+// with an X-Forwarded-For header.
+func rateLimitedRequest(router *gin.Engine, user string, ip string, forwardedFor ...string) int {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = ip + ":12345"
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	for _, address := range forwardedFor {
+		req.Header.Add("X-Forwarded-For", address)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	router := newRateLimitedRouter(0.001, 1)
+
+	// Users are limited regardless of their IP (and their email's case)
+	if code := rateLimitedRequest(router, "jane@example.org", "192.0.2.1"); code != 200 {
+		t.Fatalf("expected the first request of the user to be allowed, got %d", code)
+	}
+	if code := rateLimitedRequest(router, "Jane@Example.org", "192.0.2.2"); code != 429 {
+		t.Fatalf("expected the second request of the user to be limited, got %d", code)
+	}
+	if code := rateLimitedRequest(router, "john@example.org", "192.0.2.1"); code != 200 {
+		t.Fatalf("expected the request of another user to be allowed, got %d", code)
+	}
+
+	// Requests without a user are limited by client IP
+	if code := rateLimitedRequest(router, "", "192.0.2.1"); code != 200 {
+		t.Fatalf("expected the first request of the IP to be allowed, got %d", code)
+	}
+	if code := rateLimitedRequest(router, "", "192.0.2.1"); code != 429 {
+		t.Fatalf("expected the second request of the IP to be limited, got %d", code)
+	}
+	if code := rateLimitedRequest(router, "", "192.0.2.2"); code != 200 {
+		t.Fatalf("expected the request of another IP to be allowed, got %d", code)
+	}
+
+	// A rate or burst of zero disables the limit
+	for _, router := range []*gin.Engine{newRateLimitedRouter(0, 1), newRateLimitedRouter(1, 0)} {
+		for range 5 {
+			if code := rateLimitedRequest(router, "jane@example.org", "192.0.2.1"); code != 200 {
+				t.Fatalf("expected no limit, got %d", code)
+			}
+		}
+	}
+}
+
+func TestRateLimitMiddlewareForwardedFor(t *testing.T) {
+	// Without trusted proxies, rotating X-Forwarded-For does not evade the limit of the client IP
+	router := newRateLimitedRouter(0.001, 1)
+	if code := rateLimitedRequest(router, "", "192.0.2.1", "198.51.100.1"); code != 200 {
+		t.Fatalf("expected the first request to be allowed, got %d", code)
+	}
+	for _, spoofed := range []string{"198.51.100.2", "198.51.100.3"} {
+		if code := rateLimitedRequest(router, "", "192.0.2.1", spoofed); code != 429 {
+			t.Fatalf("expected the request with the spoofed X-Forwarded-For %s to be limited, got %d", spoofed, code)
+		}
+	}
+
+	// Behind a trusted proxy, the forwarded clients are limited separately
+	router = newRateLimitedRouter(0.001, 1, "192.0.2.10")
+	if code := rateLimitedRequest(router, "", "192.0.2.10", "198.51.100.1"); code != 200 {
+		t.Fatalf("expected the first request of the forwarded client to be allowed, got %d", code)
+	}
+	if code := rateLimitedRequest(router, "", "192.0.2.10", "198.51.100.1"); code != 429 {
+		t.Fatalf("expected the second request of the forwarded client to be limited, got %d", code)
+	}
+	if code := rateLimitedRequest(router, "", "192.0.2.10", "198.51.100.2"); code != 200 {
+		t.Fatalf("expected the request of another forwarded client to be allowed, got %d", code)
+	}
+
+	// A client cannot prepend a spoofed address to the chain of the trusted proxy
+	if code := rateLimitedRequest(router, "", "192.0.2.10", "203.0.113.7, 198.51.100.1"); code != 429 {
+		t.Fatalf("expected the prepended address to be ignored, got %d", code)
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MinSessionSecretLength is the minimum length of the secret signing the session cookies.
+const MinSessionSecretLength = 32
+
+var (
+	// ErrNoSession is returned if a request carries no session cookie.
+	ErrNoSession = errors.New("no session cookie")
+	// ErrInvalidSession is returned if a session cookie is malformed, forged, or expired.
+	ErrInvalidSession = errors.New("invalid or expired session")
+)
+
+// SessionConfig holds the settings of the session cookies.
+type SessionConfig struct {
+	// The name of the session cookie
+	CookieName string
+	// The secret signing the session cookies (at least MinSessionSecretLength bytes)
+	Secret string
+	// How long a session is valid after login
+	TTL time.Duration
+	// The SameSite mode of the cookie ("strict", "lax", or "none")
+	SameSite string
+	// Whether the cookie is only sent over HTTPS (should only be disabled for local development)
+	Secure bool
+	// The path the cookie is valid for (e.g. the base path of the API)
+	Path string
+	// The origin (e.g. "https://dns.example.com") cookie-authenticated requests must come from
+	// if they send an Origin header. This guards against cross-site request forgery.
+	AllowedOrigin string
+}
+
+// SessionManager issues and validates stateless session cookies. The cookie holds the user claims and
+// the expiry, signed with HMAC-SHA256, so sessions survive restarts and need no server-side storage.
+// As a consequence, a session cannot be revoked before it expires other than by rotating the secret.
+type SessionManager struct {
+	config   SessionConfig
+	sameSite http.SameSite
+}
+
+// sessionPayload is the signed content of a session cookie.
+type sessionPayload struct {
+	Claims    UserClaims `json:"claims"`
+	ExpiresAt int64      `json:"exp"`
+}
+
+// NewSessionManager creates a session manager, validating the secret and the SameSite mode.
+func NewSessionManager(cfg SessionConfig) (*SessionManager, error) {
+	if len(cfg.Secret) < MinSessionSecretLength {
+		return nil, fmt.Errorf("session secret must be at least %d characters long", MinSessionSecretLength)
+	}
+
+	var sameSite http.SameSite
+	switch strings.ToLower(cfg.SameSite) {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "lax":
+		sameSite = http.SameSiteLaxMode
+	case "none":
+		// Browsers only accept SameSite=None for secure cookies
+		if !cfg.Secure {
+			return nil, errors.New("SameSite=None requires secure session cookies")
+		}
+		sameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("unsupported SameSite mode '%s' (allowed: strict, lax, none)", cfg.SameSite)
+	}
+
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+
+	return &SessionManager{config: cfg, sameSite: sameSite}, nil
+}
+
+// Issue sets a session cookie for the user on the response.
+func (s *SessionManager) Issue(c *gin.Context, claims *UserClaims) error {
+	expiresAt := time.Now().Add(s.config.TTL)
+
+	payload, err := json.Marshal(sessionPayload{Claims: *claims, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+
+	s.setCookie(c, encodedPayload+"."+s.sign(encodedPayload), expiresAt, int(s.config.TTL.Seconds()))
+	return nil
+}
+
+// Clear removes the session cookie (logout).
+func (s *SessionManager) Clear(c *gin.Context) {
+	s.setCookie(c, "", time.Unix(0, 0), -1)
+}
+
+// Validate checks the session cookie of the request and returns the user claims it holds.
+// It returns ErrNoSession if there is no cookie and ErrInvalidSession if it is not valid.
+func (s *SessionManager) Validate(c *gin.Context) (*UserClaims, error) {
+	value, err := c.Cookie(s.config.CookieName)
+	if err != nil || value == "" {
+		return nil, ErrNoSession
+	}
+
+	encodedPayload, signature, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.sign(encodedPayload))) {
+		return nil, ErrInvalidSession
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	var session sessionPayload
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return nil, ErrInvalidSession
+	}
+	if time.Now().Unix() >= session.ExpiresAt {
+		return nil, ErrInvalidSession
+	}
+
+	return &session.Claims, nil
+}
+
+// CheckOrigin rejects cookie-authenticated requests sent from a foreign origin. Browsers attach the
+// cookie automatically, so without this check (and with SameSite=None) any site could act on behalf of the user.
+func (s *SessionManager) CheckOrigin(c *gin.Context) error {
+	origin := c.GetHeader("Origin")
+	if origin == "" || s.config.AllowedOrigin == "" {
+		return nil
+	}
+	if !strings.EqualFold(strings.TrimSuffix(origin, "/"), strings.TrimSuffix(s.config.AllowedOrigin, "/")) {
+		return fmt.Errorf("cross-origin request from '%s' is not allowed with a session cookie", origin)
+	}
+	return nil
+}
+
+// sign returns the base64-encoded HMAC-SHA256 signature of the encoded payload.
+func (s *SessionManager) sign(encodedPayload string) string {
+	mac := hmac.New(sha256.New, []byte(s.config.Secret))
+	mac.Write([]byte(encodedPayload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *SessionManager) setCookie(c *gin.Context, value string, expires time.Time, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     s.config.CookieName,
+		Value:    value,
+		Path:     s.config.Path,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   s.config.Secure,
+		HttpOnly: true,
+		SameSite: s.sameSite,
+	})
+}
@@ -1,9 +1,28 @@
 package auth
 
+import "encoding/json"
+
 // UserClaims holds the relevant user information extracted from the ID token.
 type UserClaims struct {
 	Subject           string `json:"sub"`
 	Email             string `json:"email,omitempty"`
 	PreferredUsername string `json:"preferred_username,omitempty"`
 	Name              string `json:"name,omitempty"`
+	// The groups of the user; rules with the %g placeholder expand once per group, the fallback zone uses the first one
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ParseGroupsClaim reads the groups of a user from the raw value of a groups claim, which is either a
+// list of strings or a single string. It returns nil for a missing claim or a value of another type.
+func ParseGroupsClaim(raw json.RawMessage) []string {
+	var groups []string
+	if err := json.Unmarshal(raw, &groups); err == nil {
+		return groups
+	}
+
+	var group string
+	if err := json.Unmarshal(raw, &group); err == nil && group != "" {
+		return []string{group}
+	}
+	return nil
 }
@@ -0,0 +1,285 @@
+package config
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/farberg/cloud-self-service-api/internal/auth"
+)
+
+// validTestConfig returns a default configuration that passes the validation.
+func validTestConfig() AppConfig {
+	config := DefaultAppConfig()
+	config.WebServer.OIDCIssuerURL = "https://issuer.example.org"
+	config.WebServer.OIDCClientIDs = []string{"dns-api"}
+	config.DnsPolicyConfig.WebhookApiKey = "test-webhook-api-key"
+	config.DnsPolicyConfig.SuperAdminEmails = StringSet{"admin@example.org": {}}
+	return config
+}
+
+func TestValidateRedactsSecrets(t *testing.T) {
+	config := validTestConfig()
+	config.WebServer.SessionsEnabled = true
+	config.WebServer.SessionSecret = "too-short-secret"
+	config.WebServer.CorsAllowedOrigins = StringSet{"https://app.example.org": {}}
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("expected a too short session secret to be rejected")
+	}
+	if strings.Contains(err.Error(), config.WebServer.SessionSecret) {
+		t.Fatalf("the session secret appears in the error: %v", err)
+	}
+	if !strings.Contains(err.Error(), "SessionSecret") || !strings.Contains(err.Error(), "(Length: 16)") {
+		t.Fatalf("expected the field and the length of the secret in the error, got: %v", err)
+	}
+
+	// The values of other fields are still included
+	config = validTestConfig()
+	config.WebServer.SessionSameSite = "sometimes"
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "(Value: 'sometimes')") {
+		t.Fatalf("expected the value of a non-secret field in the error, got: %v", err)
+	}
+}
+
+func TestValidateSessionsRequireCorsAllowlist(t *testing.T) {
+	config := validTestConfig()
+	config.WebServer.SessionsEnabled = true
+	config.WebServer.SessionSecret = strings.Repeat("s", 32)
+
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "API_CORS_ALLOWED_ORIGINS") {
+		t.Fatalf("expected sessions without a CORS allowlist to be rejected, got: %v", err)
+	}
+
+	config.WebServer.CorsAllowedOrigins = StringSet{"https://app.example.org": {}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("expected sessions with a CORS allowlist to be accepted, got: %v", err)
+	}
+
+	// Without sessions, an empty allowlist is accepted
+	config = validTestConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("expected the configuration without sessions to be accepted, got: %v", err)
+	}
+}
+
+func TestCorsOriginAllowed(t *testing.T) {
+	if !(WebServerConfig{}).CorsOriginAllowed("https://evil.example.com") {
+		t.Fatal("expected every origin to be allowed without an allowlist")
+	}
+
+	webServerConfig := WebServerConfig{CorsAllowedOrigins: StringSet{"https://app.example.org": {}}}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.org", true},
+		{"https://APP.example.org/", true},
+		{"http://app.example.org", false},
+		{"https://app.example.org.evil.com", false},
+		{"https://evil.example.com", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := webServerConfig.CorsOriginAllowed(test.origin); got != test.want {
+			t.Errorf("CorsOriginAllowed(%q) = %v, want %v", test.origin, got, test.want)
+		}
+	}
+}
+
+func TestValidateWebhookCredentials(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		apiKey     string
+		hmacSecret string
+		valid      bool
+	}{
+		{"no credentials", true, "", "", false},
+		{"api key", true, "test-webhook-api-key", "", true},
+		{"hmac secret only", true, "", "test-hmac-secret", true},
+		{"disabled without credentials", false, "", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validTestConfig()
+			config.DnsPolicyConfig.WebhookEnabled = test.enabled
+			config.DnsPolicyConfig.WebhookApiKey = test.apiKey
+			config.DnsPolicyConfig.WebhookHmacSecret = test.hmacSecret
+			if err := config.Validate(); (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got: %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestOIDCClientIDsFromEnvironment(t *testing.T) {
+	t.Setenv("OIDC_CLIENT_ID", " web, cli ,mobile,")
+	config := applyEnvironment(validTestConfig())
+	if !slices.Equal(config.WebServer.OIDCClientIDs, []string{"web", "cli", "mobile"}) {
+		t.Fatalf("unexpected client IDs %q", config.WebServer.OIDCClientIDs)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("expected several client IDs to be accepted, got: %v", err)
+	}
+}
+
+func TestIsSuperAdmin(t *testing.T) {
+	emailOnly := DnsPolicyConfig{SuperAdminEmails: StringSet{"admin@example.org": {}}}
+	groupOnly := DnsPolicyConfig{SuperAdminGroups: StringSet{"dns-admins": {}}}
+	both := DnsPolicyConfig{SuperAdminEmails: emailOnly.SuperAdminEmails, SuperAdminGroups: groupOnly.SuperAdminGroups}
+
+	tests := []struct {
+		name   string
+		cfg    DnsPolicyConfig
+		claims auth.UserClaims
+		want   bool
+	}{
+		{"email only: listed email", emailOnly, auth.UserClaims{Email: "Admin@Example.org"}, true},
+		{"email only: group", emailOnly, auth.UserClaims{Email: "jane@example.org", Groups: []string{"dns-admins"}}, false},
+		{"group only: listed group", groupOnly, auth.UserClaims{Email: "jane@example.org", Groups: []string{"staff", "dns-admins"}}, true},
+		{"group only: group in another case", groupOnly, auth.UserClaims{Email: "jane@example.org", Groups: []string{"DNS-Admins"}}, false},
+		{"group only: email", groupOnly, auth.UserClaims{Email: "admin@example.org"}, false},
+		{"both: listed email", both, auth.UserClaims{Email: "admin@example.org"}, true},
+		{"both: listed group", both, auth.UserClaims{Email: "jane@example.org", Groups: []string{"dns-admins"}}, true},
+		{"both: neither", both, auth.UserClaims{Email: "jane@example.org", Groups: []string{"staff"}}, false},
+		{"both: no email", both, auth.UserClaims{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsSuperAdmin(&test.claims, test.cfg); got != test.want {
+				t.Fatalf("IsSuperAdmin(%+v) = %v, want %v", test.claims, got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateRequiresSuperAdmins(t *testing.T) {
+	tests := []struct {
+		name    string
+		devMode bool
+		require bool
+		emails  StringSet
+		groups  StringSet
+		valid   bool
+	}{
+		{"production without SuperAdmins", false, true, StringSet{}, StringSet{}, false},
+		{"development without SuperAdmins", true, true, StringSet{}, StringSet{}, true},
+		{"production with a SuperAdmin email", false, true, StringSet{"admin@example.org": {}}, StringSet{}, true},
+		{"production with a SuperAdmin group", false, true, StringSet{}, StringSet{"dns-admins": {}}, true},
+		{"production without the requirement", false, false, StringSet{}, StringSet{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validTestConfig()
+			config.DevMode = test.devMode
+			config.DnsPolicyConfig.RequireSuperAdmins = test.require
+			config.DnsPolicyConfig.SuperAdminEmails = test.emails
+			config.DnsPolicyConfig.SuperAdminGroups = test.groups
+			err := config.Validate()
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got: %v", test.valid, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "DNS_POLICY_SUPERADMIN_EMAILS") {
+				t.Fatalf("expected the error to name the setting, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateAutoMigrateOnlyInDevMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		devMode     bool
+		autoMigrate bool
+		valid       bool
+	}{
+		{"production with migrations", false, false, true},
+		{"production with AutoMigrate", false, true, false},
+		{"development with AutoMigrate", true, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := validTestConfig()
+			config.DevMode = test.devMode
+			config.Storage.DbAutoMigrate = test.autoMigrate
+			err := config.Validate()
+			if (err == nil) != test.valid {
+				t.Fatalf("expected valid %v, got: %v", test.valid, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "DB_AUTO_MIGRATE") {
+				t.Fatalf("expected the error to name the setting, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestSuperAdminEmailsFromEnvironment(t *testing.T) {
+	t.Setenv("DNS_POLICY_SUPERADMIN_EMAILS", " Admin@Example.org, ,boss@EXAMPLE.org,")
+	config := applyEnvironment(DefaultAppConfig())
+
+	want := []string{"admin@example.org", "boss@example.org"}
+	if got := slices.Sorted(maps.Keys(config.DnsPolicyConfig.SuperAdminEmails)); !slices.Equal(got, want) {
+		t.Fatalf("expected the lower-cased emails %v, got %v", want, got)
+	}
+	if !IsSuperAdmin(&auth.UserClaims{Email: "ADMIN@example.org"}, config.DnsPolicyConfig) {
+		t.Fatal("expected SuperAdmin emails to match case-insensitively")
+	}
+}
+
+func TestDbConnectMaxAttemptsFromEnvironment(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts string
+		retries  string
+		want     int
+	}{
+		{"default", "", "", 4},
+		{"deprecated attempts", "3", "", 2},
+		{"a single attempt", "1", "", 0},
+		{"retries", "", "6", 6},
+		{"retries take precedence", "3", "6", 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.attempts != "" {
+				t.Setenv("DB_CONNECT_MAX_ATTEMPTS", test.attempts)
+			}
+			if test.retries != "" {
+				t.Setenv("DB_CONNECT_MAX_RETRIES", test.retries)
+			}
+			if got := applyEnvironment(DefaultAppConfig()).Storage.DbConnectMaxRetries; got != test.want {
+				t.Fatalf("expected %d retries, got %d", test.want, got)
+			}
+		})
+	}
+
+	t.Setenv("DB_CONNECT_MAX_ATTEMPTS", "0")
+	config := applyEnvironment(validTestConfig())
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "DbConnectMaxRetries") {
+		t.Fatalf("expected zero attempts to be rejected, got: %v", err)
+	}
+}
+
+func TestTrustedProxiesFromEnvironment(t *testing.T) {
+	if config := applyEnvironment(validTestConfig()); config.WebServer.TrustedProxies != nil {
+		t.Fatalf("expected no trusted proxies by default, got %v", config.WebServer.TrustedProxies)
+	}
+
+	t.Setenv("API_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.10")
+	config := applyEnvironment(validTestConfig())
+	if !slices.Equal(config.WebServer.TrustedProxies, []string{"10.0.0.0/8", "192.0.2.10"}) {
+		t.Fatalf("unexpected trusted proxies %v", config.WebServer.TrustedProxies)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("expected IPs and CIDRs to be accepted, got: %v", err)
+	}
+
+	t.Setenv("API_TRUSTED_PROXIES", "proxy.example.org")
+	config = applyEnvironment(validTestConfig())
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "TrustedProxies") {
+		t.Fatalf("expected a host name to be rejected, got: %v", err)
+	}
+}
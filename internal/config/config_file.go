@@ -0,0 +1,71 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// GetAppConfigFromFile loads the configuration from a YAML (".yaml" or ".yml") or JSON (".json") file using the
+// keys of the logged configuration (e.g. "webserver_config: {oidc_issuer_url: ...}"). Settings missing in the
+// file keep their defaults, and environment variables take precedence over the file. Secrets excluded from the
+// logged configuration (DNS_POLICY_WEBHOOK_HMAC_SECRET and API_SESSION_SECRET) can only be set by environment variables.
+func GetAppConfigFromFile(path string) (AppConfig, error) {
+	appConfig := DefaultAppConfig()
+	if err := readConfigFile(path, &appConfig); err != nil {
+		return appConfig, err
+	}
+
+	// Normalize emails and rule fields like when read from environment variables, so they are compared case-insensitively
+	appConfig.DnsPolicyConfig.SuperAdminEmails = lowerStringSet(appConfig.DnsPolicyConfig.SuperAdminEmails)
+	appConfig.DnsPolicyConfig.UserVisibleRuleFields = lowerStringSet(appConfig.DnsPolicyConfig.UserVisibleRuleFields)
+
+	appConfig = applyEnvironment(appConfig)
+
+	err := appConfig.Validate()
+	return appConfig, err
+}
+
+// readConfigFile decodes the file into the configuration, overwriting only the settings present in the file.
+// The format is determined by the file extension.
+func readConfigFile(path string, appConfig *AppConfig) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the configuration file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+	case ".yaml", ".yml":
+		// Converting to JSON lets both formats share the JSON keys and decoding rules
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			return fmt.Errorf("failed to parse the configuration file '%s': %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported configuration file format '%s' of '%s' (expected .yaml, .yml, or .json)", ext, path)
+	}
+
+	// Reject unknown keys, so misspelled settings are not silently ignored
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(appConfig); err != nil {
+		return fmt.Errorf("failed to parse the configuration file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// lowerStringSet returns the set with all values trimmed and converted to lower case. Empty values are dropped.
+func lowerStringSet(set StringSet) StringSet {
+	lowered := make(StringSet, len(set))
+	for value := range set {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			lowered[value] = struct{}{}
+		}
+	}
+	return lowered
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes a configuration file with the name into a temporary directory.
+func writeConfigFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write configuration file: %v", err)
+	}
+	return path
+}
+
+func TestGetAppConfigFromFile(t *testing.T) {
+	config, err := GetAppConfigFromFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatalf("GetAppConfigFromFile failed: %v", err)
+	}
+
+	if config.Storage.DbMaxOpenConns != 7 || config.WebServer.TracingSampleRatio != 0.5 || config.DnsPolicyConfig.WebhookApiKey != "file-webhook-api-key" {
+		t.Fatalf("expected the settings of the file, got %+v", config)
+	}
+	if !slices.Equal(config.WebServer.OIDCClientIDs, []string{"web", "cli"}) {
+		t.Fatalf("unexpected client IDs %q", config.WebServer.OIDCClientIDs)
+	}
+	// Emails are compared case-insensitively, groups exactly
+	if _, ok := config.DnsPolicyConfig.SuperAdminEmails["admin@example.org"]; !ok {
+		t.Fatalf("expected the lower-cased SuperAdmin email, got %v", config.DnsPolicyConfig.SuperAdminEmails)
+	}
+	if _, ok := config.DnsPolicyConfig.SuperAdminGroups["DNS-Admins"]; !ok {
+		t.Fatalf("expected the SuperAdmin group, got %v", config.DnsPolicyConfig.SuperAdminGroups)
+	}
+
+	// Settings missing in the file keep their defaults
+	defaults := DefaultAppConfig()
+	if config.Storage.DbMaxIdleConns != defaults.Storage.DbMaxIdleConns || len(config.DnsPolicyConfig.UserVisibleRuleFields) != len(defaults.DnsPolicyConfig.UserVisibleRuleFields) {
+		t.Fatalf("expected the defaults of settings missing in the file, got %+v", config)
+	}
+}
+
+func TestGetAppConfigFromFileEnvironmentOverrides(t *testing.T) {
+	t.Setenv("DB_MAX_OPEN_CONNS", "9")
+	t.Setenv("DNS_POLICY_SUPERADMIN_EMAILS", "Boss@Example.org")
+	t.Setenv("DNS_POLICY_WEBHOOK_HMAC_SECRET", "env-hmac-secret")
+
+	config, err := GetAppConfigFromFile("testdata/config.yaml")
+	if err != nil {
+		t.Fatalf("GetAppConfigFromFile failed: %v", err)
+	}
+
+	if config.Storage.DbMaxOpenConns != 9 {
+		t.Fatalf("expected the environment to take precedence, got %d open connections", config.Storage.DbMaxOpenConns)
+	}
+	if _, ok := config.DnsPolicyConfig.SuperAdminEmails["boss@example.org"]; !ok || len(config.DnsPolicyConfig.SuperAdminEmails) != 1 {
+		t.Fatalf("expected the SuperAdmins of the environment, got %v", config.DnsPolicyConfig.SuperAdminEmails)
+	}
+	if config.DnsPolicyConfig.WebhookHmacSecret != "env-hmac-secret" || config.WebServer.TracingSampleRatio != 0.5 {
+		t.Fatalf("expected the secret of the environment and the other settings of the file, got %+v", config)
+	}
+}
+
+func TestGetAppConfigFromFileErrors(t *testing.T) {
+	// Apart from the tested problem, the files are valid
+	valid := "webserver_config:\n  oidc_issuer_url: https://issuer.example.org\ndns_policy_config:\n  webhook_api_key: key\n  super_admin_emails: [admin@example.org]\n"
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{"valid", "config.yaml", valid, ""},
+		{"unknown key", "config.json", `{"webserver_config":{"oidc_isuer_url":"https://issuer.example.org"}}`, "unknown field"},
+		{"unsupported format", "config.toml", valid, "unsupported configuration file format"},
+		{"invalid yaml", "config.yaml", valid + "storage_config: [\n", "failed to parse"},
+		{"invalid value", "config.yml", valid + "storage_config:\n  db_type: oracle\n", "DbType"},
+		{"secret in the file", "config.json", `{"dns_policy_config":{"webhook_hmac_secret":"file-secret"}}`, "unknown field"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := GetAppConfigFromFile(writeConfigFile(t, test.file, test.content))
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("expected the configuration file to be accepted, got: %v", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("expected an error containing '%s', got: %v", test.wantErr, err)
+			}
+		})
+	}
+
+	if _, err := GetAppConfigFromFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("expected a missing configuration file to be rejected")
+	}
+}
@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Pinger is a dependency whose availability can be checked (e.g. the database).
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Status is the result of the last health check.
+type Status struct {
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Monitor checks a dependency and keeps the result of the last check. If a sweep interval is
+// configured, the dependency is checked periodically in the background, which also keeps idle
+// pooled database connections alive.
+type Monitor struct {
+	pinger   Pinger
+	interval time.Duration
+	timeout  time.Duration
+	log      *zap.SugaredLogger
+
+	mu     sync.RWMutex
+	status Status
+}
+
+// NewMonitor creates a monitor for the given dependency. An interval of zero disables the background sweep.
+// Each check is bounded by the given timeout.
+func NewMonitor(pinger Pinger, interval time.Duration, timeout time.Duration, log *zap.SugaredLogger) *Monitor {
+	return &Monitor{
+		pinger:   pinger,
+		interval: interval,
+		timeout:  timeout,
+		log:      log,
+	}
+}
+
+// SweepEnabled reports whether the dependency is checked periodically in the background.
+func (m *Monitor) SweepEnabled() bool {
+	return m.interval > 0
+}
+
+// Start runs the background sweep until the context is done. It does nothing if the sweep is disabled.
+func (m *Monitor) Start(ctx context.Context) {
+	if !m.SweepEnabled() {
+		return
+	}
+
+	m.Check(ctx)
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.Check(ctx)
+			}
+		}
+	}()
+}
+
+// Check checks the dependency now, stores the result, and returns it.
+func (m *Monitor) Check(ctx context.Context) Status {
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	status := Status{Healthy: true, LastCheck: time.Now().UTC()}
+	if err := m.pinger.Ping(ctx); err != nil {
+		status.Healthy = false
+		status.Error = err.Error()
+	}
+
+	m.mu.Lock()
+	previous := m.status
+	m.status = status
+	m.mu.Unlock()
+
+	// Only log changes of the health state to avoid flooding the log
+	if !status.Healthy && (previous.Healthy || previous.LastCheck.IsZero()) {
+		m.log.Errorf("health.Check: Dependency became unhealthy: %s", status.Error)
+	} else if status.Healthy && !previous.Healthy && !previous.LastCheck.IsZero() {
+		m.log.Info("health.Check: Dependency is healthy again")
+	}
+
+	return status
+}
+
+// Status returns the result of the last check.
+func (m *Monitor) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
@@ -0,0 +1,23 @@
+package helper
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// AuthFailureDelay waits for a random duration between zero and maxDelay to slow down credential
+// guessing. It returns early when the context is done, so a request is never held indefinitely.
+func AuthFailureDelay(ctx context.Context, maxDelay time.Duration) {
+	if maxDelay <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(rand.N(maxDelay))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
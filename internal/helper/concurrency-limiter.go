@@ -0,0 +1,62 @@
+package helper
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IPConcurrencyLimiter bounds the number of simultaneous in-flight requests per client IP.
+// Unlike a rate limit, it caps parallel load rather than requests per time window.
+type IPConcurrencyLimiter struct {
+	maxPerIP int
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// NewIPConcurrencyLimiter creates a limiter allowing maxPerIP concurrent requests per client IP.
+func NewIPConcurrencyLimiter(maxPerIP int) *IPConcurrencyLimiter {
+	return &IPConcurrencyLimiter{
+		maxPerIP: maxPerIP,
+		inFlight: make(map[string]int),
+	}
+}
+
+// Middleware returns a Gin middleware that rejects requests with 429 once the client IP
+// has reached its limit. The slot is released when the request completes.
+func (l *IPConcurrencyLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if !l.acquire(ip) {
+			RespondError(c, http.StatusTooManyRequests, ErrorCodeRateLimited, "Too many concurrent requests from this client")
+			return
+		}
+		defer l.release(ip)
+
+		c.Next()
+	}
+}
+
+func (l *IPConcurrencyLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[ip] >= l.maxPerIP {
+		return false
+	}
+	l.inFlight[ip]++
+	return true
+}
+
+func (l *IPConcurrencyLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Remove idle entries right away so the map only holds IPs with in-flight requests
+	if l.inFlight[ip] <= 1 {
+		delete(l.inFlight, ip)
+		return
+	}
+	l.inFlight[ip]--
+}
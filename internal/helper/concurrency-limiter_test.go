@@ -0,0 +1,82 @@
+package helper
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newConcurrencyLimitedRouter creates a router allowing one concurrent request per client IP, trusting
+// X-Forwarded-For only from the given proxies. Requests block until release is closed.
+func newConcurrencyLimitedRouter(t *testing.T, trustedProxies []string) (router *gin.Engine, entered chan struct{}, release chan struct{}) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router = gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatalf("failed to set trusted proxies: %v", err)
+	}
+	entered = make(chan struct{}, 10)
+	release = make(chan struct{})
+	router.Use(NewIPConcurrencyLimiter(1).Middleware())
+	router.GET("/", func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(200)
+	})
+	return router, entered, release
+}
+
+// concurrentRequest sends a request from the remote IP with the X-Forwarded-For header (if not empty).
+func concurrentRequest(router *gin.Engine, remoteIP string, forwardedFor string) int {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteIP + ":12345"
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestIPConcurrencyLimiterSpoofedForwardedFor(t *testing.T) {
+	router, entered, release := newConcurrencyLimitedRouter(t, nil)
+
+	// The first request of the client holds its only slot
+	done := make(chan int)
+	go func() { done <- concurrentRequest(router, "192.0.2.1", "") }()
+	<-entered
+
+	// Rotating X-Forwarded-For does not get the client a fresh slot
+	for _, spoofed := range []string{"198.51.100.1", "198.51.100.2"} {
+		if code := concurrentRequest(router, "192.0.2.1", spoofed); code != 429 {
+			t.Fatalf("expected 429 with the spoofed X-Forwarded-For %s, got %d", spoofed, code)
+		}
+	}
+
+	close(release)
+	if code := <-done; code != 200 {
+		t.Fatalf("expected the first request to succeed, got %d", code)
+	}
+}
+
+func TestIPConcurrencyLimiterTrustedProxy(t *testing.T) {
+	router, entered, release := newConcurrencyLimitedRouter(t, []string{"192.0.2.10"})
+
+	// Behind a trusted proxy, each forwarded client has its own slot
+	done := make(chan int)
+	go func() { done <- concurrentRequest(router, "192.0.2.10", "198.51.100.1") }()
+	<-entered
+	go func() { done <- concurrentRequest(router, "192.0.2.10", "198.51.100.2") }()
+	<-entered
+	if code := concurrentRequest(router, "192.0.2.10", "198.51.100.1"); code != 429 {
+		t.Fatalf("expected 429 for the second request of a forwarded client, got %d", code)
+	}
+
+	close(release)
+	for range 2 {
+		if code := <-done; code != 200 {
+			t.Fatalf("expected the requests of both clients to succeed, got %d", code)
+		}
+	}
+}
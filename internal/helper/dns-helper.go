@@ -1,12 +1,20 @@
 package helper
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"regexp"
 	"strings"
 )
 
 var dnsLabelCharRegex = regexp.MustCompile("^[A-Za-z0-9-]+$")
 
+// The maximum length of a DNS label (RFC 1035)
+const dnsMaxLabelLength = 63
+
+// The number of hex characters of the hash appended to truncated labels
+const dnsLabelHashLength = 8
+
 func DnsValidateName(value string) bool {
 	if value == "" {
 		return false
@@ -84,5 +92,18 @@ func DnsMakeCompliant(input string) string {
 	// Convert the entire string to lowercase
 	dnsName = strings.ToLower(dnsName)
 
+	// Truncate labels exceeding the maximum length. A hash of the full input is appended so distinct
+	// inputs sharing a long prefix do not collapse to the same label.
+	if len(dnsName) > dnsMaxLabelLength {
+		hash := sha256.Sum256([]byte(strings.ToLower(input)))
+		prefix := strings.TrimSuffix(dnsName[:dnsMaxLabelLength-dnsLabelHashLength-1], "-")
+		dnsName = prefix + "-" + hex.EncodeToString(hash[:])[:dnsLabelHashLength]
+	}
+
 	return dnsName
 }
+
+// DnsToFqdn returns the name as a fully-qualified DNS name with exactly one trailing dot.
+func DnsToFqdn(name string) string {
+	return strings.TrimRight(name, ".") + "."
+}
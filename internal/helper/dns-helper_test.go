@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDnsToFqdn(t *testing.T) {
+	tests := map[string]string{
+		"example.org":   "example.org.",
+		"example.org.":  "example.org.",
+		"example.org..": "example.org.",
+	}
+	for name, want := range tests {
+		if got := DnsToFqdn(name); got != want {
+			t.Errorf("DnsToFqdn(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestDnsMakeCompliant(t *testing.T) {
+	tests := map[string]string{
+		"jane.doe@example.org":  "jane-doe-at-example-org",
+		"Jane_Doe":              "jane-doe",
+		"--jane--doe--":         "jane-doe",
+		"!!!":                   "",
+		strings.Repeat("a", 63): strings.Repeat("a", 63),
+	}
+	for input, want := range tests {
+		if got := DnsMakeCompliant(input); got != want {
+			t.Errorf("DnsMakeCompliant(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestDnsMakeCompliantLongLabels(t *testing.T) {
+	// Two emails sharing the first 63 characters used to be truncated to the same label
+	prefix := strings.Repeat("a", 63)
+	first := DnsMakeCompliant(prefix + "-jane@example.org")
+	second := DnsMakeCompliant(prefix + "-john@example.org")
+
+	for _, label := range []string{first, second} {
+		if len(label) > dnsMaxLabelLength || !DnsIsValidLabel(label) {
+			t.Fatalf("invalid label '%s' (%d characters)", label, len(label))
+		}
+	}
+	if first == second {
+		t.Fatalf("emails sharing a 63 character prefix collide as '%s'", first)
+	}
+
+	// The label is stable and does not depend on the case
+	if DnsMakeCompliant(strings.ToUpper(prefix)+"-JANE@EXAMPLE.ORG") != first {
+		t.Fatal("the label of a long email must not depend on the case")
+	}
+}
@@ -0,0 +1,37 @@
+package helper
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var emailFolder = cases.Fold()
+
+// EmailNormalizeForMatching converts an email address (or an email pattern) into a canonical form
+// for case-insensitive comparisons. Punycode-encoded (IDN) domain labels are decoded to Unicode, and
+// the whole string is NFC-normalized and Unicode case-folded, so that e.g. "Jane@XN--MLLER-KVA.DE"
+// and "jane@müller.de" yield the same result.
+func EmailNormalizeForMatching(email string) string {
+	email = emailFolder.String(norm.NFC.String(email))
+
+	local, domain, hasDomain := cutLast(email, "@")
+	if hasDomain {
+		// The Punycode profile leaves non-IDN labels (including wildcards) untouched
+		if unicodeDomain, err := idna.Punycode.ToUnicode(domain); err == nil && unicodeDomain != domain {
+			email = local + "@" + emailFolder.String(norm.NFC.String(unicodeDomain))
+		}
+	}
+
+	return email
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s string, sep string) (before string, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,47 @@
+package helper
+
+import "testing"
+
+func TestEmailNormalizeForMatching(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		want  string
+	}{
+		{"lowercase is unchanged", "jane@example.org", "jane@example.org"},
+		{"mixed case", "Jane.Doe@Example.ORG", "jane.doe@example.org"},
+		{"unicode domain", "jane@MÜLLER.de", "jane@müller.de"},
+		{"punycode domain", "jane@xn--mller-kva.de", "jane@müller.de"},
+		{"uppercase punycode domain", "Jane@XN--MLLER-KVA.DE", "jane@müller.de"},
+		{"decomposed unicode", "jane@mu\u0308ller.de", "jane@m\u00fcller.de"},
+		{"sharp s is folded", "jane@Straße.de", "jane@strasse.de"},
+		{"non-ascii local part", "JÖRG@example.org", "jörg@example.org"},
+		{"wildcard pattern with punycode", "*@*.XN--MLLER-KVA.de", "*@*.müller.de"},
+		{"without domain", "Jane", "jane"},
+		{"invalid punycode is kept", "jane@XN--0.de", "jane@xn--0.de"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := EmailNormalizeForMatching(test.email); got != test.want {
+				t.Errorf("EmailNormalizeForMatching(%q) = %q, want %q", test.email, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEmailNormalizeForMatchingRoundTrip(t *testing.T) {
+	// The Unicode and the Punycode form of a domain, in any case, normalize to the same value
+	forms := []string{"jane@bücher.example", "jane@BÜCHER.example", "jane@xn--bcher-kva.example", "JANE@XN--BCHER-KVA.EXAMPLE"}
+	want := EmailNormalizeForMatching(forms[0])
+	for _, form := range forms[1:] {
+		if got := EmailNormalizeForMatching(form); got != want {
+			t.Errorf("EmailNormalizeForMatching(%q) = %q, want %q", form, got, want)
+		}
+	}
+
+	// Normalizing is idempotent
+	if again := EmailNormalizeForMatching(want); again != want {
+		t.Errorf("normalizing %q again yields %q", want, again)
+	}
+}
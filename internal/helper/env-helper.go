@@ -31,16 +31,38 @@ func GetEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func GetEnvFloat(key string, defaultVal float64) float64 {
+	if valStr := os.Getenv(key); valStr != "" {
+		val, err := strconv.ParseFloat(valStr, 64)
+
+		if err == nil {
+			return val
+		}
+
+		log.Warnf("helpers.GetEnvFloat: Environment variable '%s' is not a valid number: %v. Using default: %g", key, err, defaultVal)
+	}
+
+	return defaultVal
+}
+
 func GetEnvStringArray(key string, defaultVal []string, sep string, to_lower bool) []string {
 	if valStr := os.Getenv(key); valStr != "" {
 		parts := strings.Split(valStr, sep)
-		for i, part := range parts {
-			parts[i] = strings.TrimSpace(part)
+		values := make([]string, 0, len(parts))
+
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				// Skip empty entries (e.g. of a trailing separator) like GetEnvStringSet
+				continue
+			}
 			if to_lower {
-				parts[i] = strings.ToLower(parts[i])
+				part = strings.ToLower(part)
 			}
+			values = append(values, part)
 		}
-		return parts
+
+		return values
 	}
 
 	return defaultVal
@@ -53,6 +75,10 @@ func GetEnvStringSet(key string, defaultVal map[string]struct{}, sep string, to_
 
 		for _, part := range parts {
 			part = strings.TrimSpace(part)
+			if part == "" {
+				// Skip empty entries (e.g. of a trailing separator), which would never match anything
+				continue
+			}
 			if to_lower {
 				part = strings.ToLower(part)
 			}
@@ -0,0 +1,96 @@
+package helper
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemJSONContentType is the media type of RFC 7807 problem details.
+const ProblemJSONContentType = "application/problem+json"
+
+// ErrorCode is a stable, machine-readable identifier of an error, so clients can branch on it
+// instead of on the (human-readable) message.
+type ErrorCode string
+
+// The error codes returned by the API
+const (
+	// The request is malformed (e.g. invalid JSON, path, or query parameters)
+	ErrorCodeInvalidRequest ErrorCode = "invalid_request"
+	// The request is well-formed but its fields failed validation (details map each field to the failure)
+	ErrorCodeValidationFailed ErrorCode = "validation_failed"
+	// The request is not authenticated or the token is invalid
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+	// The webhook API key is missing or invalid
+	ErrorCodeInvalidApiKey ErrorCode = "invalid_api_key"
+	// The HMAC signature of the webhook request is missing or invalid
+	ErrorCodeInvalidSignature ErrorCode = "invalid_signature"
+	// The authenticated user is not allowed to perform the request
+	ErrorCodeForbidden ErrorCode = "forbidden"
+	// The rule does not exist
+	ErrorCodeRuleNotFound ErrorCode = "rule_not_found"
+	// Another rule already uses the zone pattern
+	ErrorCodeDuplicateZone ErrorCode = "duplicate_zone"
+	// The zone pattern could expand to the same zone as the pattern of other rules
+	ErrorCodeConflictingZone ErrorCode = "conflicting_zone"
+	// A deleted rule still uses the zone pattern (purge or restore it first)
+	ErrorCodeDeletedZone ErrorCode = "deleted_zone"
+	// The rule was changed in the meantime (If-Match or an expected value does not match)
+	ErrorCodePreconditionFailed ErrorCode = "precondition_failed"
+	// The request must be conditional (e.g. If-Match is missing)
+	ErrorCodePreconditionRequired ErrorCode = "precondition_required"
+	// The request contains too many items
+	ErrorCodePayloadTooLarge ErrorCode = "payload_too_large"
+	// Too many requests; retry later
+	ErrorCodeRateLimited ErrorCode = "rate_limited"
+	// No DNS label can be derived from the claims of the user
+	ErrorCodeMissingUserLabel ErrorCode = "missing_user_label"
+	// The rules cannot be reconstructed for the requested point in time
+	ErrorCodeHistoryUnavailable ErrorCode = "history_unavailable"
+	// Webhook evaluation is paused
+	ErrorCodeWebhookPaused ErrorCode = "webhook_paused"
+	// A component required by the request is not configured
+	ErrorCodeNotConfigured ErrorCode = "not_configured"
+	// An unexpected error occurred on the server
+	ErrorCodeInternal ErrorCode = "internal_error"
+)
+
+// APIError is the body of all error responses.
+type APIError struct {
+	Code ErrorCode `json:"code"`
+	// A human-readable description of the error (serialized as "error" for clients of the former format)
+	Message string `json:"error"`
+	// Additional information depending on the code (e.g. the failed fields of a validation error)
+	Details any `json:"details,omitempty"`
+}
+
+// RespondError sends an error response with the given code and aborts the handler chain.
+// See RespondErrorDetails for the format.
+func RespondError(c *gin.Context, status int, code ErrorCode, message string) {
+	RespondErrorDetails(c, status, code, message, nil)
+}
+
+// RespondErrorDetails sends an error response with details and aborts the handler chain. By default the
+// response is an APIError. Clients sending "Accept: application/problem+json" receive RFC 7807 problem
+// details (type, title, status, detail, instance) instead, with the code and details as extension members.
+func RespondErrorDetails(c *gin.Context, status int, code ErrorCode, message string, details any) {
+	if !strings.Contains(c.GetHeader("Accept"), ProblemJSONContentType) {
+		c.AbortWithStatusJSON(status, APIError{Code: code, Message: message, Details: details})
+		return
+	}
+
+	c.Header("Content-Type", ProblemJSONContentType)
+	body := gin.H{
+		"type":     "about:blank",
+		"title":    http.StatusText(status),
+		"status":   status,
+		"detail":   message,
+		"instance": c.Request.URL.Path,
+		"code":     code,
+	}
+	if details != nil {
+		body["details"] = details
+	}
+	c.AbortWithStatusJSON(status, body)
+}
@@ -0,0 +1,78 @@
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// logEmailRegex matches email addresses including internationalized ones (e.g. "jürgen@münchen.de").
+var logEmailRegex = regexp.MustCompile(`[\p{L}\p{N}._%+\-]+@[\p{L}\p{N}\-]+(\.[\p{L}\p{N}\-]+)+`)
+
+// RedactEmail replaces the local part of an email address by a short stable hash, so log entries
+// of the same user can still be correlated (e.g. "user-3f1a9c2b@example.com").
+func RedactEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email
+	}
+
+	hash := sha256.Sum256([]byte(strings.ToLower(email)))
+	return "user-" + hex.EncodeToString(hash[:4]) + email[at:]
+}
+
+// RedactEmails replaces all email addresses in a text using RedactEmail.
+func RedactEmails(text string) string {
+	return logEmailRegex.ReplaceAllStringFunc(text, RedactEmail)
+}
+
+// emailRedactingCore is a zapcore.Core redacting email addresses in log messages and string fields
+// before passing entries on to the wrapped core.
+type emailRedactingCore struct {
+	zapcore.Core
+}
+
+// NewEmailRedactingCore wraps a core so email addresses are redacted in messages and in string,
+// stringer, and error fields. Other field types (e.g. reflected structs) are logged unchanged.
+func NewEmailRedactingCore(core zapcore.Core) zapcore.Core {
+	return &emailRedactingCore{Core: core}
+}
+
+func (c *emailRedactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &emailRedactingCore{Core: c.Core.With(redactFields(fields))}
+}
+
+func (c *emailRedactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *emailRedactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = RedactEmails(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// redactFields returns a copy of the fields with email addresses redacted in textual fields.
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = RedactEmails(field.String)
+		case zapcore.StringerType:
+			field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: RedactEmails(fmt.Sprint(field.Interface))}
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok && err != nil {
+				field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: RedactEmails(err.Error())}
+			}
+		}
+		redacted[i] = field
+	}
+	return redacted
+}
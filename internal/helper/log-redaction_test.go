@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newBufferLogger returns a logger writing JSON entries into the buffer, optionally redacting emails.
+func newBufferLogger(buffer *bytes.Buffer, redact bool) *zap.SugaredLogger {
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(buffer), zapcore.DebugLevel)
+	if redact {
+		core = NewEmailRedactingCore(core)
+	}
+	return zap.New(core).Sugar()
+}
+
+// logWithEmails writes entries containing emails in the message, in context and entry fields, and in errors.
+func logWithEmails(log *zap.SugaredLogger) {
+	log = log.With("user", "Jane.Doe@Example.org")
+	log.Infof("Received user claims for %s", "jane.doe@example.org")
+	log.Warnw("Webhook authentication failed", "actor", "john@sub.example.org", "error", errors.New("unknown user john@sub.example.org"))
+}
+
+func TestEmailRedactingCore(t *testing.T) {
+	var buffer bytes.Buffer
+	logWithEmails(newBufferLogger(&buffer, true))
+
+	output := strings.ToLower(buffer.String())
+	for _, email := range []string{"jane.doe@example.org", "john@sub.example.org"} {
+		if strings.Contains(output, email) {
+			t.Fatalf("raw email '%s' appears in the log:\n%s", email, buffer.String())
+		}
+	}
+
+	// The same user is redacted to the same hash regardless of the case, so entries can be correlated
+	redacted := RedactEmail("jane.doe@example.org")
+	if strings.Count(output, redacted) != 3 {
+		t.Fatalf("expected '%s' three times in the log:\n%s", redacted, buffer.String())
+	}
+}
+
+func TestEmailRedactingCoreDisabled(t *testing.T) {
+	var buffer bytes.Buffer
+	logWithEmails(newBufferLogger(&buffer, false))
+
+	if !strings.Contains(buffer.String(), "john@sub.example.org") {
+		t.Fatalf("expected raw emails without redaction:\n%s", buffer.String())
+	}
+}
+
+func TestRedactEmail(t *testing.T) {
+	redacted := RedactEmail("Jane@example.org")
+	if !strings.HasPrefix(redacted, "user-") || !strings.HasSuffix(redacted, "@example.org") || strings.Contains(redacted, "Jane") {
+		t.Fatalf("unexpected redaction '%s'", redacted)
+	}
+	if RedactEmail("jane@example.org") != redacted {
+		t.Fatal("the redaction must not depend on the case of the email")
+	}
+	if RedactEmail("no-email") != "no-email" {
+		t.Fatal("text without an email must not be changed")
+	}
+}
+
+func TestRedactEmailsUnicode(t *testing.T) {
+	tests := []struct {
+		text   string
+		domain string
+		leaked []string
+	}{
+		{"login of jürgen@münchen.de", "@münchen.de", []string{"jürgen"}},
+		{"login of jürgen@example.org", "@example.org", []string{"jürgen", "jü"}},
+		{"login of jane@bücher.example", "@bücher.example", []string{"jane"}},
+		{"login of 山田@例え.jp failed", "@例え.jp", []string{"山田"}},
+		{"login of ÉLODIE@Example.org", "@Example.org", []string{"ÉLODIE", "lodie"}},
+	}
+	for _, test := range tests {
+		redacted := RedactEmails(test.text)
+		if !strings.Contains(redacted, "user-") || !strings.Contains(redacted, test.domain) {
+			t.Errorf("expected '%s' to be redacted keeping the domain, got '%s'", test.text, redacted)
+		}
+		for _, part := range test.leaked {
+			if strings.Contains(redacted, part) {
+				t.Errorf("'%s' leaks '%s' in '%s'", test.text, part, redacted)
+			}
+		}
+	}
+
+	// The surrounding text is kept
+	if got := RedactEmails("login of 山田@例え.jp failed"); !strings.HasPrefix(got, "login of user-") || !strings.HasSuffix(got, "@例え.jp failed") {
+		t.Fatalf("unexpected redaction '%s'", got)
+	}
+}
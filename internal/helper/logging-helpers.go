@@ -11,7 +11,9 @@ import (
 	"go.uber.org/zap/zapcore"
 )
 
-func InitLogger(dev_mode bool) (logger *zap.Logger, log *zap.SugaredLogger) {
+// InitLogger creates the application logger. With redactEmails, email addresses are replaced by
+// stable hashes in all log entries (see NewEmailRedactingCore).
+func InitLogger(dev_mode bool, redactEmails bool) (logger *zap.Logger, log *zap.SugaredLogger) {
 	var err error
 
 	if dev_mode {
@@ -40,6 +42,10 @@ func InitLogger(dev_mode bool) (logger *zap.Logger, log *zap.SugaredLogger) {
 		panic(fmt.Errorf("failed to initialize logger: %w", err))
 	}
 
+	if redactEmails {
+		logger = logger.WithOptions(zap.WrapCore(NewEmailRedactingCore))
+	}
+
 	log = logger.Sugar()
 
 	return logger, log
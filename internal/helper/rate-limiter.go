@@ -0,0 +1,114 @@
+package helper
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minRateLimitCleanupInterval bounds how often idle buckets are searched for with fast refill rates.
+const minRateLimitCleanupInterval = time.Minute
+
+// RateLimiter limits the request rate per key (e.g. per user or client IP) with a token bucket per key.
+// Each bucket holds up to burst tokens and is refilled at rps tokens per second; a request takes one token.
+// Buckets are kept in memory, and idle buckets are removed periodically, so memory does not grow with
+// the number of distinct keys ever seen.
+type RateLimiter struct {
+	rps   float64
+	burst float64
+	// Buckets idle for longer than this are full again and can be dropped
+	idleAfter time.Duration
+	now       func() time.Time
+
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+// tokenBucket is the state of one key. The tokens are only updated when the key is used.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rps requests per second per key with bursts of up to burst
+// requests. A burst below 1 is raised to 1.
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	burst = max(burst, 1)
+	return &RateLimiter{
+		rps:       rps,
+		burst:     float64(burst),
+		idleAfter: time.Duration(float64(burst) / rps * float64(time.Second)),
+		now:       time.Now,
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token from the bucket of the key. If the bucket is empty, it returns false and how long
+// to wait until the next token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.cleanup(now)
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	// Refill the tokens accumulated since the last request
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rps)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rps * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Middleware returns a Gin middleware rejecting requests with 429 and a Retry-After header once the bucket
+// of the key returned by keyFunc is empty.
+func (l *RateLimiter) Middleware(keyFunc func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, wait := l.Allow(keyFunc(c))
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			RespondErrorDetails(c, http.StatusTooManyRequests, ErrorCodeRateLimited, "Rate limit exceeded, retry later", gin.H{"retry_after_seconds": retryAfter})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Len returns the number of buckets currently kept in memory.
+func (l *RateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// cleanup removes the buckets that have been refilled completely since their last use, as they do not
+// differ from a new bucket. It runs at most once per refill period (or minute) and must be called with the lock held.
+func (l *RateLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < max(l.idleAfter, minRateLimitCleanupInterval) {
+		return
+	}
+	l.lastCleanup = now
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= l.idleAfter {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package helper
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRateLimiter creates a limiter whose clock only advances when the returned function is called.
+func newTestRateLimiter(rps float64, burst int) (*RateLimiter, func(time.Duration)) {
+	now := time.Unix(1_000_000, 0)
+	limiter := NewRateLimiter(rps, burst)
+	limiter.now = func() time.Time { return now }
+	return limiter, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter, advance := newTestRateLimiter(2, 3)
+
+	// The burst is allowed, the next request has to wait for a token
+	for i := range 3 {
+		if allowed, _ := limiter.Allow("jane"); !allowed {
+			t.Fatalf("expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	if allowed, wait := limiter.Allow("jane"); allowed || wait != 500*time.Millisecond {
+		t.Fatalf("expected the request to be limited for 500ms, got allowed %v and wait %v", allowed, wait)
+	}
+
+	// Other keys have their own bucket
+	if allowed, _ := limiter.Allow("john"); !allowed {
+		t.Fatal("expected the request of another key to be allowed")
+	}
+
+	// One token is refilled per 500ms
+	advance(500 * time.Millisecond)
+	if allowed, _ := limiter.Allow("jane"); !allowed {
+		t.Fatal("expected the request to be allowed after the refill")
+	}
+	if allowed, _ := limiter.Allow("jane"); allowed {
+		t.Fatal("expected the refilled token to be used up")
+	}
+
+	// After being idle, the bucket is full again and the idle buckets are dropped
+	advance(2 * time.Minute)
+	if allowed, _ := limiter.Allow("jane"); !allowed {
+		t.Fatal("expected the request to be allowed after the reset")
+	}
+	if limiter.Len() != 1 {
+		t.Fatalf("expected the idle bucket to be dropped, got %d buckets", limiter.Len())
+	}
+	for i := range 2 {
+		if allowed, _ := limiter.Allow("jane"); !allowed {
+			t.Fatalf("expected request %d of the reset burst to be allowed", i+2)
+		}
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter, advance := newTestRateLimiter(0.5, 1)
+	router := gin.New()
+	router.Use(limiter.Middleware(func(c *gin.Context) string { return c.GetHeader("X-Key") }))
+	router.GET("/", func(c *gin.Context) { c.Status(200) })
+	request := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request("jane"); w.Code != 200 {
+		t.Fatalf("expected the first request to be allowed, got %d", w.Code)
+	}
+	w := request("jane")
+	if w.Code != 429 || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("expected 429 with Retry-After 2, got %d with '%s'", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := request("john"); w.Code != 200 {
+		t.Fatalf("expected the request of another key to be allowed, got %d", w.Code)
+	}
+
+	advance(2 * time.Second)
+	if w := request("jane"); w.Code != 200 {
+		t.Fatalf("expected the request to be allowed after Retry-After, got %d", w.Code)
+	}
+}
@@ -0,0 +1,34 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CaptureRequestBody reads the start of the request body and restores it, so it can still be bound afterwards.
+// At most maxBytes of the body are returned; truncated reports whether the body was longer. Only maxBytes+1
+// bytes are read ahead; the rest of the body is left to the binding, which enforces its own limits.
+func CaptureRequestBody(c *gin.Context, maxBytes int) (body []byte, truncated bool, err error) {
+	if c.Request.Body == nil {
+		return nil, false, nil
+	}
+
+	head, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, false, err
+	}
+	c.Request.Body = restoredBody{Reader: io.MultiReader(bytes.NewReader(head), c.Request.Body), Closer: c.Request.Body}
+
+	if len(head) > maxBytes {
+		return head[:maxBytes], true, nil
+	}
+	return head, false, nil
+}
+
+// restoredBody is a request body whose captured start is read again before the rest of the original body.
+type restoredBody struct {
+	io.Reader
+	io.Closer
+}
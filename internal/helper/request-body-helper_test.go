@@ -0,0 +1,61 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
+
+func TestCaptureRequestBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantCaptured  string
+		wantTruncated bool
+	}{
+		{"short body", `{"a":1}`, `{"a":1}`, false},
+		{"body of exactly the limit", strings.Repeat("x", 16), strings.Repeat("x", 16), false},
+		{"long body", strings.Repeat("x", 1024), strings.Repeat("x", 16), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := &countingReader{Reader: strings.NewReader(test.body)}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", "/", io.NopCloser(source))
+
+			captured, truncated, err := CaptureRequestBody(c, 16)
+			if err != nil {
+				t.Fatalf("CaptureRequestBody failed: %v", err)
+			}
+			if string(captured) != test.wantCaptured || truncated != test.wantTruncated {
+				t.Fatalf("expected %q (truncated: %v), got %q (truncated: %v)", test.wantCaptured, test.wantTruncated, captured, truncated)
+			}
+			// The body is not buffered beyond the limit
+			if source.read > 17 {
+				t.Fatalf("expected at most 17 bytes to be read ahead, got %d", source.read)
+			}
+
+			// The whole body can still be read afterwards
+			restored, err := io.ReadAll(c.Request.Body)
+			if err != nil || !bytes.Equal(restored, []byte(test.body)) {
+				t.Fatalf("expected the restored body to equal the original body, got %d bytes (%v)", len(restored), err)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// RequestIDContextKey is the Gin context key holding the ID of the current request.
+const RequestIDContextKey = "request_id"
+
+// requestIDKey is the key of the request ID in the context of the HTTP request, so code only
+// receiving c.Request.Context() (e.g. the zone evaluation) can log it as well.
+type requestIDKey struct{}
+
+const (
+	// RequestIDPolicyTrust accepts a well-formed request ID supplied by the client (or an upstream proxy)
+	// and only generates one if none was sent.
+	RequestIDPolicyTrust = "trust"
+	// RequestIDPolicyGenerate ignores any client-supplied value and always generates a fresh ID.
+	RequestIDPolicyGenerate = "generate"
+)
+
+// Client-supplied IDs are restricted to a short, log-safe character set so they cannot inject
+// line breaks or control characters into log output.
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// RequestIDMiddleware returns a Gin middleware assigning an ID to every request. The ID is stored
+// in the Gin context under RequestIDContextKey (and in the request context) and echoed back in the
+// given response header.
+//
+// Trusting client-supplied IDs lets the ID correlate logs across an upstream proxy or gateway, but
+// any client can then choose the ID that appears in our logs, e.g. to impersonate another request.
+// Use RequestIDPolicyGenerate unless the header is set (and overwritten) by a trusted proxy.
+// Malformed client values are always replaced by a generated ID.
+func RequestIDMiddleware(header string, policy string) gin.HandlerFunc {
+	trustClient := policy == RequestIDPolicyTrust
+
+	return func(c *gin.Context) {
+		id := ""
+		if trustClient {
+			if clientID := c.GetHeader(header); requestIDRegex.MatchString(clientID) {
+				id = clientID
+			}
+		}
+		if id == "" {
+			id = NewRequestID()
+		}
+
+		c.Set(RequestIDContextKey, id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+		c.Header(header, id)
+		c.Next()
+	}
+}
+
+// RequestID returns the ID assigned to the request by RequestIDMiddleware, or "" if there is none.
+func RequestID(c *gin.Context) string {
+	return c.GetString(RequestIDContextKey)
+}
+
+// RequestIDFromContext returns the ID of the request from a Gin context or the context of an
+// HTTP request, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	if c, ok := ctx.(*gin.Context); ok {
+		return RequestID(c)
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestLogger returns the logger with the ID of the request (see RequestIDFromContext) attached to
+// every entry, so all log lines of a request can be correlated. Without a request ID, the logger is
+// returned unchanged.
+func RequestLogger(ctx context.Context, log *zap.SugaredLogger) *zap.SugaredLogger {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return log.With("request_id", id)
+	}
+	return log
+}
+
+// NewRequestID generates a random (version 4) UUID to identify a request.
+func NewRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Request IDs only correlate log entries, so a non-cryptographic ID is an acceptable fallback
+		return RandomString(32)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
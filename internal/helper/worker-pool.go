@@ -0,0 +1,57 @@
+package helper
+
+import (
+	"context"
+	"sync"
+)
+
+// ForEachConcurrently calls fn for the indices 0 to n-1 using at most workers goroutines. Callers keep
+// the result order by writing the result of index i to position i of a preallocated slice.
+// The first error cancels the context passed to the remaining calls, no further indices are started,
+// and that error is returned once all running calls have finished.
+func ForEachConcurrently(ctx context.Context, n int, workers int, fn func(ctx context.Context, i int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	workers = min(workers, n)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	// Hand out the indices until all are done or a call failed
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
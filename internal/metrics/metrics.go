@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// Namespace prefixes the names of all metrics.
+const Namespace = "dns_api"
+
+// PoolStatsFunc returns the current statistics of the database connection pool.
+type PoolStatsFunc func() (sql.DBStats, error)
+
+// Metrics holds the Prometheus metrics of the application in its own registry, so only these
+// metrics (and the Go runtime and process metrics) are exposed.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	webhookZones    prometheus.Counter
+	webhookSkipped  prometheus.Counter
+	ruleExpiresIn   *prometheus.GaugeVec
+	rulesExpiring   prometheus.Gauge
+	// The label values (ID and SOA) of the rule expiry gauges set by the last update
+	ruleExpiryLabels map[[2]string]struct{}
+}
+
+// New creates the metrics. The connection pool gauges are read from poolStats on every scrape.
+func New(poolStats PoolStatsFunc) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by method, route, and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by method, route, and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		webhookZones: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "webhook_zone_expansions_total",
+			Help:      "Number of zones expanded from zone patterns and returned by the webhook.",
+		}),
+		webhookSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "webhook_rules_skipped_total",
+			Help:      "Number of invalid rules skipped during zone evaluation.",
+		}),
+		ruleExpiresIn: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "policy_rule_expires_in_seconds",
+			Help:      "Time until a rule expires, by rule ID and SOA (only rules with an expiry that have not expired yet).",
+		}, []string{"id", "soa"}),
+		rulesExpiring: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "policy_rules_expiring",
+			Help:      "Number of rules expiring within the configured warning window.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.webhookZones,
+		m.webhookSkipped,
+		m.ruleExpiresIn,
+		m.rulesExpiring,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		poolGauge(poolStats, "db_open_connections", "Number of established database connections (in use and idle).",
+			func(stats sql.DBStats) int { return stats.OpenConnections }),
+		poolGauge(poolStats, "db_idle_connections", "Number of idle database connections.",
+			func(stats sql.DBStats) int { return stats.Idle }),
+		poolGauge(poolStats, "db_in_use_connections", "Number of database connections currently in use.",
+			func(stats sql.DBStats) int { return stats.InUse }),
+	)
+
+	return m
+}
+
+// poolGauge creates a gauge reading a value of the connection pool statistics on every scrape.
+// It reports -1 if the statistics are not available.
+func poolGauge(poolStats PoolStatsFunc, name string, help string, value func(sql.DBStats) int) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: Namespace, Name: name, Help: help}, func() float64 {
+		stats, err := poolStats()
+		if err != nil {
+			return -1
+		}
+		return float64(value(stats))
+	})
+}
+
+// Middleware returns a Gin middleware counting the requests and measuring their latency. Requests are
+// labeled with the route pattern (e.g. "/v1/policies/rules/:id") rather than the path, so the number of
+// label values stays bounded; requests matching no route are labeled "unmatched".
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		m.requests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.requestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// WebhookRuleSkipped counts an invalid rule skipped during zone evaluation. It does nothing on nil metrics (metrics disabled).
+func (m *Metrics) WebhookRuleSkipped() {
+	if m == nil {
+		return
+	}
+	m.webhookSkipped.Inc()
+}
+
+// ObserveNotifierFailures exposes the number of events the notifier failed to deliver, read from failedCount
+// on every scrape. It does nothing on nil metrics (metrics disabled).
+func (m *Metrics) ObserveNotifierFailures(failedCount func() uint64) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "notifier_failures_total",
+		Help:      "Number of policy change events that could not be delivered to the notifier sink.",
+	}, func() float64 {
+		return float64(failedCount())
+	}))
+}
+
+// Handler returns the HTTP handler exposing the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WebhookZonesExpanded counts zones returned by the webhook. It does nothing on nil metrics (metrics disabled).
+func (m *Metrics) WebhookZonesExpanded(count int) {
+	if m == nil {
+		return
+	}
+	m.webhookZones.Add(float64(count))
+}
+
+// RuleExpiry is the expiry time of a rule.
+type RuleExpiry struct {
+	ID        int64
+	Soa       string
+	ExpiresAt time.Time
+}
+
+// RuleExpiriesFunc returns the expiry times of the rules expiring after the given time.
+type RuleExpiriesFunc func(ctx context.Context, after time.Time) ([]RuleExpiry, error)
+
+// ObserveRuleExpiries updates the rule expiry metrics now and then every interval until the context is done.
+// Rules expiring within the window are counted as expiring. It does nothing on nil metrics (metrics disabled).
+func (m *Metrics) ObserveRuleExpiries(ctx context.Context, ruleExpiries RuleExpiriesFunc, interval time.Duration, window time.Duration, log *zap.SugaredLogger) {
+	if m == nil {
+		return
+	}
+
+	update := func() {
+		now := time.Now()
+		expiries, err := ruleExpiries(ctx, now)
+		if err != nil {
+			// Keep the previous values, which are at most one interval old
+			log.Warnf("metrics.ObserveRuleExpiries: Failed to retrieve the rule expiries: %v", err)
+			return
+		}
+		m.setRuleExpiries(expiries, now, window)
+	}
+
+	update()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				update()
+			}
+		}
+	}()
+}
+
+// setRuleExpiries replaces the rule expiry metrics with the given expiries. The gauges of rules that
+// expired or lost their expiry since the last update are removed, so the number of series stays bounded
+// by the number of rules that will expire.
+func (m *Metrics) setRuleExpiries(expiries []RuleExpiry, now time.Time, window time.Duration) {
+	labels := make(map[[2]string]struct{}, len(expiries))
+	expiring := 0
+	for _, expiry := range expiries {
+		expiresIn := expiry.ExpiresAt.Sub(now)
+		if expiresIn <= 0 {
+			continue
+		}
+		id := strconv.FormatInt(expiry.ID, 10)
+		m.ruleExpiresIn.WithLabelValues(id, expiry.Soa).Set(expiresIn.Seconds())
+		labels[[2]string{id, expiry.Soa}] = struct{}{}
+		if expiresIn <= window {
+			expiring++
+		}
+	}
+	m.rulesExpiring.Set(float64(expiring))
+
+	for previous := range m.ruleExpiryLabels {
+		if _, ok := labels[previous]; !ok {
+			m.ruleExpiresIn.DeleteLabelValues(previous[0], previous[1])
+		}
+	}
+	m.ruleExpiryLabels = labels
+}
@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testPoolStats returns fixed connection pool statistics.
+func testPoolStats() (sql.DBStats, error) {
+	return sql.DBStats{OpenConnections: 3, Idle: 2, InUse: 1}, nil
+}
+
+// scrape returns the metrics exposed by m in the Prometheus text format.
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 200 {
+		t.Fatalf("scraping the metrics failed with status %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestObserveNotifierFailures(t *testing.T) {
+	m := New(testPoolStats)
+	var failed uint64
+	m.ObserveNotifierFailures(func() uint64 { return failed })
+
+	if body := scrape(t, m); !strings.Contains(body, "dns_api_notifier_failures_total 0\n") {
+		t.Fatalf("expected no notifier failures, got:\n%s", body)
+	}
+
+	// The counter is read on every scrape
+	failed = 4
+	if body := scrape(t, m); !strings.Contains(body, "dns_api_notifier_failures_total 4\n") {
+		t.Fatalf("expected 4 notifier failures, got:\n%s", body)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveNotifierFailures(func() uint64 { return 1 })
+	m.WebhookZonesExpanded(1)
+	m.WebhookRuleSkipped()
+	m.ObserveRuleExpiries(context.Background(), nil, time.Minute, time.Hour, zap.NewNop().Sugar())
+}
+
+func TestRuleExpiryMetrics(t *testing.T) {
+	m := New(testPoolStats)
+	now := time.Now()
+	m.setRuleExpiries([]RuleExpiry{
+		{ID: 1, Soa: "a.example.org", ExpiresAt: now.Add(-time.Minute)},
+		{ID: 2, Soa: "a.example.org", ExpiresAt: now.Add(30 * time.Minute)},
+		{ID: 3, Soa: "b.example.org", ExpiresAt: now.Add(2 * time.Hour)},
+	}, now, time.Hour)
+
+	body := scrape(t, m)
+	for _, line := range []string{
+		`dns_api_policy_rule_expires_in_seconds{id="2",soa="a.example.org"} 1800` + "\n",
+		`dns_api_policy_rule_expires_in_seconds{id="3",soa="b.example.org"} 7200` + "\n",
+		"dns_api_policy_rules_expiring 1\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected '%s', got:\n%s", strings.TrimSpace(line), body)
+		}
+	}
+	// Expired rules are not exported
+	if strings.Contains(body, `id="1"`) {
+		t.Fatalf("unexpected gauge of an expired rule:\n%s", body)
+	}
+
+	// Rules that expired or lost their expiry since the last update are removed
+	m.setRuleExpiries([]RuleExpiry{{ID: 3, Soa: "b.example.org", ExpiresAt: now.Add(2 * time.Hour)}}, now.Add(time.Hour), time.Hour)
+	body = scrape(t, m)
+	if strings.Contains(body, `id="2"`) || !strings.Contains(body, `dns_api_policy_rule_expires_in_seconds{id="3",soa="b.example.org"} 3600`+"\n") {
+		t.Fatalf("expected only the gauge of rule 3, got:\n%s", body)
+	}
+	if !strings.Contains(body, "dns_api_policy_rules_expiring 1\n") {
+		t.Fatalf("expected rule 3 to be expiring, got:\n%s", body)
+	}
+}
+
+func TestObserveRuleExpiries(t *testing.T) {
+	m := New(testPoolStats)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first update succeeds, all later ones fail
+	var calls atomic.Int32
+	ruleExpiries := func(ctx context.Context, after time.Time) ([]RuleExpiry, error) {
+		if calls.Add(1) > 1 {
+			return nil, errors.New("database is down")
+		}
+		return []RuleExpiry{{ID: 7, Soa: "a.example.org", ExpiresAt: after.Add(time.Minute)}}, nil
+	}
+	m.ObserveRuleExpiries(ctx, ruleExpiries, 5*time.Millisecond, time.Hour, zap.NewNop().Sugar())
+
+	// The metrics are updated immediately and then periodically
+	if calls.Load() != 1 {
+		t.Fatalf("expected an immediate update, got %d", calls.Load())
+	}
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected periodic updates, got %d", calls.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Failed updates keep the previous values
+	if body := scrape(t, m); !strings.Contains(body, `dns_api_policy_rule_expires_in_seconds{id="7",soa="a.example.org"}`) {
+		t.Fatalf("expected the gauge of rule 7, got:\n%s", body)
+	}
+}
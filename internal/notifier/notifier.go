@@ -0,0 +1,123 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"go.uber.org/zap"
+)
+
+// Event types emitted by the notifier
+const (
+	EventRuleCreated = "cloud.self-service.policy.rule.created"
+	EventRuleUpdated = "cloud.self-service.policy.rule.updated"
+	EventRuleDeleted = "cloud.self-service.policy.rule.deleted"
+	// Emitted when a soft-deleted rule is restored
+	EventRuleRestored = "cloud.self-service.policy.rule.restored"
+	// Synthetic event to test the connectivity to the sink
+	EventNotifierTest = "cloud.self-service.notifier.test"
+	// Emitted when a rule is merged into another rule (and deleted)
+	EventRulesMerged = "cloud.self-service.policy.rules.merged"
+	// Emitted once for a bulk change of the enabled state of rules
+	EventRulesEnabledChanged = "cloud.self-service.policy.rules.enabled-changed"
+)
+
+// Event is a CloudEvents 1.0 event in structured JSON mode.
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            any       `json:"data,omitempty"`
+}
+
+// Notifier sends events about policy changes to an HTTP sink.
+type Notifier struct {
+	url    string
+	source string
+	client *http.Client
+	log    *zap.SugaredLogger
+	failed atomic.Uint64
+}
+
+// NewNotifier creates a notifier posting to the given sink URL.
+// Each outbound call is bounded by the given timeout.
+func NewNotifier(url string, source string, timeout time.Duration, log *zap.SugaredLogger) *Notifier {
+	return &Notifier{
+		url:    url,
+		source: source,
+		client: &http.Client{Timeout: timeout},
+		log:    log,
+	}
+}
+
+// NewEvent creates an event of the given type carrying data.
+func (n *Notifier) NewEvent(eventType string, data any) Event {
+	return Event{
+		SpecVersion:     "1.0",
+		ID:              helper.RandomString(24),
+		Source:          n.source,
+		Type:            eventType,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// Notify sends an event asynchronously so the caller is never delayed by the sink.
+// Failures are logged and counted but never returned. Calling Notify on a nil
+// Notifier (no sink configured) is a no-op.
+func (n *Notifier) Notify(eventType string, data any) {
+	if n == nil {
+		return
+	}
+
+	event := n.NewEvent(eventType, data)
+	go func() {
+		if _, err := n.Send(context.Background(), event); err != nil {
+			n.failed.Add(1)
+			n.log.Warnf("notifier.Notify: Failed to deliver event '%s' (%s): %v", event.Type, event.ID, err)
+		}
+	}()
+}
+
+// Send delivers an event synchronously and returns the HTTP status code of the sink.
+func (n *Notifier) Send(ctx context.Context, event Event) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post event to sink: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("sink responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// FailedCount returns the number of events that could not be delivered.
+func (n *Notifier) FailedCount() uint64 {
+	if n == nil {
+		return 0
+	}
+	return n.failed.Load()
+}
@@ -0,0 +1,103 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// waitFor polls condition until it holds or the timeout expires.
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return condition()
+}
+
+func TestNotifyDoesNotBlockOnSlowSink(t *testing.T) {
+	release := make(chan struct{})
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer sink.Close()
+	defer close(release)
+
+	n := NewNotifier(sink.URL, "https://api.example.org", 200*time.Millisecond, zap.NewNop().Sugar())
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		n.Notify(EventRuleCreated, map[string]int{"id": i})
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Notify blocked for %v on a slow sink", elapsed)
+	}
+
+	// All deliveries time out and are counted as failed
+	if !waitFor(t, 5*time.Second, func() bool { return n.FailedCount() == 3 }) {
+		t.Fatalf("expected 3 failed deliveries, got %d", n.FailedCount())
+	}
+}
+
+func TestNotifyDeliversEvent(t *testing.T) {
+	received := make(chan Event, 1)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/cloudevents+json" {
+			t.Errorf("unexpected content type '%s'", contentType)
+		}
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("failed to decode event: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+		received <- event
+	}))
+	defer sink.Close()
+
+	n := NewNotifier(sink.URL, "https://api.example.org", time.Second, zap.NewNop().Sugar())
+	n.Notify(EventRuleDeleted, map[string]int{"id": 7})
+
+	select {
+	case event := <-received:
+		if event.Type != EventRuleDeleted || event.Source != "https://api.example.org" || event.SpecVersion != "1.0" || event.ID == "" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sink did not receive the event")
+	}
+	if n.FailedCount() != 0 {
+		t.Fatalf("expected no failed deliveries, got %d", n.FailedCount())
+	}
+}
+
+func TestSendReportsSinkErrors(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer sink.Close()
+
+	n := NewNotifier(sink.URL, "https://api.example.org", time.Second, zap.NewNop().Sugar())
+	status, err := n.Send(t.Context(), n.NewEvent(EventNotifierTest, nil))
+	if err == nil || status != http.StatusServiceUnavailable {
+		t.Fatalf("expected an error with status 503, got %d (%v)", status, err)
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	n.Notify(EventRuleCreated, nil)
+	if n.FailedCount() != 0 {
+		t.Fatal("a nil notifier must not count failures")
+	}
+}
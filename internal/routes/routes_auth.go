@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/farberg/cloud-self-service-api/internal/auth"
+	"github.com/farberg/cloud-self-service-api/internal/config"
+	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"github.com/gin-gonic/gin"
+)
+
+// AuthConfigResponse contains the public (non-secret) parameters a client needs to start an OIDC login.
+type AuthConfigResponse struct {
+	// The OIDC issuer URL
+	IssuerURL string `json:"issuer_url"`
+	// The OIDC client ID of the web front-end (the first configured client ID)
+	ClientID string `json:"client_id"`
+	// The base URL of this web server (including the base path, if any)
+	WebserverBaseUrl string `json:"webserver_base_url"`
+	// Whether the ID token can be exchanged for a session cookie at /v1/auth/session
+	SessionsEnabled bool `json:"sessions_enabled"`
+}
+
+// CreateAuthApiGroup sets up the /auth API group and its routes.
+func CreateAuthApiGroup(group *gin.RouterGroup, app *config.AppData) *gin.RouterGroup {
+	// Assuming the group is mounted at /v1/auth
+	group.GET("/config", getAuthConfig(app))
+
+	return group
+}
+
+// getAuthConfig returns the public OIDC configuration.
+// @Summary Get the OIDC login configuration
+// @Description Returns the public OIDC parameters (issuer URL, client ID) and the web server base URL so clients can configure a login flow. No authentication required.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} AuthConfigResponse "The public OIDC configuration"
+// @Router /v1/auth/config [get]
+func getAuthConfig(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, AuthConfigResponse{
+			IssuerURL:        app.Config.WebServer.OIDCIssuerURL,
+			ClientID:         app.Config.WebServer.PrimaryOIDCClientID(),
+			WebserverBaseUrl: app.Config.WebServer.PublicBaseUrl(),
+			SessionsEnabled:  app.Config.WebServer.SessionsEnabled,
+		})
+	}
+}
+
+// CreateSessionRoutes sets up the routes exchanging an ID token for a session cookie (login),
+// returning the session user, and removing the cookie (logout).
+func CreateSessionRoutes(group *gin.RouterGroup, app *config.AppData, verifier *auth.OIDCAuthVerifier, sessions *auth.SessionManager) *gin.RouterGroup {
+	// Assuming the group is mounted at /v1/auth
+	group.POST("/session", createSession(app, verifier, sessions))
+	group.GET("/session", getSession(sessions))
+	group.DELETE("/session", deleteSession(sessions))
+
+	return group
+}
+
+// createSession verifies the ID token and issues a session cookie for its user.
+// @Summary Create a session (login)
+// @Description Verifies the ID token in the Authorization header and sets a secure, HttpOnly session cookie, so browser clients do not need to keep the token in JavaScript. Only available if sessions are enabled.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} auth.UserClaims "The user of the new session"
+// @Failure 401 {object} helper.APIError "Missing or invalid ID token"
+// @Security ApiKeyAuth
+// @Router /v1/auth/session [post]
+func createSession(app *config.AppData, verifier *auth.OIDCAuthVerifier, sessions *auth.SessionManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := helper.RequestLogger(c, app.Log)
+		rawIDToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || rawIDToken == "" {
+			helper.AuthFailureDelay(c.Request.Context(), app.Config.WebServer.AuthFailureDelay())
+			helper.RespondError(c, http.StatusUnauthorized, helper.ErrorCodeUnauthorized, "Bearer token required")
+			return
+		}
+
+		claims, err := verifier.VerifyToken(c.Request.Context(), rawIDToken)
+		if err != nil {
+			log.Warnf("Failed to verify ID token for session login: %v", err)
+			helper.AuthFailureDelay(c.Request.Context(), app.Config.WebServer.AuthFailureDelay())
+			helper.RespondError(c, http.StatusUnauthorized, helper.ErrorCodeUnauthorized, err.Error())
+			return
+		}
+
+		if err := sessions.Issue(c, claims); err != nil {
+			log.Errorf("Failed to issue session cookie: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to create session")
+			return
+		}
+		c.JSON(http.StatusOK, claims)
+	}
+}
+
+// getSession returns the user of the current session.
+// @Summary Get the current session
+// @Description Returns the user of the session cookie, so browser clients can check whether they are logged in. Only available if sessions are enabled.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} auth.UserClaims "The user of the session"
+// @Failure 401 {object} helper.APIError "No valid session"
+// @Router /v1/auth/session [get]
+func getSession(sessions *auth.SessionManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := sessions.Validate(c)
+		if err != nil {
+			helper.RespondError(c, http.StatusUnauthorized, helper.ErrorCodeUnauthorized, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, claims)
+	}
+}
+
+// deleteSession removes the session cookie.
+// @Summary Delete the current session (logout)
+// @Description Removes the session cookie. Since sessions are stateless, a copy of the cookie stays valid until it expires. Only available if sessions are enabled.
+// @Tags auth
+// @Success 204 "Session cookie removed"
+// @Failure 403 {object} helper.APIError "Cross-origin request"
+// @Router /v1/auth/session [delete]
+func deleteSession(sessions *auth.SessionManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := sessions.CheckOrigin(c); err != nil {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, err.Error())
+			return
+		}
+		sessions.Clear(c)
+		c.Status(http.StatusNoContent)
+	}
+}
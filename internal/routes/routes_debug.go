@@ -0,0 +1,121 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/farberg/cloud-self-service-api/internal/auth"
+	"github.com/farberg/cloud-self-service-api/internal/config"
+	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"github.com/gin-gonic/gin"
+)
+
+// EvaluateTokenRequest contains the raw bearer token to evaluate.
+type EvaluateTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// EvaluateTokenResponse is the result of an end-to-end evaluation of a token.
+type EvaluateTokenResponse struct {
+	// True if the token passed the OIDC verification
+	Valid bool `json:"valid"`
+	// The verification error (only set if the token is invalid)
+	Error string `json:"error,omitempty"`
+	// The claims extracted from the token
+	Claims *auth.UserClaims `json:"claims,omitempty"`
+	// The zones the user would get from the webhook (including the generating rule)
+	Zones []ZoneResponse `json:"zones"`
+	// The rules that did not apply to the user and why (only returned to SuperAdmins)
+	RejectedRules []RejectedRule `json:"rejected_rules,omitempty"`
+	// The point in time the rules were evaluated at (only set if requested)
+	At *time.Time `json:"at,omitempty"`
+}
+
+// CreateDebugApiGroup sets up the /debug API group and its routes.
+func CreateDebugApiGroup(group *gin.RouterGroup, app *config.AppData, verifier *auth.OIDCAuthVerifier) *gin.RouterGroup {
+	// Assuming the group is mounted at /v1/debug
+	group.POST("/evaluate-token", evaluateToken(app, verifier))
+
+	return group
+}
+
+// evaluateToken runs the full auth stack for a token (dev mode or super-admin only).
+// @Summary Evaluate a bearer token end-to-end
+// @Description Verifies the given token, extracts its claims, and returns the zones the user would get. Only available in development mode or to SuperAdmins, since it echoes token contents.
+// @Description SuperAdmins additionally receive the rules that did not apply to the user, each with the reason.
+// @Description With at, the rules are evaluated as they were at that time, reconstructed from the audit log (the token is still verified now).
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param token body EvaluateTokenRequest true "The token to evaluate"
+// @Param at query string false "Evaluate the rules as they were at this time (RFC 3339, e.g. 2024-05-01T12:00:00Z)"
+// @Success 200 {object} EvaluateTokenResponse "The verification result, claims, and zones"
+// @Failure 400 {object} helper.APIError "Invalid request payload or time"
+// @Failure 403 {object} helper.APIError "Forbidden: Not in development mode and not a SuperAdmin"
+// @Failure 422 {object} helper.APIError "No DNS label can be derived for the user, or the rule history does not reach back to the requested time"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/debug/evaluate-token [post]
+func evaluateToken(app *config.AppData, verifier *auth.OIDCAuthVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !app.Config.DevMode && !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can evaluate tokens outside development mode")
+			return
+		}
+
+		var req EvaluateTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid request payload")
+			return
+		}
+		at, err := parseEvaluationTime(c)
+		if err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+
+		// Verify the token (a failure is part of the result, not an error of this endpoint)
+		claims, err := verifier.VerifyToken(c.Request.Context(), req.Token)
+		if err != nil {
+			c.JSON(http.StatusOK, EvaluateTokenResponse{Valid: false, Error: err.Error(), Zones: []ZoneResponse{}})
+			return
+		}
+
+		// Evaluate the zones for the extracted claims
+		response, err := evaluateClaims(c.Request.Context(), app, claims, at)
+		if err != nil {
+			respondEvaluationError(c, app, err)
+			return
+		}
+		// The rejected rules reveal the full rule set
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			response.RejectedRules = nil
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// evaluateClaims returns the evaluation of verified claims, using the zone evaluation of the webhook
+// (see resolveUserZones). Each zone includes the generating rule.
+func evaluateClaims(ctx context.Context, app *config.AppData, claims *auth.UserClaims, at time.Time) (EvaluateTokenResponse, error) {
+	matches, evaluations, err := resolveUserZones(ctx, app, claims, at)
+	if err != nil {
+		return EvaluateTokenResponse{}, err
+	}
+
+	zones := make([]ZoneResponse, 0, len(matches))
+	for _, match := range matches {
+		zone := match.Zone
+		key := match.RuleKey
+		zone.RuleID = &key
+		zones = append(zones, zone)
+	}
+
+	response := EvaluateTokenResponse{Valid: true, Claims: claims, Zones: zones, RejectedRules: rejectedRules(evaluations)}
+	if !at.IsZero() {
+		response.At = &at
+	}
+	return response, nil
+}
@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/farberg/cloud-self-service-api/internal/auth"
+	"github.com/farberg/cloud-self-service-api/internal/storage"
+)
+
+func TestEvaluateClaimsMatchesWebhook(t *testing.T) {
+	app := newTestApp(t)
+	app.Config.DnsPolicyConfig.WebhookIncludeRuleID = true
+	app.Config.DnsPolicyConfig.FallbackZonePattern = "%u.fallback.example.org"
+	app.Config.DnsPolicyConfig.FallbackZoneSOA = "fallback.example.org"
+	// Two rules producing the same zone for jane, and a rule that does not apply
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "jane.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "jane@example.org"})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.staff.example.org", ZoneSoa: "staff.example.org", TargetUserFilter: "*@staff.example.org"})
+	router := newTestRouter(app)
+
+	for _, claims := range []auth.UserClaims{{Email: "jane@example.org"}, {Email: "joe@other.org"}} {
+		t.Run(claims.Email, func(t *testing.T) {
+			response, err := evaluateClaims(context.Background(), app, &claims, time.Time{})
+			if err != nil {
+				t.Fatalf("evaluateClaims failed: %v", err)
+			}
+			webhookZones := callWebhook(t, router, "", claims)
+			if len(webhookZones) != 1 || !reflect.DeepEqual(response.Zones, webhookZones) {
+				t.Fatalf("expected the zones of the webhook %+v, got %+v", webhookZones, response.Zones)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package routes
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/farberg/cloud-self-service-api/internal/auth"
+	"github.com/farberg/cloud-self-service-api/internal/config"
+	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"github.com/farberg/cloud-self-service-api/internal/notifier"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateDiagnosticsApiGroup sets up the /diagnostics API group and its routes.
+func CreateDiagnosticsApiGroup(group *gin.RouterGroup, app *config.AppData) *gin.RouterGroup {
+	// Assuming the group is mounted at /v1/diagnostics
+	group.GET("/schema", getSchemaStatus(app))
+	group.GET("/versions", getVersions(app))
+	group.POST("/write-check", postWriteCheck(app))
+	group.POST("/notify-test", postNotifyTest(app))
+
+	return group
+}
+
+// getSchemaStatus returns the state of the database schema (super-admin only).
+// @Summary Get the database schema status
+// @Description Reports whether all tables, columns, and indexes of the storage models exist and which schema migrations are applied. Read-only. Only SuperAdmins are authorized.
+// @Tags diagnostics
+// @Produce json
+// @Success 200 {object} storage.SchemaStatus "The schema status"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/diagnostics/schema [get]
+func getSchemaStatus(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can access diagnostics")
+			return
+		}
+
+		status, err := app.Storage.SchemaStatus()
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Warnf("Failed to determine schema status: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to determine schema status")
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+// versionedModules lists the dependencies whose versions are reported by the diagnostics.
+var versionedModules = []string{
+	"github.com/gin-gonic/gin",
+	"gorm.io/gorm",
+	"gorm.io/driver/sqlite",
+	"gorm.io/driver/postgres",
+	"gorm.io/driver/mysql",
+	"github.com/coreos/go-oidc",
+}
+
+// VersionsResponse contains the versions of the application, its key dependencies, and the database server.
+type VersionsResponse struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	// The versions of key Go modules by module path ("unknown" if not available in the build info)
+	Dependencies map[string]string `json:"dependencies"`
+	DatabaseType string            `json:"database_type"`
+	// The version reported by the database server (empty if it could not be queried)
+	DatabaseVersion string `json:"database_version"`
+}
+
+// getVersions returns the versions of the application components (super-admin only).
+// @Summary Get component versions
+// @Description Reports the versions of the application, key dependencies (Gin, GORM, go-oidc), and the database server. Only SuperAdmins are authorized.
+// @Tags diagnostics
+// @Produce json
+// @Success 200 {object} VersionsResponse "The component versions"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Security ApiKeyAuth
+// @Router /v1/diagnostics/versions [get]
+func getVersions(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can access diagnostics")
+			return
+		}
+
+		dependencies := make(map[string]string, len(versionedModules))
+		for _, module := range versionedModules {
+			dependencies[module] = "unknown"
+		}
+		if buildInfo, ok := debug.ReadBuildInfo(); ok {
+			for _, dep := range buildInfo.Deps {
+				if _, wanted := dependencies[dep.Path]; wanted {
+					dependencies[dep.Path] = dep.Version
+				}
+			}
+		}
+
+		// A missing database version should not hide the other versions
+		dbVersion, err := app.Storage.DatabaseVersion()
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Warnf("Failed to determine database version: %v", err)
+		}
+
+		c.JSON(http.StatusOK, VersionsResponse{
+			Version:         appVersion(),
+			GoVersion:       runtime.Version(),
+			Dependencies:    dependencies,
+			DatabaseType:    app.Storage.DatabaseType(),
+			DatabaseVersion: dbVersion,
+		})
+	}
+}
+
+// WriteCheckResponse reports the result of a database write check.
+type WriteCheckResponse struct {
+	Writable   bool    `json:"writable"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// postWriteCheck verifies that the database accepts writes (super-admin only).
+// @Summary Check database write capability
+// @Description Inserts a probe rule in a transaction that is rolled back, to detect databases that only accept reads (e.g. after a failover). Only SuperAdmins are authorized.
+// @Tags diagnostics
+// @Produce json
+// @Success 200 {object} WriteCheckResponse "The database accepts writes"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 503 {object} WriteCheckResponse "The database does not accept writes"
+// @Security ApiKeyAuth
+// @Router /v1/diagnostics/write-check [post]
+func postWriteCheck(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can access diagnostics")
+			return
+		}
+
+		duration, err := app.Storage.WriteCheckCtx(c.Request.Context())
+		response := WriteCheckResponse{
+			Writable:   err == nil,
+			DurationMs: float64(duration.Microseconds()) / 1000,
+		}
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Errorf("Database write check failed: %v", err)
+			response.Error = err.Error()
+			c.JSON(http.StatusServiceUnavailable, response)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// NotifyTestResponse reports the result of sending a test event to the notifier sink.
+type NotifyTestResponse struct {
+	Delivered bool   `json:"delivered"`
+	EventID   string `json:"event_id"`
+	// The HTTP status code of the sink (0 if no response was received)
+	StatusCode int     `json:"status_code"`
+	LatencyMs  float64 `json:"latency_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// postNotifyTest sends a synthetic event to the notifier sink (super-admin only).
+// @Summary Test the notifier sink
+// @Description Sends a synthetic test event to the configured notifier sink and reports the HTTP status, latency, and error. The notifier timeout applies. Only SuperAdmins are authorized.
+// @Tags diagnostics
+// @Produce json
+// @Success 200 {object} NotifyTestResponse "The sink accepted the event"
+// @Failure 400 {object} helper.APIError "No notifier is configured"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 502 {object} NotifyTestResponse "The sink could not be reached or rejected the event"
+// @Security ApiKeyAuth
+// @Router /v1/diagnostics/notify-test [post]
+func postNotifyTest(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can access diagnostics")
+			return
+		}
+
+		if app.Notifier == nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeNotConfigured, "No notifier is configured (set DNS_POLICY_NOTIFIER_URL)")
+			return
+		}
+
+		event := app.Notifier.NewEvent(notifier.EventNotifierTest, gin.H{"requested_by": user.Email})
+		start := time.Now()
+		statusCode, err := app.Notifier.Send(c.Request.Context(), event)
+
+		response := NotifyTestResponse{
+			Delivered:  err == nil,
+			EventID:    event.ID,
+			StatusCode: statusCode,
+			LatencyMs:  float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Warnf("Notifier test event '%s' failed: %v", event.ID, err)
+			response.Error = err.Error()
+			c.JSON(http.StatusBadGateway, response)
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/farberg/cloud-self-service-api/internal/config"
+	"github.com/farberg/cloud-self-service-api/internal/health"
+	"github.com/gin-gonic/gin"
+)
+
+// ReadinessResponse reports whether the application is ready to serve requests.
+type ReadinessResponse struct {
+	Ready    bool          `json:"ready"`
+	Database health.Status `json:"database"`
+}
+
+// HealthResponse reports the result of a direct database check.
+type HealthResponse struct {
+	Status string `json:"status"`
+	// The configured database type (e.g. "postgres")
+	DbType string `json:"db_type"`
+	// The reason the database is not reachable (only set if unhealthy)
+	Error string `json:"error,omitempty"`
+}
+
+// The maximum time the health probe waits for the database
+const healthCheckTimeout = 2 * time.Second
+
+// CreateHealthRoutes sets up the health probe routes.
+func CreateHealthRoutes(group *gin.RouterGroup, app *config.AppData) *gin.RouterGroup {
+	group.GET("/healthz", getHealth(app))
+	group.GET("/readyz", getReadiness(app))
+
+	return group
+}
+
+// getReadiness reports whether the database is available.
+// @Summary Readiness probe
+// @Description Reports whether the database is available. With the background health sweep enabled, the result of the last sweep is returned; otherwise the database is checked on each request. Unauthenticated.
+// @Tags diagnostics
+// @Produce json
+// @Success 200 {object} ReadinessResponse "The application is ready"
+// @Failure 503 {object} ReadinessResponse "The database is not available"
+// @Router /readyz [get]
+func getReadiness(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var status health.Status
+		if app.Health.SweepEnabled() {
+			status = app.Health.Status()
+		} else {
+			status = app.Health.Check(c.Request.Context())
+		}
+
+		response := ReadinessResponse{Ready: status.Healthy, Database: status}
+		if !status.Healthy {
+			c.JSON(http.StatusServiceUnavailable, response)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// getHealth pings the database on each request.
+// @Summary Database health probe
+// @Description Pings the database (bounded by a 2 second timeout) and reports whether it is reachable, independent of the background health sweep. Unauthenticated.
+// @Tags diagnostics
+// @Produce json
+// @Success 200 {object} HealthResponse "The database is reachable"
+// @Failure 503 {object} HealthResponse "The database is not reachable"
+// @Router /healthz [get]
+func getHealth(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+		defer cancel()
+
+		response := HealthResponse{Status: "ok", DbType: app.Config.Storage.DbType}
+		if err := app.Storage.Ping(ctx); err != nil {
+			response.Status = "unavailable"
+			response.Error = err.Error()
+			c.JSON(http.StatusServiceUnavailable, response)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -1,17 +1,29 @@
 package routes
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"net/mail"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/farberg/cloud-self-service-api/internal/auth"
 	"github.com/farberg/cloud-self-service-api/internal/config"
 	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"github.com/farberg/cloud-self-service-api/internal/notifier"
 	"github.com/farberg/cloud-self-service-api/internal/storage"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/net/idna"
 	"gorm.io/gorm"
 )
 
@@ -21,12 +33,178 @@ type PolicyRuleRequest struct {
 	ZoneSoa          string `json:"zone_soa" binding:"required"`
 	TargetUserFilter string `json:"target_user_filter" binding:"required"`
 	Description      string `json:"description"`
+	IncludeWww       bool   `json:"include_www"`
+	// Whether users may manage the zones of the rule or only view them (default: manage)
+	AccessLevel string `json:"access_level" binding:"omitempty,oneof=manage view"`
+	// Optional time after which the rule no longer applies (must be in the future; default: the rule does not expire)
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// accessLevel returns the requested access level, defaulting to manage.
+func (req *PolicyRuleRequest) accessLevel() string {
+	if req.AccessLevel == "" {
+		return storage.AccessLevelManage
+	}
+	return req.AccessLevel
+}
+
+// RenamePatternRequest is used to change the zone pattern of a rule.
+type RenamePatternRequest struct {
+	ZonePattern string `json:"zone_pattern" binding:"required"`
+}
+
+// SetEnabledRequest enables or disables all rules matching the filter (at least one criterion is required).
+type SetEnabledRequest struct {
+	ZoneSoa string `json:"zone_soa"`
+	// The keys of the rules (IDs, or UUIDs if DNS_POLICY_RULE_KEY_TYPE is uuid)
+	IDs     []RuleKey `json:"ids" swaggertype:"array,string"`
+	Enabled *bool     `json:"enabled" binding:"required"`
+	// If set, only the number of rules that would change is returned
+	DryRun bool `json:"dry_run"`
+}
+
+// MergeRequest merges the rule merge_id into the rule keep_id, which gets the combined user filter.
+// The rules are given by their keys (IDs, or UUIDs if DNS_POLICY_RULE_KEY_TYPE is uuid).
+type MergeRequest struct {
+	KeepID         *RuleKey `json:"keep_id" binding:"required" swaggertype:"string"`
+	MergeID        *RuleKey `json:"merge_id" binding:"required" swaggertype:"string"`
+	CombinedFilter string   `json:"combined_filter" binding:"required"`
+}
+
+// SetEnabledResponse reports how many rules changed (or would change in a dry run).
+type SetEnabledResponse struct {
+	Changed int64 `json:"changed"`
+	DryRun  bool  `json:"dry_run"`
 }
 
 // RulesResponse wraps policy rules for list endpoint.
 type RulesResponse struct {
-	EditAllowed bool                 `json:"edit_allowed"`
-	Rules       []storage.PolicyRule `json:"rules"`
+	EditAllowed bool `json:"edit_allowed"`
+	// SuperAdmins receive full rules, other users only the fields configured as visible to them
+	Rules []any `json:"rules" swaggertype:"array,object"`
+	// The value of the "after" parameter to fetch the next page (only set for keyset pagination if more rules may follow)
+	NextCursor *int64 `json:"next_cursor,omitempty"`
+	// The total number of rules visible to the user (only set for page-based pagination)
+	Total *int64 `json:"total,omitempty"`
+}
+
+// The maximum number of rules per page
+const maxPageLimit = 1000
+
+// The maximum length of the search text of the rule list
+const maxSearchQueryLength = 255
+
+// The default and maximum number of rules per page of page-based pagination
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// CompareRequest holds the claims of the two users whose zone entitlements are compared.
+type CompareRequest struct {
+	Left  auth.UserClaims `json:"left"`
+	Right auth.UserClaims `json:"right"`
+}
+
+// AuditFiltersRequest lists the email domains that still have active users (e.g. "dhbw.de").
+type AuditFiltersRequest struct {
+	ActiveDomains []string `json:"active_domains" binding:"required,min=1,dive,required"`
+}
+
+// AuditedRule is a rule whose target user filter matches none of the active domains.
+type AuditedRule struct {
+	RuleID           RuleKey `json:"rule_id" swaggertype:"string"`
+	ZonePattern      string  `json:"zone_pattern"`
+	TargetUserFilter string  `json:"target_user_filter"`
+}
+
+// AuditFiltersResponse reports the likely dead rules among all checked rules.
+type AuditFiltersResponse struct {
+	// The number of rules checked
+	Checked int `json:"checked"`
+	// The rules whose filter matches none of the active domains
+	Unmatched []AuditedRule `json:"unmatched"`
+}
+
+// AuditLogResponse contains one page of the audit log, newest entry first.
+type AuditLogResponse struct {
+	Entries []storage.AuditLog `json:"entries"`
+	// The total number of entries
+	Total   int64 `json:"total"`
+	Page    int   `json:"page"`
+	PerPage int   `json:"per_page"`
+}
+
+// The maximum number of rules accepted by a single import
+const maxImportRules = 5000
+
+// The outcomes of importing a rule
+const (
+	importStatusCreated   = "created"
+	importStatusInvalid   = "invalid"
+	importStatusDuplicate = "duplicate"
+	importStatusConflict  = "conflict"
+	importStatusDeleted   = "deleted"
+	importStatusAborted   = "aborted"
+)
+
+// deletedZonePatternMessage is the error message if a deleted rule still uses the zone pattern of a request.
+const deletedZonePatternMessage = "A deleted rule still uses this zone pattern; purge or restore the deleted rule first"
+
+// ImportResult is the outcome of importing one rule.
+type ImportResult struct {
+	// The index of the rule in the request
+	Index int `json:"index"`
+	// "created", "invalid", "duplicate" (the zone pattern exists), "conflict" (the zone pattern conflicts with
+	// the pattern of another rule), "deleted" (a deleted rule still uses the zone pattern), or "aborted"
+	// (not created because the import was aborted)
+	Status string `json:"status"`
+	// The created rule (only set if created)
+	Rule *RuleResponse `json:"rule,omitempty"`
+	// The fields that failed validation (only set if invalid)
+	Fields []config.FieldError `json:"fields,omitempty"`
+}
+
+// ImportResponse reports the outcome of an import for each rule in request order.
+type ImportResponse struct {
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Results []ImportResult `json:"results"`
+}
+
+// ZoneDifference is a zone that only one side of a comparison is entitled to.
+type ZoneDifference struct {
+	Zone    string    `json:"zone"`
+	ZoneSOA string    `json:"zone_soa"`
+	RuleIDs []RuleKey `json:"rule_ids" swaggertype:"array,string"`
+}
+
+// CompareResponse contains the symmetric difference of the zones of two users.
+type CompareResponse struct {
+	// The keys of all rules that matched the left/right user
+	LeftRuleIDs  []RuleKey `json:"left_rule_ids" swaggertype:"array,string"`
+	RightRuleIDs []RuleKey `json:"right_rule_ids" swaggertype:"array,string"`
+	// The zones only the left/right user is entitled to, including the rules causing them
+	OnlyLeft  []ZoneDifference `json:"only_left"`
+	OnlyRight []ZoneDifference `json:"only_right"`
+}
+
+// PreviewZone is a zone the webhook would return for a user, together with the rule producing it.
+type PreviewZone struct {
+	Zone        string `json:"zone"`
+	ZoneSOA     string `json:"zone_soa"`
+	AccessLevel string `json:"access_level"`
+	// The key of the rule producing the zone (0 for the fallback zone)
+	RuleID RuleKey `json:"rule_id" swaggertype:"string"`
+}
+
+// PreviewResponse contains the zones the webhook would return for a user.
+type PreviewResponse struct {
+	Email string `json:"email"`
+	// The zones sorted by name (empty if no rule applies to the user)
+	Zones []PreviewZone `json:"zones"`
+	// The point in time the rules were evaluated at (only set if requested)
+	At *time.Time `json:"at,omitempty"`
 }
 
 type ZoneResponse struct {
@@ -34,286 +212,1970 @@ type ZoneResponse struct {
 	Zone string `json:"zone"`
 	// The zone name from which on this nameserver is authoritative (e.g., "users.example.com")
 	ZoneSOA string `json:"zone_soa"`
+	// The key of the rule that generated this zone (only set if enabled in the configuration)
+	RuleID *RuleKey `json:"rule_id,omitempty" swaggertype:"string"`
+	// Whether the user may manage the zone or only view it ("manage" or "view")
+	AccessLevel string `json:"access_level"`
+	// The description of the rule that generated this zone (only set if requested)
+	Description string `json:"description,omitempty"`
+}
+
+// ChecksumResponse contains the checksum of all policy rules.
+type ChecksumResponse struct {
+	// The SHA-256 checksum over the semantically meaningful fields of all rules in ID order (hex-encoded)
+	Checksum string `json:"checksum"`
+}
+
+// RuleResponse is the representation of a rule for SuperAdmins.
+type RuleResponse struct {
+	// The ID of the rule (omitted if DNS_POLICY_RULE_KEY_TYPE is uuid, so only the UUID identifies the rule).
+	// It hides the ID of the embedded rule, as it is less deeply nested.
+	ID *int64 `json:"id,omitempty"`
+	storage.PolicyRule
+	// The checksum of the rule (only set if requested)
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// newRuleResponse returns the representation of a rule for SuperAdmins according to the configured key type.
+func newRuleResponse(policyConfig config.DnsPolicyConfig, rule storage.PolicyRule) *RuleResponse {
+	response := &RuleResponse{PolicyRule: rule}
+	if policyConfig.RuleKeyType != "uuid" {
+		response.ID = &rule.ID
+	}
+	return response
 }
 
 // CreatePolicyApiGroup sets up the /policies API group and its routes.
 func CreatePolicyApiGroup(group *gin.RouterGroup, app *config.AppData) *gin.RouterGroup {
 	// Assuming the group is mounted at /v1/policies
+	group.Use(auditActorMiddleware())
 	group.GET("/rules", listPolicyRules(app))
 	group.POST("/rules", createPolicyRule(app))
+	group.GET("/rules/:id", getPolicyRule(app))
 	group.PUT("/rules/:id", updatePolicyRule(app))
 	group.DELETE("/rules/:id", deletePolicyRule(app))
+	group.POST("/rules/:id/restore", restorePolicyRule(app))
+	group.PUT("/rules/:id/pattern", renamePolicyRulePattern(app))
+	group.POST("/rules/:id/approve", approvePolicyRule(app))
+	group.POST("/rules/:id/reject", rejectPolicyRule(app))
+	group.POST("/merge", mergePolicyRules(app))
+	group.POST("/audit-filters", auditPolicyFilters(app))
+	group.GET("/audit", listAuditLog(app))
+	group.POST("/import", importPolicyRules(app))
+	group.GET("/export", exportPolicyRules(app))
+	group.POST("/set-enabled", setPolicyRulesEnabled(app))
+	group.POST("/compare", comparePolicyZones(app))
+	group.GET("/preview", previewUserZones(app))
+	group.GET("/soas", listPolicySOAs(app))
+	group.GET("/stats/by-soa", countPolicyRulesBySOA(app))
+	group.GET("/checksum", getPolicyChecksum(app))
+	group.GET("/webhook/paused", getWebhookPaused(app))
+	group.PUT("/webhook/paused", setWebhookPaused(app))
+
+	return group
+}
+
+func listUserRules(ctx context.Context, app *config.AppData, user *auth.UserClaims, is_super_admin bool, order storage.ListOrder) ([]storage.PolicyRule, error) {
+	// Get all rules from storage
+	rules, err := app.Storage.PolicyGetAllSortedCtx(ctx, order)
+	if err != nil {
+		// Log the error (not shown here)
+		return nil, err
+	}
+
+	return filterUserRules(rules, user, is_super_admin), nil
+}
+
+// filterUserRules returns the rules whose user filter matches the user (super admins get all rules).
+func filterUserRules(rules []storage.PolicyRule, user *auth.UserClaims, is_super_admin bool) []storage.PolicyRule {
+	// Filter the rules based on user email
+	if !is_super_admin {
+		filteredRules := make([]storage.PolicyRule, 0)
+		for _, rule := range rules {
+			if MatchesUserFilter(rule.TargetUserFilter, user) {
+				filteredRules = append(filteredRules, rule)
+			}
+		}
+		rules = filteredRules
+	}
+
+	return rules
+}
+
+// isValidRuleStatus reports whether the value is a known approval status.
+func isValidRuleStatus(status string) bool {
+	switch status {
+	case storage.RuleStatusPending, storage.RuleStatusApproved, storage.RuleStatusRejected:
+		return true
+	}
+	return false
+}
+
+// filterRulesByStatus returns the rules with the given approval status.
+func filterRulesByStatus(rules []storage.PolicyRule, status string) []storage.PolicyRule {
+	filteredRules := make([]storage.PolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Status == status {
+			filteredRules = append(filteredRules, rule)
+		}
+	}
+	return filteredRules
+}
+
+// userRuleView returns the reduced representation of a rule for a non-SuperAdmin, containing only
+// the visible fields. The owner email is only included if the user is the owner.
+func userRuleView(rule storage.PolicyRule, user *auth.UserClaims, policyConfig config.DnsPolicyConfig) (map[string]any, error) {
+	ruleJson, err := json.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(ruleJson, &fields); err != nil {
+		return nil, err
+	}
+
+	for field := range fields {
+		if _, visible := policyConfig.UserVisibleRuleFields[field]; !visible {
+			delete(fields, field)
+		}
+	}
+	if !strings.EqualFold(rule.OwnerEmail, user.Email) {
+		delete(fields, "owner_email")
+	}
+	// Only the UUID identifies the rule in uuid mode
+	if policyConfig.RuleKeyType == "uuid" {
+		delete(fields, "id")
+	}
+
+	return fields, nil
+}
+
+// pagination holds the pagination parameters of a list request.
+type pagination struct {
+	Limit int
+	// The cursor for keyset pagination (nil for offset pagination)
+	After  *int64
+	Offset int
+}
+
+// parsePagination parses the limit, after, and offset parameters. It returns nil if no pagination is requested.
+func parsePagination(c *gin.Context, order storage.ListOrder) (*pagination, error) {
+	limitStr, hasLimit := c.GetQuery("limit")
+	afterStr, hasAfter := c.GetQuery("after")
+	offsetStr, hasOffset := c.GetQuery("offset")
+	if !hasLimit && !hasAfter && !hasOffset {
+		return nil, nil
+	}
+
+	if !hasLimit {
+		return nil, errors.New("limit is required for pagination")
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return nil, fmt.Errorf("invalid limit (expected 1 to %d)", maxPageLimit)
+	}
+	page := &pagination{Limit: limit}
+
+	if hasAfter && hasOffset {
+		return nil, errors.New("after and offset cannot be combined")
+	}
+	if hasAfter {
+		if order != "" && order != storage.ListOrderIDAsc {
+			return nil, errors.New("keyset pagination (after) only supports the id_asc order")
+		}
+		after, err := strconv.ParseInt(afterStr, 10, 64)
+		if err != nil || after < 0 {
+			return nil, errors.New("invalid after cursor (expected a rule ID)")
+		}
+		page.After = &after
+	} else if hasOffset {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return nil, errors.New("invalid offset (expected a non-negative number)")
+		}
+		page.Offset = offset
+	} else {
+		// A limit alone starts keyset pagination at the beginning if the order allows it
+		if order == "" || order == storage.ListOrderIDAsc {
+			var start int64
+			page.After = &start
+		}
+	}
+
+	return page, nil
+}
+
+// listRulesPage retrieves one page of rules. For keyset pagination, it also returns the cursor of the
+// next page if the page is full.
+func listRulesPage(ctx context.Context, app *config.AppData, page *pagination, order storage.ListOrder) ([]storage.PolicyRule, *int64, error) {
+	if page.After == nil {
+		rules, err := app.Storage.PolicyGetPageCtx(ctx, page.Offset, page.Limit, order)
+		return rules, nil, err
+	}
+
+	rules, err := app.Storage.PolicyGetAfterCtx(ctx, *page.After, page.Limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var nextCursor *int64
+	if len(rules) == page.Limit {
+		nextCursor = &rules[len(rules)-1].ID
+	}
+	return rules, nextCursor, nil
+}
+
+// parsePolicyFilter parses the search parameters zone_soa, target_filter, and q. It returns nil if no
+// search is requested.
+func parsePolicyFilter(c *gin.Context, order storage.ListOrder) (*storage.PolicyFilter, error) {
+	filter := &storage.PolicyFilter{
+		ZoneSoa:          strings.TrimSpace(c.Query("zone_soa")),
+		TargetUserFilter: strings.TrimSpace(c.Query("target_filter")),
+		Query:            strings.TrimSpace(c.Query("q")),
+		Order:            order,
+	}
+	if filter.ZoneSoa == "" && filter.TargetUserFilter == "" && filter.Query == "" {
+		return nil, nil
+	}
+
+	if len(filter.Query) > maxSearchQueryLength {
+		return nil, fmt.Errorf("q is too long (at most %d characters)", maxSearchQueryLength)
+	}
+	return filter, nil
+}
+
+// numberedPage holds the parameters of page-based pagination (?page= and ?per_page=).
+type numberedPage struct {
+	Page    int
+	PerPage int
+	SortBy  string
+	SortDir string
+}
+
+// parseNumberedPage parses the page, per_page, sort_by, and sort_dir parameters. It returns nil if no
+// page-based pagination is requested.
+func parseNumberedPage(c *gin.Context) (*numberedPage, error) {
+	pageStr, hasPage := c.GetQuery("page")
+	perPageStr, hasPerPage := c.GetQuery("per_page")
+	if !hasPage && !hasPerPage {
+		return nil, nil
+	}
+
+	page := &numberedPage{
+		Page:    1,
+		PerPage: defaultPerPage,
+		SortBy:  c.DefaultQuery("sort_by", "id"),
+		SortDir: strings.ToLower(c.DefaultQuery("sort_dir", "asc")),
+	}
+	if hasPage {
+		number, err := strconv.Atoi(pageStr)
+		if err != nil || number < 1 {
+			return nil, errors.New("invalid page (expected a positive number)")
+		}
+		page.Page = number
+	}
+	if hasPerPage {
+		perPage, err := strconv.Atoi(perPageStr)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return nil, fmt.Errorf("invalid per_page (expected 1 to %d)", maxPerPage)
+		}
+		page.PerPage = perPage
+	}
+
+	return page, nil
+}
+
+// listRulesNumberedPage retrieves one page of the rules visible to the user and the total number of
+// these rules. SuperAdmins are paged in the database; for other users (or with a status filter) all
+// sorted rules are filtered first, so the total and the page boundaries only count visible rules.
+func listRulesNumberedPage(ctx context.Context, app *config.AppData, user *auth.UserClaims, isSuperAdmin bool, statusFilter string, page *numberedPage) ([]storage.PolicyRule, int64, error) {
+	offset := (page.Page - 1) * page.PerPage
+
+	if isSuperAdmin && statusFilter == "" {
+		return app.Storage.PolicyGetPagedCtx(ctx, offset, page.PerPage, page.SortBy, page.SortDir)
+	}
+
+	rules, _, err := app.Storage.PolicyGetPagedCtx(ctx, 0, 0, page.SortBy, page.SortDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	rules = filterUserRules(rules, user, isSuperAdmin)
+	if statusFilter != "" {
+		rules = filterRulesByStatus(rules, statusFilter)
+	}
+
+	total := int64(len(rules))
+	if offset >= len(rules) {
+		return []storage.PolicyRule{}, total, nil
+	}
+	return rules[offset:min(offset+page.PerPage, len(rules))], total, nil
+}
+
+// listPolicyRules lists all policy rules.
+// @Summary List policy rules
+// @Description List all DNS policy rules. Non-SuperAdmins only see rules matching their user filter.
+// @Description With pagination, rules are filtered per page, so pages of non-SuperAdmins may contain fewer rules than the limit.
+// @Tags policies
+// @Produce json
+// @Param modified_since query string false "Only return rules created or updated at or after this RFC 3339 timestamp"
+// @Param status query string false "Only return rules with this approval status" Enums(pending, approved, rejected)
+// @Param zone_soa query string false "Only return rules with this SOA (case-insensitive)"
+// @Param target_filter query string false "Only return rules with this target user filter (case-insensitive, e.g. *@example.org)"
+// @Param q query string false "Only return rules whose zone pattern or description contains this text (case-insensitive); search parameters are combined with AND and cannot be combined with pagination, modified_since, or include_deleted"
+// @Param sort query string false "The order of the rules (default: DB_DEFAULT_LIST_ORDER); ignored with modified_since, which lists by modification time" Enums(id_asc, created_desc)
+// @Param limit query int false "The maximum number of rules per page (1-1000); enables pagination"
+// @Param after query int false "Keyset pagination: only return rules with a greater ID (use next_cursor of the previous page; ordered by ID)"
+// @Param offset query int false "Offset pagination: skip this many rules (slower for deep pages, pages shift when rules change)"
+// @Param page query int false "Page-based pagination: the page number (default: 1); the response includes the total number of rules"
+// @Param per_page query int false "Page-based pagination: the number of rules per page (1-500, default: 50)"
+// @Param sort_by query string false "Page-based pagination: the sort column (default: id)" Enums(id, zone_pattern, created_at)
+// @Param sort_dir query string false "Page-based pagination: the sort direction (default: asc)" Enums(asc, desc)
+// @Param include_deleted query bool false "Include soft-deleted rules (with deleted_at set); SuperAdmins only, cannot be combined with pagination"
+// @Param include_checksum query bool false "Include the checksum of each rule (see GET /v1/policies/checksum)"
+// @Success 200 {object} RulesResponse "List of policy rules"
+// @Failure 400 {object} helper.APIError "Invalid modified_since timestamp, status, sort order, search, or pagination parameters"
+// @Failure 403 {object} helper.APIError "Forbidden: include_deleted requested by a non-SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/rules [get]
+func listPolicyRules(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := helper.RequestLogger(c, app.Log)
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		is_super_admin := config.IsSuperAdmin(user, app.Config.DnsPolicyConfig)
+
+		statusFilter := c.Query("status")
+		if statusFilter != "" && !isValidRuleStatus(statusFilter) {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid status (expected pending, approved, or rejected)")
+			return
+		}
+
+		order := storage.ListOrder(c.Query("sort"))
+		if order != "" && !order.IsValid() {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid sort order (expected id_asc or created_desc)")
+			return
+		}
+
+		page, err := parsePagination(c, order)
+		if err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+
+		numberedPage, err := parseNumberedPage(c)
+		if err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+		if numberedPage != nil && (page != nil || order != "" || c.Query("modified_since") != "") {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "page and per_page cannot be combined with limit, after, offset, sort, or modified_since")
+			return
+		}
+
+		filter, err := parsePolicyFilter(c, order)
+		if err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+
+		includeDeleted := c.Query("include_deleted") == "true"
+		if filter != nil && (page != nil || numberedPage != nil || includeDeleted || c.Query("modified_since") != "") {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "zone_soa, target_filter, and q cannot be combined with pagination, modified_since, or include_deleted")
+			return
+		}
+		if includeDeleted && !is_super_admin {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can list deleted rules")
+			return
+		}
+		if includeDeleted && (page != nil || numberedPage != nil) {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "include_deleted cannot be combined with pagination")
+			return
+		}
+
+		var rules []storage.PolicyRule
+		var nextCursor *int64
+		var total *int64
+		if modifiedSinceStr := c.Query("modified_since"); modifiedSinceStr != "" {
+			if page != nil {
+				helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "modified_since cannot be combined with pagination")
+				return
+			}
+
+			// Get only the rules modified since the given time (for incremental synchronization)
+			modifiedSince, parseErr := time.Parse(time.RFC3339, modifiedSinceStr)
+			if parseErr != nil {
+				helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid modified_since timestamp (expected RFC 3339)")
+				return
+			}
+
+			if includeDeleted {
+				// Deleted rules are included so the sync job can remove them downstream
+				rules, err = app.Storage.PolicyGetModifiedSinceIncludingDeletedCtx(c.Request.Context(), modifiedSince)
+			} else {
+				rules, err = app.Storage.PolicyGetModifiedSinceCtx(c.Request.Context(), modifiedSince)
+			}
+			if err == nil {
+				rules = filterUserRules(rules, user, is_super_admin)
+			}
+		} else if filter != nil {
+			// Get only the rules matching the search parameters
+			rules, err = app.Storage.PolicySearchCtx(c.Request.Context(), *filter)
+			if err == nil {
+				rules = filterUserRules(rules, user, is_super_admin)
+			}
+		} else if numberedPage != nil {
+			// Get a numbered page of rules including the total number of rules
+			var count int64
+			rules, count, err = listRulesNumberedPage(c.Request.Context(), app, user, is_super_admin, statusFilter, numberedPage)
+			if errors.Is(err, storage.ErrInvalidSort) {
+				helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+				return
+			}
+			total = &count
+		} else if page != nil {
+			// Get a single page of rules
+			rules, nextCursor, err = listRulesPage(c.Request.Context(), app, page, order)
+			if err == nil {
+				rules = filterUserRules(rules, user, is_super_admin)
+			}
+		} else if includeDeleted {
+			// Get all rules including the deleted ones (for auditing)
+			rules, err = app.Storage.PolicyGetAllIncludingDeletedCtx(c.Request.Context())
+		} else {
+			// Get all rules from storage
+			rules, err = listUserRules(c.Request.Context(), app, user, is_super_admin, order)
+		}
+		if err != nil {
+			// Log the error
+			log.Warnf("Failed to retrieve policy rules: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to retrieve rules")
+			return
+		}
+
+		if statusFilter != "" {
+			rules = filterRulesByStatus(rules, statusFilter)
+		}
+
+		// Serialize the rules according to the role of the user
+		includeChecksum := c.Query("include_checksum") == "true"
+		ruleViews := make([]any, 0, len(rules))
+		for _, rule := range rules {
+			if is_super_admin {
+				ruleView := newRuleResponse(app.Config.DnsPolicyConfig, rule)
+				if includeChecksum {
+					ruleView.Checksum = rule.Checksum()
+				}
+				ruleViews = append(ruleViews, ruleView)
+				continue
+			}
+
+			ruleView, err := userRuleView(rule, user, app.Config.DnsPolicyConfig)
+			if err != nil {
+				log.Warnf("Failed to serialize policy rule %d: %v", rule.ID, err)
+				helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to retrieve rules")
+				return
+			}
+			if includeChecksum {
+				ruleView["checksum"] = rule.Checksum()
+			}
+			ruleViews = append(ruleViews, ruleView)
+		}
+
+		// Return the rules
+		log.Debugf("Returning %d policy rules to user %s (super admin: %v)", len(rules), user.Email, is_super_admin)
+		c.JSON(http.StatusOK, RulesResponse{Rules: ruleViews, EditAllowed: is_super_admin, NextCursor: nextCursor, Total: total})
+	}
+}
+
+// listPolicySOAs lists the distinct SOAs used by the policy rules (super-admin only).
+// @Summary List zone SOAs
+// @Description Lists the distinct zone SOAs used by all DNS policy rules in lower case and alphabetical order. Only SuperAdmins are authorized.
+// @Tags policies
+// @Produce json
+// @Success 200 {array} string "The distinct zone SOAs"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/soas [get]
+func listPolicySOAs(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can list SOAs")
+			return
+		}
+
+		soas, err := app.Storage.PolicyGetDistinctSOAsCtx(c.Request.Context())
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Warnf("Failed to retrieve SOAs: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to retrieve SOAs")
+			return
+		}
+
+		c.JSON(http.StatusOK, soas)
+	}
+}
+
+// countPolicyRulesBySOA counts the policy rules per SOA (super-admin only).
+// @Summary Count rules by SOA
+// @Description Returns the number of DNS policy rules per zone SOA (in lower case). Only SuperAdmins are authorized.
+// @Tags policies
+// @Produce json
+// @Success 200 {object} map[string]int64 "The number of rules per SOA"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/stats/by-soa [get]
+func countPolicyRulesBySOA(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can retrieve rule statistics")
+			return
+		}
+
+		counts, err := app.Storage.PolicyCountBySOACtx(c.Request.Context())
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Warnf("Failed to count rules by SOA: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to count rules")
+			return
+		}
+
+		c.JSON(http.StatusOK, counts)
+	}
+}
+
+// getPolicyChecksum returns the checksum of all policy rules (super-admin only).
+// @Summary Get the checksum of all policy rules
+// @Description Returns a stable checksum over the semantically meaningful fields (zone pattern, SOA, user filter, description, include_www, enabled, access level, expiry) of all rules in ID order.
+// @Description Timestamps, IDs, owners, and approval states are excluded, so the checksum can be compared across environments to detect drift. Only SuperAdmins are authorized.
+// @Tags policies
+// @Produce json
+// @Success 200 {object} ChecksumResponse "The checksum of all rules"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/checksum [get]
+func getPolicyChecksum(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can retrieve the rule checksum")
+			return
+		}
+
+		checksum, err := app.Storage.PolicyChecksumCtx(c.Request.Context())
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Warnf("Failed to compute the rule checksum: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to compute the rule checksum")
+			return
+		}
+
+		c.JSON(http.StatusOK, ChecksumResponse{Checksum: checksum})
+	}
+}
+
+// createPolicyRule creates a new policy rule (super-admin only unless user submissions are enabled).
+// @Summary Create a policy rule
+// @Description Creates a new DNS policy rule. Only SuperAdmins are authorized, unless user rule submission is enabled.
+// @Description Rules submitted by other users are pending until a SuperAdmin approves them; rules of SuperAdmins are approved immediately if configured.
+// @Description Other users can create at most DNS_POLICY_MAX_RULE_CREATES_PER_HOUR rules per hour (SuperAdmins are exempt).
+// @Tags policies
+// @Accept json
+// @Produce json
+// @Param rule body PolicyRuleRequest true "Policy rule payload"
+// @Success 201 {object} RuleResponse "The newly created policy rule"
+// @Failure 400 {object} helper.APIError "Invalid request payload"
+// @Failure 422 {object} helper.APIError "Validation error"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 409 {object} helper.APIError "A rule with this zone pattern (or a conflicting one) already exists, or a deleted rule still uses it"
+// @Failure 429 {object} helper.APIError "Too many rules created within the last hour (see Retry-After)"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/rules [post]
+func createPolicyRule(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		is_super_admin := config.IsSuperAdmin(user, app.Config.DnsPolicyConfig)
+
+		if !is_super_admin && !app.Config.DnsPolicyConfig.UserRuleSubmissionEnabled {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can create rules")
+			return
+		}
+
+		rawBody, truncated, ok := captureAuditRequestBody(c)
+		if !ok {
+			return
+		}
+
+		req, ok := bindPolicyRuleRequest(c, app.Config.DnsPolicyConfig)
+		if !ok {
+			return
+		}
+
+		if !is_super_admin && !checkRuleCreateRate(c, app, user) {
+			return
+		}
+
+		newRule := storage.PolicyRule{
+			ZonePattern:      req.ZonePattern,
+			ZoneSoa:          req.ZoneSoa,
+			TargetUserFilter: req.TargetUserFilter,
+			Description:      req.Description,
+			IncludeWww:       req.IncludeWww,
+			AccessLevel:      req.accessLevel(),
+			ExpiresAt:        req.ExpiresAt,
+			OwnerEmail:       user.Email,
+			Status:           storage.RuleStatusPending,
+		}
+		if is_super_admin && app.Config.DnsPolicyConfig.AutoApproveSuperAdminRules {
+			newRule.Status = storage.RuleStatusApproved
+		}
+
+		if !checkZonePatternConflicts(c, app, &newRule) {
+			return
+		}
+
+		createdRule, err := app.Storage.PolicyCreateCtx(c.Request.Context(), &newRule)
+		if err != nil {
+			if errors.Is(err, storage.ErrDuplicateZonePattern) {
+				helper.RespondError(c, http.StatusConflict, helper.ErrorCodeDuplicateZone, "A rule with this zone pattern already exists")
+				return
+			}
+			if errors.Is(err, storage.ErrDeletedZonePattern) {
+				helper.RespondError(c, http.StatusConflict, helper.ErrorCodeDeletedZone, deletedZonePatternMessage)
+				return
+			}
+			helper.RequestLogger(c, app.Log).Warnf("Failed to create policy rule: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to create rule")
+			return
+		}
+
+		auditPolicyChange(c, app, user, "create", createdRule.ID, rawBody, truncated)
+		app.Notifier.Notify(notifier.EventRuleCreated, createdRule)
+		c.Header("ETag", ruleETag(createdRule))
+		c.JSON(http.StatusCreated, newRuleResponse(app.Config.DnsPolicyConfig, *createdRule))
+	}
+}
+
+// checkZonePatternConflicts responds with 409 and the IDs of the rules whose zone pattern could expand to
+// the same zone as the pattern of the rule (see Storage.PolicyFindConflicts) and returns false if there are any.
+func checkZonePatternConflicts(c *gin.Context, app *config.AppData, rule *storage.PolicyRule) bool {
+	conflicts, err := app.Storage.PolicyFindConflictsCtx(c.Request.Context(), rule)
+	if err != nil {
+		helper.RequestLogger(c, app.Log).Warnf("Failed to check for conflicting zone patterns: %v", err)
+		helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to check for conflicting rules")
+		return false
+	}
+	if len(conflicts) == 0 {
+		return true
+	}
+
+	conflictingIDs := make([]RuleKey, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		conflictingIDs = append(conflictingIDs, newRuleKey(app.Config.DnsPolicyConfig, conflict))
+	}
+	helper.RespondErrorDetails(c, http.StatusConflict, helper.ErrorCodeConflictingZone, "The zone pattern conflicts with the zone pattern of other rules", gin.H{"conflicting_rule_ids": conflictingIDs})
+	return false
+}
+
+// checkRuleCreateRate enforces the maximum number of rules a user may create per hour. If the limit is
+// reached, it responds with 429 and a Retry-After header (the time until the oldest create in the window
+// expires) and returns false. Concurrent requests may briefly exceed the limit.
+func checkRuleCreateRate(c *gin.Context, app *config.AppData, user *auth.UserClaims) bool {
+	limit := app.Config.DnsPolicyConfig.MaxRuleCreatesPerHour
+	if limit <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	count, oldest, err := app.Storage.PolicyCountCreatedByOwnerSinceCtx(c.Request.Context(), user.Email, now.Add(-time.Hour))
+	if err != nil {
+		helper.RequestLogger(c, app.Log).Warnf("Failed to count recently created rules: %v", err)
+		helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to create rule")
+		return false
+	}
+	if count < int64(limit) {
+		return true
+	}
+
+	retryAfter := int(math.Ceil(oldest.Add(time.Hour).Sub(now).Seconds()))
+	c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	helper.RespondError(c, http.StatusTooManyRequests, helper.ErrorCodeRateLimited, fmt.Sprintf("At most %d rules can be created per hour", limit))
+	return false
+}
+
+// The header carrying the expected current zone pattern for conditional updates
+const expectedZonePatternHeader = "X-Expected-Zone-Pattern"
+
+// ruleETag returns the entity tag of a rule, which is its quoted version (e.g. "3").
+func ruleETag(rule *storage.PolicyRule) string {
+	return strconv.Quote(strconv.Itoa(rule.Version))
+}
+
+// parseIfMatch parses the rule version from the If-Match header, which must contain a single ETag of a rule.
+// On failure, an error response has already been sent (428 if the header is missing) and false is returned.
+func parseIfMatch(c *gin.Context) (int, bool) {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" {
+		helper.RespondError(c, http.StatusPreconditionRequired, helper.ErrorCodePreconditionRequired, "The If-Match header with the ETag of the rule is required")
+		return 0, false
+	}
+
+	// Unquoted versions are accepted for clients that strip the quotes
+	if unquoted, err := strconv.Unquote(ifMatch); err == nil {
+		ifMatch = unquoted
+	}
+	version, err := strconv.Atoi(ifMatch)
+	if err != nil || version < 1 {
+		helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid If-Match header (expected the ETag of the rule, e.g. \"3\")")
+		return 0, false
+	}
+	return version, true
+}
+
+// getPolicyRule returns a single policy rule.
+// @Summary Get a policy rule
+// @Description Returns a DNS policy rule by ID with its version as ETag (required in If-Match to update the rule). Non-SuperAdmins only get rules matching their user filter, reduced to the visible fields.
+// @Tags policies
+// @Produce json
+// @Param id path string true "Rule ID (the rule UUID if DNS_POLICY_RULE_KEY_TYPE is uuid)"
+// @Success 200 {object} RuleResponse "The policy rule"
+// @Header 200 {string} ETag "The version of the rule"
+// @Failure 400 {object} helper.APIError "Invalid rule ID"
+// @Failure 404 {object} helper.APIError "Rule not found"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/rules/{id} [get]
+func getPolicyRule(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		isSuperAdmin := config.IsSuperAdmin(user, app.Config.DnsPolicyConfig)
+
+		id, ok := parseRuleKey(c, app)
+		if !ok {
+			return
+		}
+
+		rule, err := app.Storage.PolicyGetByIDCtx(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "Rule not found")
+			} else {
+				helper.RequestLogger(c, app.Log).Warnf("Failed to retrieve policy rule %d: %v", id, err)
+				helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to retrieve rule")
+			}
+			return
+		}
+
+		if isSuperAdmin {
+			c.Header("ETag", ruleETag(rule))
+			c.JSON(http.StatusOK, newRuleResponse(app.Config.DnsPolicyConfig, *rule))
+			return
+		}
+
+		// Rules not applying to the user are hidden as if they did not exist
+		if !MatchesUserFilter(rule.TargetUserFilter, user) {
+			helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "Rule not found")
+			return
+		}
+		ruleView, err := userRuleView(*rule, user, app.Config.DnsPolicyConfig)
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Warnf("Failed to serialize policy rule %d: %v", rule.ID, err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to retrieve rule")
+			return
+		}
+		c.Header("ETag", ruleETag(rule))
+		c.JSON(http.StatusOK, ruleView)
+	}
+}
+
+// updatePolicyRule updates an existing policy rule (super-admin only).
+// @Summary Update a policy rule
+// @Description Updates an existing DNS policy rule by ID. Only SuperAdmins are authorized. The If-Match header must contain
+// @Description the current ETag of the rule (see GET /v1/policies/rules/{id}), so concurrent updates cannot overwrite each other.
+// @Tags policies
+// @Accept json
+// @Produce json
+// @Param id path string true "Rule ID (the rule UUID if DNS_POLICY_RULE_KEY_TYPE is uuid)"
+// @Param rule body PolicyRuleRequest true "Policy rule payload"
+// @Param If-Match header string true "The current ETag (version) of the rule"
+// @Param X-Expected-Zone-Pattern header string false "Only update the rule if its current zone pattern equals this value"
+// @Success 200 {object} RuleResponse "The updated policy rule"
+// @Header 200 {string} ETag "The new version of the rule"
+// @Failure 400 {object} helper.APIError "Invalid rule ID, request payload, or If-Match header"
+// @Failure 422 {object} helper.APIError "Validation error"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 404 {object} helper.APIError "Rule not found"
+// @Failure 409 {object} helper.APIError "Another rule already uses this zone pattern or a conflicting one, or a deleted rule still uses it"
+// @Failure 412 {object} helper.APIError "The rule was changed in the meantime (If-Match or X-Expected-Zone-Pattern does not match)"
+// @Failure 428 {object} helper.APIError "The If-Match header is missing"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/rules/{id} [put]
+func updatePolicyRule(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can update rules")
+			return
+		}
+
+		id, ok := parseRuleKey(c, app)
+		if !ok {
+			return
+		}
+		version, ok := parseIfMatch(c)
+		if !ok {
+			return
+		}
+
+		rawBody, truncated, ok := captureAuditRequestBody(c)
+		if !ok {
+			return
+		}
+
+		req, ok := bindPolicyRuleRequest(c, app.Config.DnsPolicyConfig)
+		if !ok {
+			return
+		}
+
+		// Check if rule exists before update attempt
+		existingRule, err := app.Storage.PolicyGetByIDCtx(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "Rule not found")
+			} else {
+				helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to retrieve rule")
+			}
+			return
+		}
+
+		if existingRule.Version != version {
+			helper.RespondError(c, http.StatusPreconditionFailed, helper.ErrorCodePreconditionFailed, "The rule was changed by someone else (version does not match If-Match)")
+			return
+		}
+
+		// Update the fields on the existing rule object
+		existingRule.ZonePattern = req.ZonePattern
+		existingRule.ZoneSoa = req.ZoneSoa
+		existingRule.TargetUserFilter = req.TargetUserFilter
+		existingRule.Description = req.Description
+		existingRule.IncludeWww = req.IncludeWww
+		existingRule.AccessLevel = req.accessLevel()
+		existingRule.ExpiresAt = req.ExpiresAt
+
+		if !checkZonePatternConflicts(c, app, existingRule) {
+			return
+		}
+
+		// Only update if the rule was not changed since it was read (by the client and above)
+		expected := storage.PolicyRule{Version: version}
+		if expectedPattern, ok := c.Request.Header[expectedZonePatternHeader]; ok {
+			expected.ZonePattern = expectedPattern[0]
+		}
+		updatedRule, err := app.Storage.PolicyUpdateIfCtx(c.Request.Context(), id, expected, *existingRule)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "Rule not found")
+				return
+			}
+			if errors.Is(err, storage.ErrPreconditionFailed) {
+				helper.RespondError(c, http.StatusPreconditionFailed, helper.ErrorCodePreconditionFailed, "The rule was changed by someone else (version does not match If-Match or zone pattern does not match "+expectedZonePatternHeader+")")
+				return
+			}
+			if errors.Is(err, storage.ErrDuplicateZonePattern) {
+				helper.RespondError(c, http.StatusConflict, helper.ErrorCodeDuplicateZone, "Another rule already uses this zone pattern")
+				return
+			}
+			if errors.Is(err, storage.ErrDeletedZonePattern) {
+				helper.RespondError(c, http.StatusConflict, helper.ErrorCodeDeletedZone, deletedZonePatternMessage)
+				return
+			}
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to update rule")
+			return
+		}
+
+		auditPolicyChange(c, app, user, "update", updatedRule.ID, rawBody, truncated)
+		app.Notifier.Notify(notifier.EventRuleUpdated, updatedRule)
+		c.Header("ETag", ruleETag(updatedRule))
+		c.JSON(http.StatusOK, newRuleResponse(app.Config.DnsPolicyConfig, *updatedRule))
+	}
+}
+
+// renamePolicyRulePattern changes the zone pattern of a rule (super-admin only).
+// @Summary Rename the zone pattern of a policy rule
+// @Description Atomically changes the zone pattern of an existing DNS policy rule. Only SuperAdmins are authorized.
+// @Tags policies
+// @Accept json
+// @Produce json
+// @Param id path string true "Rule ID (the rule UUID if DNS_POLICY_RULE_KEY_TYPE is uuid)"
+// @Param pattern body RenamePatternRequest true "The new zone pattern"
+// @Success 200 {object} RuleResponse "The updated policy rule"
+// @Failure 400 {object} helper.APIError "Invalid rule ID or request payload"
+// @Failure 422 {object} helper.APIError "Invalid zone pattern"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 404 {object} helper.APIError "Rule not found"
+// @Failure 409 {object} helper.APIError "Another rule already uses the zone pattern or a conflicting one, or a deleted rule still uses it"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/rules/{id}/pattern [put]
+func renamePolicyRulePattern(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can update rules")
+			return
+		}
+
+		id, ok := parseRuleKey(c, app)
+		if !ok {
+			return
+		}
+
+		rawBody, truncated, ok := captureAuditRequestBody(c)
+		if !ok {
+			return
+		}
+
+		var req RenamePatternRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		if fieldError := validateZonePatternField(req.ZonePattern); fieldError != nil {
+			respondValidationErrors(c, []config.FieldError{*fieldError})
+			return
+		}
+
+		if !checkZonePatternConflicts(c, app, &storage.PolicyRule{ID: id, ZonePattern: req.ZonePattern}) {
+			return
+		}
+
+		updatedRule, err := app.Storage.PolicyRenamePatternCtx(c.Request.Context(), id, req.ZonePattern)
+		if err != nil {
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "Rule not found")
+			case errors.Is(err, storage.ErrDuplicateZonePattern):
+				helper.RespondError(c, http.StatusConflict, helper.ErrorCodeDuplicateZone, "Another rule already uses this zone pattern")
+			case errors.Is(err, storage.ErrDeletedZonePattern):
+				helper.RespondError(c, http.StatusConflict, helper.ErrorCodeDeletedZone, deletedZonePatternMessage)
+			default:
+				helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to update rule")
+			}
+			return
+		}
+
+		auditPolicyChange(c, app, user, "rename", updatedRule.ID, rawBody, truncated)
+		app.Notifier.Notify(notifier.EventRuleUpdated, updatedRule)
+		c.JSON(http.StatusOK, newRuleResponse(app.Config.DnsPolicyConfig, *updatedRule))
+	}
+}
+
+// approvePolicyRule approves a rule so it takes effect (super-admin only).
+// @Summary Approve a policy rule
+// @Description Sets the status of a DNS policy rule to approved, so it is used during webhook evaluation. Only SuperAdmins are authorized.
+// @Tags policies
+// @Produce json
+// @Param id path string true "Rule ID (the rule UUID if DNS_POLICY_RULE_KEY_TYPE is uuid)"
+// @Success 200 {object} RuleResponse "The approved policy rule"
+// @Failure 400 {object} helper.APIError "Invalid rule ID"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 404 {object} helper.APIError "Rule not found"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/rules/{id}/approve [post]
+func approvePolicyRule(app *config.AppData) gin.HandlerFunc {
+	return setPolicyRuleStatus(app, storage.RuleStatusApproved)
+}
+
+// rejectPolicyRule rejects a rule so it does not take effect (super-admin only).
+// @Summary Reject a policy rule
+// @Description Sets the status of a DNS policy rule to rejected, so it is ignored during webhook evaluation. Only SuperAdmins are authorized.
+// @Tags policies
+// @Produce json
+// @Param id path string true "Rule ID (the rule UUID if DNS_POLICY_RULE_KEY_TYPE is uuid)"
+// @Success 200 {object} RuleResponse "The rejected policy rule"
+// @Failure 400 {object} helper.APIError "Invalid rule ID"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 404 {object} helper.APIError "Rule not found"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/rules/{id}/reject [post]
+func rejectPolicyRule(app *config.AppData) gin.HandlerFunc {
+	return setPolicyRuleStatus(app, storage.RuleStatusRejected)
+}
+
+// setPolicyRuleStatus creates a handler setting the approval status of a rule.
+func setPolicyRuleStatus(app *config.AppData, status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can approve or reject rules")
+			return
+		}
+
+		id, ok := parseRuleKey(c, app)
+		if !ok {
+			return
+		}
+
+		updatedRule, err := app.Storage.PolicySetStatusCtx(c.Request.Context(), id, status)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "Rule not found")
+				return
+			}
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to update rule")
+			return
+		}
+
+		helper.RequestLogger(c, app.Log).Infof("User '%s' set status of rule %d to '%s'", user.Email, id, status)
+		app.Notifier.Notify(notifier.EventRuleUpdated, updatedRule)
+		c.JSON(http.StatusOK, newRuleResponse(app.Config.DnsPolicyConfig, *updatedRule))
+	}
+}
+
+// deletePolicyRule deletes a policy rule (super-admin only).
+// @Summary Delete a policy rule
+// @Description Deletes a DNS policy rule by ID. Only SuperAdmins are authorized.
+// @Description Rules are soft-deleted and can be restored, unless purge is set, which removes the rule permanently (also for already deleted rules).
+// @Tags policies
+// @Produce json
+// @Param id path string true "Rule ID (the rule UUID if DNS_POLICY_RULE_KEY_TYPE is uuid)"
+// @Param purge query bool false "Remove the rule permanently instead of soft-deleting it"
+// @Success 200 {object} map[string]string "Rule successfully deleted"
+// @Failure 400 {object} helper.APIError "Invalid rule ID"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 404 {object} helper.APIError "Rule not found"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/rules/{id} [delete]
+func deletePolicyRule(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can delete rules")
+			return
+		}
+
+		id, ok := parseRuleKey(c, app)
+		if !ok {
+			return
+		}
+
+		var err error
+		purge := c.Query("purge") == "true"
+		if purge {
+			err = app.Storage.PolicyPurgeCtx(c.Request.Context(), id)
+		} else {
+			err = app.Storage.PolicyDeleteCtx(c.Request.Context(), id)
+		}
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "Rule not found")
+				return
+			}
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to delete rule")
+			return
+		}
+
+		if purge {
+			app.Notifier.Notify(notifier.EventRuleDeleted, gin.H{"id": id, "purged": true})
+			c.JSON(http.StatusOK, gin.H{"status": "purged"})
+			return
+		}
+
+		app.Notifier.Notify(notifier.EventRuleDeleted, gin.H{"id": id})
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	}
+}
+
+// restorePolicyRule restores a soft-deleted policy rule (super-admin only).
+// @Summary Restore a deleted policy rule
+// @Description Restores a soft-deleted DNS policy rule by ID. Only SuperAdmins are authorized.
+// @Tags policies
+// @Produce json
+// @Param id path string true "Rule ID (the rule UUID if DNS_POLICY_RULE_KEY_TYPE is uuid)"
+// @Success 200 {object} RuleResponse "The restored policy rule"
+// @Failure 400 {object} helper.APIError "Invalid rule ID"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 404 {object} helper.APIError "No deleted rule with this ID"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/rules/{id}/restore [post]
+func restorePolicyRule(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can restore rules")
+			return
+		}
+
+		id, ok := parseRuleKey(c, app)
+		if !ok {
+			return
+		}
+
+		rule, err := app.Storage.PolicyRestoreCtx(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "No deleted rule with this ID")
+				return
+			}
+			helper.RequestLogger(c, app.Log).Warnf("Failed to restore policy rule %d: %v", id, err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to restore rule")
+			return
+		}
+
+		app.Notifier.Notify(notifier.EventRuleRestored, rule)
+		c.JSON(http.StatusOK, newRuleResponse(app.Config.DnsPolicyConfig, *rule))
+	}
+}
+
+// importPolicyRules creates many rules at once (super-admin only).
+// @Summary Import policy rules
+// @Description Creates all rules of a JSON array (e.g. an export) in a single transaction and reports the outcome per rule. Fields other than those of a created rule (e.g. "id") are ignored.
+// @Description If any rule is invalid, no rule is created (422). Rules whose zone pattern already exists or conflicts with another rule (including an earlier rule of the import) are skipped by default; with on_conflict=abort, no rule is created (409).
+// @Description Only SuperAdmins are authorized.
+// @Tags policies
+// @Accept json
+// @Produce json
+// @Param rules body []PolicyRuleRequest true "The rules to create"
+// @Param on_conflict query string false "Whether rules with a duplicate or conflicting zone pattern are skipped or abort the import" Enums(skip, abort) default(skip)
+// @Success 200 {object} ImportResponse "The outcome per rule"
+// @Failure 400 {object} helper.APIError "Invalid request payload or on_conflict value"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 409 {object} ImportResponse "A zone pattern is duplicate, conflicting, or used by a deleted rule and on_conflict is abort (nothing was created)"
+// @Failure 413 {object} helper.APIError "Too many rules"
+// @Failure 422 {object} ImportResponse "Validation error (nothing was created)"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/import [post]
+func importPolicyRules(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can import rules")
+			return
+		}
+
+		onConflict := c.DefaultQuery("on_conflict", "skip")
+		if onConflict != "skip" && onConflict != "abort" {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "on_conflict must be 'skip' or 'abort'")
+			return
+		}
+
+		// Keep the raw rules for the audit log
+		var rawRules []json.RawMessage
+		if err := c.ShouldBindJSON(&rawRules); err != nil || len(rawRules) == 0 {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid request payload: expected a non-empty array of rules")
+			return
+		}
+		if len(rawRules) > maxImportRules {
+			helper.RespondErrorDetails(c, http.StatusRequestEntityTooLarge, helper.ErrorCodePayloadTooLarge,
+				fmt.Sprintf("the import contains %d rules but at most %d are allowed; split it into smaller imports", len(rawRules), maxImportRules),
+				gin.H{"max_import_rules": maxImportRules})
+			return
+		}
+
+		// Validate all rules before creating any
+		response := ImportResponse{Results: make([]ImportResult, len(rawRules))}
+		rules := make([]storage.PolicyRule, len(rawRules))
+		for i, rawRule := range rawRules {
+			response.Results[i] = ImportResult{Index: i, Status: importStatusAborted}
+			req, fieldErrors := parseImportedRule(rawRule, app.Config.DnsPolicyConfig)
+			if len(fieldErrors) > 0 {
+				response.Results[i].Status = importStatusInvalid
+				response.Results[i].Fields = fieldErrors
+				response.Failed++
+				continue
+			}
+
+			rules[i] = storage.PolicyRule{
+				ZonePattern:      req.ZonePattern,
+				ZoneSoa:          req.ZoneSoa,
+				TargetUserFilter: req.TargetUserFilter,
+				Description:      req.Description,
+				IncludeWww:       req.IncludeWww,
+				AccessLevel:      req.accessLevel(),
+				ExpiresAt:        req.ExpiresAt,
+				OwnerEmail:       user.Email,
+				Status:           storage.RuleStatusPending,
+			}
+			if app.Config.DnsPolicyConfig.AutoApproveSuperAdminRules {
+				rules[i].Status = storage.RuleStatusApproved
+			}
+		}
+		if response.Failed > 0 {
+			c.JSON(http.StatusUnprocessableEntity, response)
+			return
+		}
+
+		var created []storage.PolicyRule
+		var skipped []storage.BulkCreateError
+		var err error
+		rawBodies := make([][]byte, len(rawRules))
+		for i, rawRule := range rawRules {
+			rawBodies[i] = rawRule
+		}
+		ctx := storage.WithRequestBodies(c.Request.Context(), rawBodies)
+		if onConflict == "skip" {
+			created, skipped, err = app.Storage.PolicyBulkCreateSkipConflictsCtx(ctx, rules)
+		} else {
+			created, err = app.Storage.PolicyBulkCreateCtx(ctx, rules)
+		}
+
+		var bulkErr *storage.BulkCreateError
+		if errors.As(err, &bulkErr) {
+			response.Results[bulkErr.Index].Status = importConflictStatus(bulkErr.Err)
+			response.Failed = 1
+			c.JSON(http.StatusConflict, response)
+			return
+		}
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Warnf("Failed to import policy rules: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to import rules")
+			return
+		}
+
+		for _, skippedRule := range skipped {
+			response.Results[skippedRule.Index].Status = importConflictStatus(skippedRule.Err)
+		}
+		// The created rules are returned in request order
+		for i := range response.Results {
+			if response.Results[i].Status != importStatusAborted {
+				continue
+			}
+			rule := created[response.Created]
+			response.Results[i].Status = importStatusCreated
+			response.Results[i].Rule = newRuleResponse(app.Config.DnsPolicyConfig, rule)
+			response.Created++
 
-	return group
+			auditPolicyChange(c, app, user, "import", rule.ID, rawRules[i], false)
+			app.Notifier.Notify(notifier.EventRuleCreated, rule)
+		}
+		response.Failed = len(skipped)
+
+		helper.RequestLogger(c, app.Log).Infof("User '%s' imported %d rule(s) (%d skipped)", user.Email, response.Created, response.Failed)
+		c.JSON(http.StatusOK, response)
+	}
 }
 
-func listUserRules(app *config.AppData, user *auth.UserClaims, is_super_admin bool) ([]storage.PolicyRule, error) {
-	// Get all rules from storage
-	rules, err := app.Storage.PolicyGetAll()
-	if err != nil {
-		// Log the error (not shown here)
-		return nil, err
+// parseImportedRule decodes and validates a rule of an import like a rule of a create request.
+func parseImportedRule(rawRule json.RawMessage, policyConfig config.DnsPolicyConfig) (*PolicyRuleRequest, []config.FieldError) {
+	var req PolicyRuleRequest
+	if err := json.Unmarshal(rawRule, &req); err != nil {
+		return nil, []config.FieldError{{Field: "", Rule: "json", Message: "the rule is not a valid rule object"}}
 	}
 
-	// Filter the rules based on user email
-	if !is_super_admin {
-		filteredRules := make([]storage.PolicyRule, 0)
-		for _, rule := range rules {
-			if canAccess, err := userCanAccessRule(user.Email, rule.TargetUserFilter); err == nil && canAccess {
-				filteredRules = append(filteredRules, rule)
-			}
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		var validationErrors validator.ValidationErrors
+		if errors.As(err, &validationErrors) {
+			return nil, config.ValidationFieldErrors(validationErrors)
 		}
-		rules = filteredRules
+		return nil, []config.FieldError{{Field: "", Rule: "invalid", Message: err.Error()}}
+	}
+
+	if fieldErrors := validatePolicyRuleRequest(&req, policyConfig); len(fieldErrors) > 0 {
+		return nil, fieldErrors
 	}
+	return &req, nil
+}
 
-	return rules, nil
+// importConflictStatus returns the import status of a rule rejected because of its zone pattern.
+func importConflictStatus(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrDuplicateZonePattern):
+		return importStatusDuplicate
+	case errors.Is(err, storage.ErrDeletedZonePattern):
+		return importStatusDeleted
+	}
+	return importStatusConflict
 }
 
-// listPolicyRules lists all policy rules.
-// @Summary List policy rules
-// @Description List all DNS policy rules. Non-SuperAdmins only see rules matching their user filter.
+// exportPolicyRules streams all rules as a JSON array (super-admin only).
+// @Summary Export all policy rules
+// @Description Streams all rules in ID order as a JSON array, e.g. for backups. The export can be imported again with POST /v1/policies/import. Only SuperAdmins are authorized.
 // @Tags policies
 // @Produce json
-// @Success 200 {object} RulesResponse "List of policy rules"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Success 200 {array} RuleResponse "All policy rules"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
 // @Security ApiKeyAuth
-// @Router /v1/policies/rules [get]
-func listPolicyRules(app *config.AppData) gin.HandlerFunc {
+// @Router /v1/policies/export [get]
+func exportPolicyRules(app *config.AppData) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
-		is_super_admin := isSuperAdmin(app, user)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can export rules")
+			return
+		}
+
+		// The response is only started with the first rule, so a failing query can still be answered with 500
+		exported := 0
+		writeSeparator := func() error {
+			separator := ","
+			if exported == 0 {
+				c.Header("Content-Type", "application/json; charset=utf-8")
+				c.Header("Content-Disposition", `attachment; filename="policy-rules.json"`)
+				c.Status(http.StatusOK)
+				separator = "["
+			}
+			_, err := c.Writer.WriteString(separator)
+			return err
+		}
 
-		// Get all rules from storage
-		rules, err := listUserRules(app, user, is_super_admin)
+		err := app.Storage.PolicyStreamCtx(c.Request.Context(), func(rule storage.PolicyRule) error {
+			ruleJson, err := json.Marshal(newRuleResponse(app.Config.DnsPolicyConfig, rule))
+			if err != nil {
+				return err
+			}
+			if err := writeSeparator(); err != nil {
+				return err
+			}
+			exported++
+			_, err = c.Writer.Write(ruleJson)
+			return err
+		})
 		if err != nil {
-			// Log the error
-			app.Log.Warnf("Failed to retrieve policy rules: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rules"})
+			helper.RequestLogger(c, app.Log).Warnf("Failed to export policy rules after %d rule(s): %v", exported, err)
+			if exported == 0 {
+				helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to export rules")
+				return
+			}
+			// The response has already started; the unterminated array tells the client the export is incomplete
+			c.Abort()
 			return
 		}
 
-		// Return the rules
-		app.Log.Debugf("Returning %d policy rules to user %s (super admin: %v)", len(rules), user.Email, is_super_admin)
-		c.JSON(http.StatusOK, RulesResponse{Rules: rules, EditAllowed: is_super_admin})
+		if exported == 0 {
+			if err := writeSeparator(); err != nil {
+				return
+			}
+		}
+		_, _ = c.Writer.WriteString("]")
 	}
 }
 
-// createPolicyRule creates a new policy rule (super-admin only).
-// @Summary Create a policy rule
-// @Description Creates a new DNS policy rule. Only SuperAdmins are authorized.
+// auditPolicyFilters reports rules whose user filter matches none of the active domains (super-admin only).
+// @Summary Find rules with likely dead user filters
+// @Description Checks the target user filter of every rule against a list of email domains with active users and reports the rules matching none of them. Filters without a domain part (e.g. "*") are considered to match. Nothing is modified. Only SuperAdmins are authorized.
 // @Tags policies
 // @Accept json
 // @Produce json
-// @Param rule body PolicyRuleRequest true "Policy rule payload"
-// @Success 201 {object} storage.PolicyRule "The newly created policy rule"
-// @Failure 400 {object} map[string]string "Invalid request or validation error"
-// @Failure 403 {object} map[string]string "Forbidden: Not a SuperAdmin"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Param request body AuditFiltersRequest true "The email domains with active users"
+// @Success 200 {object} AuditFiltersResponse "The rules whose filter matches none of the domains"
+// @Failure 400 {object} helper.APIError "Invalid request payload"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
 // @Security ApiKeyAuth
-// @Router /v1/policies/rules [post]
-func createPolicyRule(app *config.AppData) gin.HandlerFunc {
+// @Router /v1/policies/audit-filters [post]
+func auditPolicyFilters(app *config.AppData) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
-
-		if !isSuperAdmin(app, user) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can create rules"})
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can audit rules")
 			return
 		}
 
-		var req PolicyRuleRequest
+		var req AuditFiltersRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+			respondBindingError(c, err)
 			return
 		}
 
-		// Validation
-		if !validateZonePattern(req.ZonePattern) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zone pattern"})
+		rules, err := app.Storage.PolicyGetAllCtx(c.Request.Context())
+		if err != nil {
+			helper.RequestLogger(c, app.Log).Warnf("Failed to retrieve policy rules: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to retrieve rules")
 			return
 		}
-		if err := validateUserFilter(req.TargetUserFilter); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+		response := AuditFiltersResponse{Checked: len(rules), Unmatched: make([]AuditedRule, 0)}
+		for _, rule := range rules {
+			if !slices.ContainsFunc(req.ActiveDomains, func(domain string) bool { return userFilterMatchesDomain(rule.TargetUserFilter, domain) }) {
+				response.Unmatched = append(response.Unmatched, AuditedRule{RuleID: newRuleKey(app.Config.DnsPolicyConfig, rule), ZonePattern: rule.ZonePattern, TargetUserFilter: rule.TargetUserFilter})
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// listAuditLog lists the audit log of all rule mutations (super-admin only).
+// @Summary List the audit log
+// @Description Lists the audit log entries of all rule mutations (create, update, and delete), newest first. Each entry contains the rule as JSON before (null for creates) and after (null for deletes) the mutation. Only SuperAdmins are authorized.
+// @Tags policies
+// @Produce json
+// @Param page query int false "The page number (default: 1)"
+// @Param per_page query int false "The number of entries per page (1-500, default: 50)"
+// @Success 200 {object} AuditLogResponse "One page of the audit log"
+// @Failure 400 {object} helper.APIError "Invalid pagination parameters"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/audit [get]
+func listAuditLog(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can view the audit log")
 			return
 		}
 
-		newRule := storage.PolicyRule{
-			ZonePattern:      req.ZonePattern,
-			ZoneSoa:          req.ZoneSoa,
-			TargetUserFilter: req.TargetUserFilter,
-			Description:      req.Description,
+		page, err := parseNumberedPage(c)
+		if err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+		if page == nil {
+			page = &numberedPage{Page: 1, PerPage: defaultPerPage}
 		}
 
-		createdRule, err := app.Storage.PolicyCreate(&newRule)
+		entries, total, err := app.Storage.AuditGetPageCtx(c.Request.Context(), (page.Page-1)*page.PerPage, page.PerPage)
 		if err != nil {
-			// Log the error
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
+			helper.RequestLogger(c, app.Log).Warnf("Failed to retrieve the audit log: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to retrieve the audit log")
 			return
 		}
 
-		c.JSON(http.StatusCreated, createdRule)
+		c.JSON(http.StatusOK, AuditLogResponse{Entries: entries, Total: total, Page: page.Page, PerPage: page.PerPage})
 	}
 }
 
-// updatePolicyRule updates an existing policy rule (super-admin only).
-// @Summary Update a policy rule
-// @Description Updates an existing DNS policy rule by ID. Only SuperAdmins are authorized.
+// mergePolicyRules merges two overlapping rules into one (super-admin only).
+// @Summary Merge two policy rules
+// @Description Sets the target user filter of the kept rule to the combined filter and deletes the merged rule in one transaction. Only SuperAdmins are authorized.
 // @Tags policies
 // @Accept json
 // @Produce json
-// @Param id path int true "Rule ID"
-// @Param rule body PolicyRuleRequest true "Policy rule payload"
-// @Success 200 {object} storage.PolicyRule "The updated policy rule"
-// @Failure 400 {object} map[string]string "Invalid rule ID, request payload, or validation error"
-// @Failure 403 {object} map[string]string "Forbidden: Not a SuperAdmin"
-// @Failure 404 {object} map[string]string "Rule not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Param request body MergeRequest true "The rule to keep, the rule to merge into it, and the combined user filter"
+// @Success 200 {object} RuleResponse "The resulting policy rule"
+// @Failure 400 {object} helper.APIError "Invalid request payload, invalid filter, or identical rules"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 404 {object} helper.APIError "One of the rules does not exist"
+// @Failure 500 {object} helper.APIError "Internal server error"
 // @Security ApiKeyAuth
-// @Router /v1/policies/rules/{id} [put]
-func updatePolicyRule(app *config.AppData) gin.HandlerFunc {
+// @Router /v1/policies/merge [post]
+func mergePolicyRules(app *config.AppData) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		log := helper.RequestLogger(c, app.Log)
 		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can merge rules")
+			return
+		}
+
+		var req MergeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+		if err := validateUserFilter(req.CombinedFilter, app.Config.DnsPolicyConfig.AllowMatchAllUserFilters); err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
 
-		if !isSuperAdmin(app, user) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can update rules"})
+		keepID, ok := resolveRuleKey(c, app, *req.KeepID)
+		if !ok {
+			return
+		}
+		mergeID, ok := resolveRuleKey(c, app, *req.MergeID)
+		if !ok {
 			return
 		}
 
-		idStr := c.Param("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
+		rule, err := app.Storage.PolicyMergeCtx(c.Request.Context(), keepID, mergeID, req.CombinedFilter)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+			switch {
+			case errors.Is(err, storage.ErrMergeSameRule):
+				helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "Rule not found")
+			default:
+				log.Warnf("Failed to merge policy rule %d into %d: %v", mergeID, keepID, err)
+				helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to merge rules")
+			}
 			return
 		}
 
-		var req PolicyRuleRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		log.Infof("User '%s' merged rule %d into rule %d", user.Email, mergeID, keepID)
+		app.Notifier.Notify(notifier.EventRulesMerged, gin.H{"kept_rule": rule, "merged_id": mergeID})
+		c.JSON(http.StatusOK, newRuleResponse(app.Config.DnsPolicyConfig, *rule))
+	}
+}
+
+// setPolicyRulesEnabled enables or disables multiple rules at once (super-admin only).
+// @Summary Enable or disable rules in bulk
+// @Description Enables or disables all DNS policy rules matching the filter (by zone SOA and/or IDs) in one transaction. Only SuperAdmins are authorized.
+// @Tags policies
+// @Accept json
+// @Produce json
+// @Param request body SetEnabledRequest true "The filter, the new state, and the dry-run flag"
+// @Success 200 {object} SetEnabledResponse "The number of changed rules"
+// @Failure 400 {object} helper.APIError "Invalid request payload or missing filter"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/set-enabled [post]
+func setPolicyRulesEnabled(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := helper.RequestLogger(c, app.Log)
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can enable or disable rules")
 			return
 		}
 
-		// Validation
-		if !validateZonePattern(req.ZonePattern) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zone pattern"})
+		var req SetEnabledRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
 			return
 		}
-		if err := validateUserFilter(req.TargetUserFilter); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+
+		// Refuse to toggle all rules by accident
+		if req.ZoneSoa == "" && len(req.IDs) == 0 {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "A filter by zone_soa or ids is required")
 			return
 		}
 
-		// Check if rule exists before update attempt
-		existingRule, err := app.Storage.PolicyGetByID(id)
-		if err != nil {
+		// Unknown UUIDs match no rule, like unknown IDs
+		filter := storage.PolicyRuleFilter{ZoneSoa: req.ZoneSoa}
+		for _, key := range req.IDs {
+			id, err := ruleIDForKey(c.Request.Context(), app, key)
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rule"})
+				continue
+			} else if err != nil {
+				resolveRuleKey(c, app, key)
+				return
 			}
+			filter.IDs = append(filter.IDs, id)
+		}
+		if filter.IsEmpty() {
+			c.JSON(http.StatusOK, SetEnabledResponse{Changed: 0, DryRun: req.DryRun})
 			return
 		}
 
-		// Update the fields on the existing rule object
-		existingRule.ZonePattern = req.ZonePattern
-		existingRule.ZoneSoa = req.ZoneSoa
-		existingRule.TargetUserFilter = req.TargetUserFilter
-		existingRule.Description = req.Description
+		changed, err := app.Storage.PolicySetEnabledCtx(c.Request.Context(), filter, *req.Enabled, req.DryRun)
+		if err != nil {
+			log.Warnf("Failed to set enabled state of rules: %v", err)
+			helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to update rules")
+			return
+		}
+
+		if !req.DryRun && changed > 0 {
+			log.Infof("User '%s' set enabled=%v on %d rule(s) (zone_soa: '%s', ids: %v)", user.Email, *req.Enabled, changed, req.ZoneSoa, req.IDs)
+			app.Notifier.Notify(notifier.EventRulesEnabledChanged, gin.H{"zone_soa": req.ZoneSoa, "ids": req.IDs, "enabled": *req.Enabled, "changed": changed})
+		}
+		c.JSON(http.StatusOK, SetEnabledResponse{Changed: changed, DryRun: req.DryRun})
+	}
+}
 
-		updatedRule, err := app.Storage.PolicyUpdate(existingRule)
+// comparePolicyZones compares the zone entitlements of two users (super-admin only).
+// @Summary Compare the zones of two users
+// @Description Evaluates the rules for two sets of user claims and returns the zones only one of them is entitled to, together with the rules causing each difference. Only SuperAdmins are authorized.
+// @Tags policies
+// @Accept json
+// @Produce json
+// @Param claims body CompareRequest true "The claims of the two users"
+// @Success 200 {object} CompareResponse "The symmetric difference of the zones"
+// @Failure 400 {object} helper.APIError "Invalid request payload"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 422 {object} helper.APIError "No DNS label can be derived for one of the users"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/compare [post]
+func comparePolicyZones(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can compare users")
+			return
+		}
+
+		var req CompareRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Left.Email == "" || req.Right.Email == "" {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid request payload")
+			return
+		}
+
+		// Run the evaluation for both sides, keeping all rules producing a zone
+		leftMatches, _, err := evaluateUserZonesWithEvaluations(c.Request.Context(), app, &req.Left, time.Time{})
 		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
-				return
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
+			respondEvaluationError(c, app, err)
+			return
+		}
+		rightMatches, _, err := evaluateUserZonesWithEvaluations(c.Request.Context(), app, &req.Right, time.Time{})
+		if err != nil {
+			respondEvaluationError(c, app, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, updatedRule)
+		c.JSON(http.StatusOK, CompareResponse{
+			LeftRuleIDs:  matchedRuleIDs(leftMatches),
+			RightRuleIDs: matchedRuleIDs(rightMatches),
+			OnlyLeft:     zoneDifference(leftMatches, rightMatches),
+			OnlyRight:    zoneDifference(rightMatches, leftMatches),
+		})
 	}
 }
 
-// deletePolicyRule deletes a policy rule (super-admin only).
-// @Summary Delete a policy rule
-// @Description Deletes a DNS policy rule by ID. Only SuperAdmins are authorized.
+// previewUserZones returns the zones the webhook would return for an email (super-admin only).
+// @Summary Preview the zones of a user
+// @Description Runs the rule matching and zone expansion of the webhook for the given email (and optionally groups) and returns the resulting zones together with the rule producing each zone. Users without matching rules get an empty list (or the fallback zone, if configured). Only SuperAdmins are authorized.
+// @Description With at, the rules are evaluated as they were at that time, e.g. to reproduce past provisioning decisions.
 // @Tags policies
 // @Produce json
-// @Param id path int true "Rule ID"
-// @Success 200 {object} map[string]string "Rule successfully deleted"
-// @Failure 400 {object} map[string]string "Invalid rule ID"
-// @Failure 403 {object} map[string]string "Forbidden: Not a SuperAdmin"
-// @Failure 404 {object} map[string]string "Rule not found"
-// @Failure 500 {object} map[string]string "Internal server error"
+// @Param email query string true "The email of the user"
+// @Param group query []string false "The groups of the user (repeatable; rules with %g expand once per group)" collectionFormat(multi)
+// @Param at query string false "Evaluate the rules as they were at this time, reconstructed from the audit log (RFC 3339, e.g. 2024-05-01T12:00:00Z)"
+// @Success 200 {object} PreviewResponse "The zones of the user"
+// @Failure 400 {object} helper.APIError "Missing or invalid email or time"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 422 {object} helper.APIError "No DNS label can be derived for the user, or the rule history does not reach back to the requested time"
+// @Failure 500 {object} helper.APIError "Internal server error"
 // @Security ApiKeyAuth
-// @Router /v1/policies/rules/{id} [delete]
-func deletePolicyRule(app *config.AppData) gin.HandlerFunc {
+// @Router /v1/policies/preview [get]
+func previewUserZones(app *config.AppData) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
-		if !isSuperAdmin(app, user) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Only super admins can delete rules"})
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can preview the zones of users")
 			return
 		}
 
-		idStr := c.Param("id")
-		id, err := strconv.ParseInt(idStr, 10, 64)
+		email := strings.TrimSpace(c.Query("email"))
+		if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Query parameter 'email' must be a valid email address")
+			return
+		}
+		previewed := auth.UserClaims{Email: email, Groups: c.QueryArray("group")}
+		at, err := parseEvaluationTime(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
 			return
 		}
 
-		if err := app.Storage.PolicyDelete(id); err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
-				return
-			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
+		// Use the evaluation of the webhook, so the preview matches what the user actually gets
+		matches, err := evaluateUserZonesAt(c.Request.Context(), app, &previewed, at)
+		if err != nil {
+			respondEvaluationError(c, app, err)
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+		zones := make([]PreviewZone, 0, len(matches))
+		for _, match := range matches {
+			zones = append(zones, PreviewZone{
+				Zone:        match.Zone.Zone,
+				ZoneSOA:     match.Zone.ZoneSOA,
+				AccessLevel: match.Zone.AccessLevel,
+				RuleID:      match.RuleKey,
+			})
+		}
+		response := PreviewResponse{Email: email, Zones: zones}
+		if !at.IsZero() {
+			response.At = &at
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// matchedRuleIDs returns the distinct keys of the rules that produced the given matches.
+func matchedRuleIDs(matches []zoneMatch) []RuleKey {
+	keys := make([]RuleKey, 0, len(matches))
+	seen := make(map[RuleKey]struct{}, len(matches))
+	for _, match := range matches {
+		if _, exists := seen[match.RuleKey]; !exists {
+			seen[match.RuleKey] = struct{}{}
+			keys = append(keys, match.RuleKey)
+		}
+	}
+	return keys
+}
+
+// zoneDifference returns the zones contained in a but not in b, grouped with the rules producing them.
+func zoneDifference(a []zoneMatch, b []zoneMatch) []ZoneDifference {
+	type zoneKey struct{ zone, soa string }
+
+	inB := make(map[zoneKey]struct{}, len(b))
+	for _, match := range b {
+		inB[zoneKey{match.Zone.Zone, match.Zone.ZoneSOA}] = struct{}{}
+	}
+
+	diff := make([]ZoneDifference, 0)
+	index := make(map[zoneKey]int)
+	for _, match := range a {
+		key := zoneKey{match.Zone.Zone, match.Zone.ZoneSOA}
+		if _, exists := inB[key]; exists {
+			continue
+		}
+		if i, exists := index[key]; exists {
+			diff[i].RuleIDs = append(diff[i].RuleIDs, match.RuleKey)
+			continue
+		}
+		index[key] = len(diff)
+		diff = append(diff, ZoneDifference{
+			Zone:    match.Zone.Zone,
+			ZoneSOA: match.Zone.ZoneSOA,
+			RuleIDs: []RuleKey{match.RuleKey},
+		})
 	}
+	return diff
 }
 
 // --- Validation Helpers
 
-// userCanAccessRule checks if a user has access to a given policy rule based on the target user filter.
-func userCanAccessRule(email string, pattern string) (bool, error) {
-	// Normalize both to lowercase for case-insensitive comparison
-	patternLower := strings.ToLower(pattern)
-	emailLower := strings.ToLower(email)
+func init() {
+	// Report the JSON names of request fields in validation errors
+	if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+// captureAuditRequestBody captures the request body and records it in the request context, so the storage
+// writes it to the audit log entries of the mutations (see storage.WithRequestBody). On failure, it responds
+// with 400 and returns false.
+func captureAuditRequestBody(c *gin.Context) ([]byte, bool, bool) {
+	rawBody, truncated, err := helper.CaptureRequestBody(c, storage.AuditRequestBodyMaxBytes)
+	if err != nil {
+		helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Failed to read request body")
+		return nil, false, false
+	}
+	c.Request = c.Request.WithContext(storage.WithRequestBody(c.Request.Context(), rawBody, truncated))
+	return rawBody, truncated, true
+}
+
+// auditActorMiddleware records the email of the authenticated user in the request context, so the storage
+// writes it to the audit log entries of the rule mutations of the request.
+func auditActorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value, exists := c.Get(auth.UserDataKey); exists {
+			if user, ok := value.(*auth.UserClaims); ok {
+				c.Request = c.Request.WithContext(storage.WithActor(c.Request.Context(), user.Email))
+			}
+		}
+		c.Next()
+	}
+}
+
+// auditPolicyChange logs a rule change including the raw request body, so what the client requested can be
+// told apart from what was stored. The storage records the body in the audit log as well.
+func auditPolicyChange(c *gin.Context, app *config.AppData, user *auth.UserClaims, action string, ruleID int64, rawBody []byte, truncated bool) {
+	helper.RequestLogger(c, app.Log).Infow("Audit: policy rule changed",
+		"action", action,
+		"rule_id", ruleID,
+		"actor", user.Email,
+		"actor_sub", user.Subject,
+		"time", time.Now().UTC().Format(time.RFC3339),
+		"request_body", string(rawBody),
+		"request_body_truncated", truncated,
+	)
+}
+
+// bindPolicyRuleRequest binds and validates a policy rule request.
+// On failure, an error response has already been sent and false is returned.
+func bindPolicyRuleRequest(c *gin.Context, policyConfig config.DnsPolicyConfig) (*PolicyRuleRequest, bool) {
+	var req PolicyRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindingError(c, err)
+		return nil, false
+	}
+
+	if fieldErrors := validatePolicyRuleRequest(&req, policyConfig); len(fieldErrors) > 0 {
+		respondValidationErrors(c, fieldErrors)
+		return nil, false
+	}
+
+	return &req, true
+}
 
-	// Check that the pattern contains at most one asterisk
-	if strings.Count(patternLower, "*") > 1 {
-		return false, errors.New("user filter can contain at most one wildcard asterisk")
+// respondBindingError sends a 422 for payloads failing the binding rules and a 400 for malformed payloads.
+func respondBindingError(c *gin.Context, err error) {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		respondValidationErrors(c, config.ValidationFieldErrors(validationErrors))
+		return
 	}
+	helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid request payload")
+}
 
-	// Check if the pattern contains the wildcard
-	if !strings.Contains(patternLower, "*") {
-		// No asterisk: must be an exact match
-		return emailLower == patternLower, nil
+// respondValidationErrors sends a 422 response whose details map each field that failed validation to the
+// failure message (several failures of a field are joined).
+func respondValidationErrors(c *gin.Context, fieldErrors []config.FieldError) {
+	details := make(map[string]string, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		if message, exists := details[fieldError.Field]; exists {
+			details[fieldError.Field] = message + "; " + fieldError.Message
+		} else {
+			details[fieldError.Field] = fieldError.Message
+		}
 	}
+	helper.RespondErrorDetails(c, http.StatusUnprocessableEntity, helper.ErrorCodeValidationFailed, "Validation failed", details)
+}
 
-	// Split the pattern by the asterisk
-	parts := strings.Split(patternLower, "*")
+// validatePolicyRuleRequest runs the custom validations of a policy rule request.
+func validatePolicyRuleRequest(req *PolicyRuleRequest, policyConfig config.DnsPolicyConfig) []config.FieldError {
+	fieldErrors := make([]config.FieldError, 0)
 
-	// A single asterisk splits into two parts
-	prefix := parts[0]
-	suffix := strings.Join(parts[1:], "")
+	if fieldError := validateZonePatternField(req.ZonePattern); fieldError != nil {
+		fieldErrors = append(fieldErrors, *fieldError)
+	}
+	if err := validateZoneSoa(req.ZoneSoa); err != nil {
+		fieldErrors = append(fieldErrors, config.FieldError{Field: "zone_soa", Rule: "hostname", Message: err.Error()})
+	}
+	if err := validateUserFilter(req.TargetUserFilter, policyConfig.AllowMatchAllUserFilters); err != nil {
+		fieldErrors = append(fieldErrors, config.FieldError{Field: "target_user_filter", Rule: "user_filter", Message: err.Error()})
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		fieldErrors = append(fieldErrors, config.FieldError{Field: "expires_at", Rule: "future", Message: "expires_at must be in the future"})
+	}
 
-	// Rule: Match prefix AND match suffix
-	// HasPrefix/HasSuffix handle empty strings correctly.
-	return strings.HasPrefix(emailLower, prefix) && strings.HasSuffix(emailLower, suffix), nil
+	return fieldErrors
 }
 
-func validateUserFilter(filter string) error {
-	errInvalidUserFilter := errors.New("user filter must be a valid email or a wildcard pattern like *@domain.com")
+// errUnsupportedPlaceholder is returned if a zone pattern contains a placeholder other than %u, %d, and %g.
+var errUnsupportedPlaceholder = errors.New("zone pattern contains an unsupported placeholder (only %u, %d, and %g are allowed)")
 
-	// Non-empty check
-	if filter == "" {
-		return errInvalidUserFilter
+// validateZonePatternField validates a zone pattern, returning nil if it is valid.
+func validateZonePatternField(pattern string) *config.FieldError {
+	if err := ValidateZonePattern(pattern); err != nil {
+		rule := "zone_pattern"
+		if errors.Is(err, errUnsupportedPlaceholder) {
+			rule = "placeholder"
+		}
+		return &config.FieldError{Field: "zone_pattern", Rule: rule, Message: err.Error()}
+	}
+	return nil
+}
+
+// ValidateZonePattern checks that a zone pattern is a plausible DNS name: every label is 1-63 characters
+// of letters, digits, and hyphens (not at the start or end), and the whole name is at most 253 characters.
+// The placeholders %u, %d, and %g count as a single character; other placeholders are rejected.
+func ValidateZonePattern(pattern string) error {
+	if strings.Contains(zonePatternPlaceholders.Replace(pattern), "%") {
+		return errUnsupportedPlaceholder
+	}
+	return validateDnsName("zone pattern", pattern, zonePatternPlaceholders.Replace)
+}
+
+// validateZoneSoa checks that a zone SOA is a valid DNS name without placeholders.
+func validateZoneSoa(soa string) error {
+	if strings.Contains(soa, "%") {
+		return errors.New("zone SOA must not contain placeholders")
+	}
+	return validateDnsName("zone SOA", soa, func(label string) string { return label })
+}
+
+// validateDnsName checks the labels and the length of a DNS name after applying expand to it,
+// returning an error describing the first problem. Errors quote the labels as given.
+func validateDnsName(field string, name string, expand func(string) string) error {
+	if name == "" {
+		return fmt.Errorf("%s must not be empty", field)
+	}
+	if len(expand(name)) > maxZoneNameLength {
+		return fmt.Errorf("%s must not exceed %d characters", field, maxZoneNameLength)
+	}
+
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%s must consist of at least two labels (e.g. example.org)", field)
+	}
+	for _, label := range labels {
+		expanded := expand(label)
+		switch {
+		case expanded == "":
+			return fmt.Errorf("%s must not contain empty labels", field)
+		case len(expanded) > 63:
+			return fmt.Errorf("label '%s' of the %s exceeds 63 characters", label, field)
+		case strings.HasPrefix(expanded, "-") || strings.HasSuffix(expanded, "-"):
+			return fmt.Errorf("label '%s' of the %s must not start or end with a hyphen", label, field)
+		case !helper.DnsIsValidLabel(expanded):
+			return fmt.Errorf("label '%s' of the %s contains invalid characters (allowed: letters, digits, and hyphens)", label, field)
+		}
+	}
+	return nil
+}
+
+// MatchesUserFilter reports whether the email of the user matches the target user filter of a rule.
+// The filter is either an exact email address or a glob pattern where each asterisk matches any
+// (possibly empty) sequence of characters, e.g. "*@dhbw.de" or "admin-*@*.de". Matching is
+// case-insensitive (using Unicode case folding), and internationalized domain names match regardless
+// of whether they are given in Unicode or Punycode form. Users without an email never match.
+func MatchesUserFilter(filter string, claims *auth.UserClaims) bool {
+	if claims == nil || claims.Email == "" || filter == "" {
+		return false
+	}
+
+	// Normalize both for case-insensitive comparison
+	return globMatch(helper.EmailNormalizeForMatching(filter), helper.EmailNormalizeForMatching(claims.Email))
+}
+
+// userFilterMatchesDomain reports whether a target user filter can match users of an email domain, i.e. whether
+// the domain part of the filter (after the last "@") matches the domain, using the same normalization as
+// MatchesUserFilter. Filters without a domain part cannot be attributed to a domain and are considered to match.
+func userFilterMatchesDomain(filter string, domain string) bool {
+	filter = helper.EmailNormalizeForMatching(filter)
+	at := strings.LastIndexByte(filter, '@')
+	if at < 0 {
+		return true
+	}
+
+	// Normalize the domain like the domain of an email address (e.g. to decode Punycode)
+	normalizedDomain := helper.EmailNormalizeForMatching("user@" + strings.TrimSpace(domain))
+	return globMatch(filter[at+1:], normalizedDomain[len("user@"):])
+}
+
+// globMatch matches text against a pattern in which "*" matches any sequence of characters.
+// It backtracks only to the most recent asterisk, so it runs in O(len(pattern)*len(text)).
+func globMatch(pattern string, text string) bool {
+	p, t := 0, 0
+	starP, starT := -1, 0
+
+	for t < len(text) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			// Remember the asterisk and first try to let it match nothing
+			starP, starT = p, t
+			p++
+		case p < len(pattern) && pattern[p] == text[t]:
+			p++
+			t++
+		case starP >= 0:
+			// Let the last asterisk swallow one more character and retry
+			starT++
+			p, t = starP+1, starT
+		default:
+			return false
+		}
 	}
 
-	// At most one wildcard asterisk allowed
-	if strings.Count(filter, "*") > 1 {
+	// Remaining pattern characters may only be asterisks
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// validateUserFilter validates a target user filter: either an email address or a pattern with
+// wildcards, which needs a local part, an "@", and a domain that is a valid DNS name once the
+// wildcards are substituted. Filters made only of wildcards (e.g. "*" or "*@*") match every user
+// and are only accepted if allowMatchAll is set.
+func validateUserFilter(filter string, allowMatchAll bool) error {
+	errInvalidUserFilter := errors.New("user filter must be a valid email or a wildcard pattern like *@domain.com or admin-*@*.de")
+
+	// Non-empty check
+	if filter == "" {
 		return errInvalidUserFilter
 	}
 
@@ -326,31 +2188,119 @@ func validateUserFilter(filter string) error {
 		return nil
 	}
 
-	return nil
+	if strings.Trim(filter, "*@.") == "" {
+		if allowMatchAll {
+			return nil
+		}
+		return errors.New("user filter must not match every user (set DNS_POLICY_ALLOW_MATCH_ALL_USER_FILTERS to allow this)")
+	}
+
+	// Validate the pattern as an address with each wildcard substituted by a letter
+	local, domain, found := strings.Cut(filter, "@")
+	if !found || local == "" || strings.Contains(domain, "@") {
+		return errInvalidUserFilter
+	}
+	if address, err := mail.ParseAddress(strings.ReplaceAll(filter, "*", "a")); err != nil || address.Name != "" {
+		return errInvalidUserFilter
+	}
+	return validateDnsName("domain of the user filter", domain, func(label string) string {
+		label = strings.ReplaceAll(label, "*", "a")
+		// Internationalized labels are validated in their Punycode form
+		if asciiLabel, err := idna.Punycode.ToASCII(label); err == nil {
+			return asciiLabel
+		}
+		return label
+	})
 }
 
-func isSuperAdmin(app *config.AppData, user *auth.UserClaims) bool {
-	superAdmins := app.Config.DnsPolicyConfig.SuperAdminEmails
+// ruleUUIDRegex matches a rule UUID in its canonical form (case-insensitive).
+var ruleUUIDRegex = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
 
-	if _, exists := superAdmins[strings.ToLower(user.Email)]; exists {
-		return true
+// parseRuleKey resolves the rule key of the ":id" path parameter to the rule ID according to the
+// configured key type. On failure, it responds with 400 (malformed key) or 404 (unknown UUID) and returns false.
+func parseRuleKey(c *gin.Context, app *config.AppData) (int64, bool) {
+	key := RuleKey{UUID: c.Param("id")}
+	if app.Config.DnsPolicyConfig.RuleKeyType != "uuid" {
+		id, err := strconv.ParseInt(key.UUID, 10, 64)
+		if err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid rule ID")
+			return 0, false
+		}
+		key = RuleKey{ID: id}
 	}
+	return resolveRuleKey(c, app, key)
+}
 
-	return false
+// RuleKey identifies a rule in request and response bodies according to DNS_POLICY_RULE_KEY_TYPE: by its ID
+// (a JSON number) or, in uuid mode, by its UUID (a JSON string), so the sequential IDs are not exposed.
+type RuleKey struct {
+	ID   int64
+	UUID string
 }
 
-// isValidZonePattern converts the provided JavaScript function to Go.
-// It validates a zone pattern by temporarily replacing the custom '%u' placeholder
-// with a valid character ('A') before performing standard DNS label checks.
-func validateZonePattern(value string) bool {
-	if value == "" {
-		return false
+// newRuleKey returns the key of a rule according to the configured key type.
+func newRuleKey(policyConfig config.DnsPolicyConfig, rule storage.PolicyRule) RuleKey {
+	if policyConfig.RuleKeyType == "uuid" {
+		return RuleKey{UUID: rule.UUID}
+	}
+	return RuleKey{ID: rule.ID}
+}
+
+// MarshalJSON writes the UUID of the key if set, and the ID otherwise.
+func (k RuleKey) MarshalJSON() ([]byte, error) {
+	if k.UUID != "" {
+		return json.Marshal(k.UUID)
+	}
+	return json.Marshal(k.ID)
+}
+
+// UnmarshalJSON reads a number as ID and a string as UUID.
+func (k *RuleKey) UnmarshalJSON(data []byte) error {
+	*k = RuleKey{}
+	if strings.HasPrefix(string(data), `"`) {
+		return json.Unmarshal(data, &k.UUID)
+	}
+	return json.Unmarshal(data, &k.ID)
+}
+
+// errInvalidRuleKey is returned for a rule key not matching the configured key type.
+var errInvalidRuleKey = errors.New("invalid rule key")
+
+// ruleIDForKey returns the ID of the rule with the key. It returns errInvalidRuleKey if the key does not match
+// the configured key type, and gorm.ErrRecordNotFound for an unknown UUID. IDs are not checked for existence.
+func ruleIDForKey(ctx context.Context, app *config.AppData, key RuleKey) (int64, error) {
+	if app.Config.DnsPolicyConfig.RuleKeyType != "uuid" {
+		if key.UUID != "" {
+			return 0, errInvalidRuleKey
+		}
+		return key.ID, nil
 	}
 
-	// 1. Replace '%u' with 'A' and trim whitespace
-	s := strings.ReplaceAll(value, "%u", "A")
-	s = strings.TrimSpace(s)
+	if key.ID != 0 || !ruleUUIDRegex.MatchString(key.UUID) {
+		return 0, errInvalidRuleKey
+	}
+	return app.Storage.PolicyGetIDByUUIDCtx(ctx, strings.ToLower(key.UUID))
+}
 
-	// Use existing DNS domain validation
-	return helper.DnsValidateName(s)
+// resolveRuleKey returns the ID of the rule with the key. On failure, it responds with 400 (malformed key)
+// or 404 (unknown UUID) and returns false.
+func resolveRuleKey(c *gin.Context, app *config.AppData, key RuleKey) (int64, bool) {
+	id, err := ruleIDForKey(c.Request.Context(), app, key)
+	switch {
+	case errors.Is(err, errInvalidRuleKey) && app.Config.DnsPolicyConfig.RuleKeyType == "uuid":
+		helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid rule UUID")
+	case errors.Is(err, errInvalidRuleKey):
+		helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "Invalid rule ID")
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		helper.RespondError(c, http.StatusNotFound, helper.ErrorCodeRuleNotFound, "Rule not found")
+	case err != nil:
+		helper.RequestLogger(c, app.Log).Warnf("Failed to resolve rule UUID %s: %v", key.UUID, err)
+		helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to resolve rule")
+	default:
+		return id, true
+	}
+	return 0, false
 }
+
+// zonePatternPlaceholders replaces the supported placeholders (see ExpandZonePattern) by a valid label character.
+var zonePatternPlaceholders = strings.NewReplacer("%u", "A", "%d", "A", "%g", "A")
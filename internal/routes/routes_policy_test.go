@@ -0,0 +1,693 @@
+package routes
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/farberg/cloud-self-service-api/internal/auth"
+	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"github.com/farberg/cloud-self-service-api/internal/storage"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    bool
+	}{
+		{"jane@example.org", "jane@example.org", true},
+		{"jane@example.org", "john@example.org", false},
+		{"*@example.org", "jane@example.org", true},
+		{"*@example.org", "jane@example.org.evil", false},
+		{"*@example.org", "jane@sub.example.org", false},
+		{"*@*.example.org", "jane@sub.example.org", true},
+		{"*@*.example.org", "jane@example.org", false},
+		{"admin-*@*.de", "admin-jane@dhbw.de", true},
+		{"admin-*@*.de", "jane@dhbw.de", false},
+		{"*-admin@*", "jane-admin@example.org", true},
+		{"j*e@ex*le.*", "jane@example.org", true},
+		{"j*e@ex*le.*", "jane@exile.org", true},
+		{"j*e@ex*le.*", "john@example.org", false},
+		{"**@example.org", "jane@example.org", true},
+		{"*", "", true},
+		{"*", "jane@example.org", true},
+		{"", "", true},
+		{"", "jane@example.org", false},
+		// The pattern may only consume the text as a whole
+		{"*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+		{"*a*b", "aaaaaaaaaaaaaaaaaaaaaaab", true},
+	}
+
+	for _, test := range tests {
+		if got := globMatch(test.pattern, test.text); got != test.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", test.pattern, test.text, got, test.want)
+		}
+	}
+}
+
+func TestMatchesUserFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		email  string
+		want   bool
+	}{
+		{"exact", "jane@example.org", "jane@example.org", true},
+		{"case-insensitive domain", "*@example.org", "jane@EXAMPLE.org", true},
+		{"case-insensitive filter", "*@DHBW.de", "jane@dhbw.de", true},
+		{"case-insensitive local part", "Jane@example.org", "JANE@example.org", true},
+		{"multiple wildcards", "admin-*@*.DHBW.de", "Admin-Jane@Mannheim.dhbw.DE", true},
+		{"unicode filter and punycode email", "*@müller.de", "jane@XN--MLLER-KVA.DE", true},
+		{"punycode filter and unicode email", "*@xn--mller-kva.de", "jane@MÜLLER.de", true},
+		{"different idn domain", "*@müller.de", "jane@mueller.de", false},
+		{"other domain", "*@example.org", "jane@example.com", false},
+		{"empty filter", "", "jane@example.org", false},
+		{"empty email", "*", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MatchesUserFilter(test.filter, &auth.UserClaims{Email: test.email}); got != test.want {
+				t.Errorf("MatchesUserFilter(%q, %q) = %v, want %v", test.filter, test.email, got, test.want)
+			}
+		})
+	}
+
+	if MatchesUserFilter("*", nil) {
+		t.Error("a filter must not match without claims")
+	}
+}
+
+func TestValidateUserFilter(t *testing.T) {
+	tests := []struct {
+		filter        string
+		allowMatchAll bool
+		valid         bool
+	}{
+		{"jane@example.org", false, true},
+		{"*@example.org", false, true},
+		{"admin-*@*.de", false, true},
+		{"*@müller.de", false, true},
+		{"*@xn--mller-kva.de", false, true},
+		{"", false, false},
+		{"not-an-email", false, false},
+		{"foo*bar", false, false},
+		{"*@", false, false},
+		{"@example.org", false, false},
+		{"*@example..org", false, false},
+		{"*@-example.org", false, false},
+		{"*@exa_mple.org", false, false},
+		{"*@*@example.org", false, false},
+		{"Jane <*@example.org>", false, false},
+		{"*@*", false, false},
+		{"*", false, false},
+		{"*@*.*", false, false},
+		{"*@*", true, true},
+		{"*", true, true},
+		{"foo*bar", true, false},
+	}
+	for _, test := range tests {
+		if err := validateUserFilter(test.filter, test.allowMatchAll); (err == nil) != test.valid {
+			t.Errorf("validateUserFilter(%q, %v) = %v, want valid %v", test.filter, test.allowMatchAll, err, test.valid)
+		}
+	}
+}
+
+func TestCreateMatchAllUserFilter(t *testing.T) {
+	app := newTestApp(t)
+	router := newTestRouter(app)
+	body := `{"zone_pattern":"%u.users.example.org","zone_soa":"users.example.org","target_user_filter":"*@*"}`
+
+	apiError := decodeResponse[helper.APIError](t, performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, body), 422)
+	if details, ok := apiError.Details.(map[string]any); !ok || details["target_user_filter"] == nil {
+		t.Fatalf("expected a validation error of target_user_filter, got %+v", apiError)
+	}
+
+	app.Config.DnsPolicyConfig.AllowMatchAllUserFilters = true
+	decodeResponse[storage.PolicyRule](t, performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, body), 201)
+}
+
+func TestValidateZonePattern(t *testing.T) {
+	longLabel := strings.Repeat("a", 63)
+	tests := []struct {
+		name    string
+		pattern string
+		valid   bool
+	}{
+		{"user placeholder", "%u.users.example.org", true},
+		{"several placeholders", "%g-%d.example.org", true},
+		{"longest label", longLabel + ".example.org", true},
+		{"empty", "", false},
+		{"single label", "example", false},
+		{"empty label", "a..example.org", false},
+		{"overlong label", longLabel + "a.example.org", false},
+		{"overlong name", strings.Join([]string{longLabel, longLabel, longLabel, longLabel}, "."), false},
+		{"leading hyphen", "-a.example.org", false},
+		{"trailing hyphen", "a-.example.org", false},
+		{"hyphen before placeholder", "-%u.example.org", false},
+		{"invalid character", "a_b.example.org", false},
+		{"unsupported placeholder", "%x.example.org", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidateZonePattern(test.pattern); (err == nil) != test.valid {
+				t.Fatalf("ValidateZonePattern(%q) = %v, want valid %v", test.pattern, err, test.valid)
+			}
+		})
+	}
+
+	if err := validateZoneSoa("%u.example.org"); err == nil {
+		t.Fatal("expected a zone SOA with a placeholder to be rejected")
+	}
+	if err := validateZoneSoa("-example.org"); err == nil {
+		t.Fatal("expected a zone SOA with a leading hyphen to be rejected")
+	}
+}
+
+func TestCreateInvalidZonePattern(t *testing.T) {
+	app := newTestApp(t)
+	router := newTestRouter(app)
+
+	body := fmt.Sprintf(`{"zone_pattern":"%%u.%s.example.org","zone_soa":"-example.org","target_user_filter":"*@example.org"}`, strings.Repeat("a", 64))
+	apiError := decodeResponse[helper.APIError](t, performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, body), 422)
+	details, ok := apiError.Details.(map[string]any)
+	if !ok || details["zone_pattern"] == nil || details["zone_soa"] == nil {
+		t.Fatalf("expected validation errors of zone_pattern and zone_soa, got %+v", apiError)
+	}
+
+	body = `{"zone_pattern":"","zone_soa":"example.org","target_user_filter":"*@example.org"}`
+	if apiError := decodeResponse[helper.APIError](t, performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, body), 422); apiError.Code != helper.ErrorCodeValidationFailed {
+		t.Fatalf("expected an empty zone pattern to be rejected, got %+v", apiError)
+	}
+}
+
+func TestRuleFieldsByRole(t *testing.T) {
+	app := newTestApp(t)
+	own := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.own.example.org", ZoneSoa: "own.example.org", TargetUserFilter: "*@example.org", OwnerEmail: "jane@example.org"})
+	other := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.other.example.org", ZoneSoa: "other.example.org", TargetUserFilter: "*@example.org", OwnerEmail: "john@example.org"})
+	router := newTestRouter(app)
+
+	type rulesList struct {
+		Rules []map[string]any `json:"rules"`
+	}
+
+	// SuperAdmins get the full representation
+	list := decodeResponse[rulesList](t, performRequest(router, "GET", "/v1/policies/rules", testSuperAdmin, ""), 200)
+	if len(list.Rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(list.Rules))
+	}
+	for _, rule := range list.Rules {
+		for _, field := range []string{"status", "owner_email", "version", "uuid"} {
+			if _, ok := rule[field]; !ok {
+				t.Errorf("field '%s' missing for a super admin: %v", field, rule)
+			}
+		}
+	}
+
+	// Other users get the visible fields only, and the owner email only of their own rules
+	list = decodeResponse[rulesList](t, performRequest(router, "GET", "/v1/policies/rules", "jane@example.org", ""), 200)
+	if len(list.Rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(list.Rules))
+	}
+	for _, rule := range list.Rules {
+		for field := range rule {
+			if _, visible := app.Config.DnsPolicyConfig.UserVisibleRuleFields[field]; !visible {
+				t.Errorf("invisible field '%s' returned to a user: %v", field, rule)
+			}
+		}
+		_, hasOwner := rule["owner_email"]
+		if isOwn := rule["id"] == float64(own.ID); hasOwner != isOwn {
+			t.Errorf("owner email returned: %v, expected: %v (%v)", hasOwner, isOwn, rule)
+		}
+	}
+
+	rule := decodeResponse[map[string]any](t, performRequest(router, "GET", fmt.Sprintf("/v1/policies/rules/%d", other.ID), "jane@example.org", ""), 200)
+	if _, ok := rule["owner_email"]; ok {
+		t.Errorf("owner email of another user returned: %v", rule)
+	}
+	if _, ok := rule["status"]; ok {
+		t.Errorf("status returned to a user: %v", rule)
+	}
+	rule = decodeResponse[map[string]any](t, performRequest(router, "GET", fmt.Sprintf("/v1/policies/rules/%d", other.ID), testSuperAdmin, ""), 200)
+	if rule["owner_email"] != "john@example.org" || rule["status"] != storage.RuleStatusApproved {
+		t.Errorf("incomplete rule returned to a super admin: %v", rule)
+	}
+}
+
+func TestPreviewAt(t *testing.T) {
+	app := newTestApp(t)
+	router := newTestRouter(app)
+	preview := func(at time.Time) *httptest.ResponseRecorder {
+		return performRequest(router, "GET", "/v1/policies/preview?email=jane@example.org&at="+url.QueryEscape(at.Format(time.RFC3339Nano)), testSuperAdmin, "")
+	}
+
+	// Without an audit log, the rules cannot be reconstructed
+	if apiError := decodeResponse[helper.APIError](t, preview(time.Now()), 422); apiError.Code != helper.ErrorCodeHistoryUnavailable {
+		t.Fatalf("unexpected error %+v", apiError)
+	}
+
+	beforeCreate := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	rule := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	time.Sleep(5 * time.Millisecond)
+	afterCreate := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	if err := app.Storage.PolicyDelete(rule.ID); err != nil {
+		t.Fatalf("failed to delete rule: %v", err)
+	}
+
+	// The deleted rule still applies at a time it existed
+	response := decodeResponse[PreviewResponse](t, preview(afterCreate), 200)
+	if len(response.Zones) != 1 || response.Zones[0].Zone != "jane.users.example.org" || response.Zones[0].RuleID != (RuleKey{ID: rule.ID}) {
+		t.Fatalf("expected the zone of the deleted rule, got %+v", response.Zones)
+	}
+	if response.At == nil || !response.At.Equal(afterCreate) {
+		t.Fatalf("expected the evaluation time %v, got %v", afterCreate, response.At)
+	}
+
+	response = decodeResponse[PreviewResponse](t, performRequest(router, "GET", "/v1/policies/preview?email=jane@example.org", testSuperAdmin, ""), 200)
+	if len(response.Zones) != 0 || response.At != nil {
+		t.Fatalf("expected no zones now, got %+v", response)
+	}
+
+	if apiError := decodeResponse[helper.APIError](t, preview(beforeCreate), 422); apiError.Code != helper.ErrorCodeHistoryUnavailable {
+		t.Fatalf("unexpected error %+v", apiError)
+	}
+	for _, at := range []string{"yesterday", url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))} {
+		if w := performRequest(router, "GET", "/v1/policies/preview?email=jane@example.org&at="+at, testSuperAdmin, ""); w.Code != 400 {
+			t.Fatalf("expected 400 for at '%s', got %d", at, w.Code)
+		}
+	}
+}
+
+func TestRuleCreateRateLimit(t *testing.T) {
+	app := newTestApp(t)
+	app.Config.DnsPolicyConfig.UserRuleSubmissionEnabled = true
+	app.Config.DnsPolicyConfig.MaxRuleCreatesPerHour = 2
+	// Rules created more than an hour ago do not count
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.old.example.org", ZoneSoa: "old.example.org", TargetUserFilter: "*@example.org", OwnerEmail: "jane@example.org", CreatedAt: time.Now().Add(-61 * time.Minute)})
+	router := newTestRouter(app)
+
+	create := func(user string, i int) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"zone_pattern":"%%u.zone-%d.example.org","zone_soa":"zone-%d.example.org","target_user_filter":"*@example.org"}`, i, i)
+		return performRequest(router, "POST", "/v1/policies/rules", user, body)
+	}
+
+	// Creates up to the limit are accepted
+	for i := range 2 {
+		if w := create("jane@example.org", i); w.Code != 201 {
+			t.Fatalf("create %d: expected 201, got %d: %s", i, w.Code, w.Body.String())
+		}
+	}
+
+	// The create beyond the limit is rejected until the oldest create leaves the window
+	w := create("jane@example.org", 2)
+	if apiError := decodeResponse[helper.APIError](t, w, 429); apiError.Code != helper.ErrorCodeRateLimited {
+		t.Fatalf("unexpected error %+v", apiError)
+	}
+	if retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retryAfter < 3590 || retryAfter > 3600 {
+		t.Fatalf("expected a Retry-After of about an hour, got '%s'", w.Header().Get("Retry-After"))
+	}
+
+	// The limit applies per owner, and super admins bypass it
+	if w := create("john@example.org", 3); w.Code != 201 {
+		t.Fatalf("expected 201 for another owner, got %d: %s", w.Code, w.Body.String())
+	}
+	for i := 4; i < 7; i++ {
+		if w := create(testSuperAdmin, i); w.Code != 201 {
+			t.Fatalf("expected 201 for a super admin, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	// Zero disables the limit
+	app.Config.DnsPolicyConfig.MaxRuleCreatesPerHour = 0
+	if w := create("jane@example.org", 7); w.Code != 201 {
+		t.Fatalf("expected 201 without a limit, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCreateDuplicateZonePattern(t *testing.T) {
+	app := newTestApp(t)
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+
+	// The duplicate is caught by the conflict check; concurrent creates are caught by the unique constraint
+	// (ErrDuplicateZonePattern, see the storage tests). Both are conflicts, not internal errors.
+	w := performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, `{"zone_pattern":"%u.users.example.org","zone_soa":"users.example.org","target_user_filter":"*@example.org"}`)
+	if apiError := decodeResponse[helper.APIError](t, w, 409); apiError.Code != helper.ErrorCodeConflictingZone {
+		t.Fatalf("unexpected error %+v", apiError)
+	}
+}
+
+func TestConflictingZonePatternIDs(t *testing.T) {
+	app := newTestApp(t)
+	literal := createTestRule(t, app, storage.PolicyRule{ZonePattern: "project.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"})
+	users := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+
+	// A literal duplicate differing only in the SOA is reported with the ID of the conflicting rule
+	w := performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, `{"zone_pattern":"project.example.org","zone_soa":"project.example.org","target_user_filter":"*@example.org"}`)
+	apiError := decodeResponse[helper.APIError](t, w, 409)
+	if details, ok := apiError.Details.(map[string]any); !ok || fmt.Sprint(details["conflicting_rule_ids"]) != fmt.Sprintf("[%d]", literal.ID) {
+		t.Fatalf("expected the ID of the conflicting rule, got %+v", apiError)
+	}
+
+	// Patterns differing only in the placeholder conflict as well
+	w = performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, `{"zone_pattern":"%g.users.example.org","zone_soa":"users.example.org","target_user_filter":"*@example.org"}`)
+	apiError = decodeResponse[helper.APIError](t, w, 409)
+	if details, ok := apiError.Details.(map[string]any); !ok || fmt.Sprint(details["conflicting_rule_ids"]) != fmt.Sprintf("[%d]", users.ID) {
+		t.Fatalf("expected the ID of the conflicting rule, got %+v", apiError)
+	}
+}
+
+func TestCreateRuleExpiry(t *testing.T) {
+	app := newTestApp(t)
+	router := newTestRouter(app)
+	create := func(expiresAt time.Time) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"zone_pattern":"%%u.users.example.org","zone_soa":"users.example.org","target_user_filter":"*@example.org","expires_at":"%s"}`, expiresAt.Format(time.RFC3339))
+		return performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, body)
+	}
+
+	apiError := decodeResponse[helper.APIError](t, create(time.Now().Add(-time.Minute)), 422)
+	if details, ok := apiError.Details.(map[string]any); !ok || details["expires_at"] == nil {
+		t.Fatalf("expected a validation error of expires_at, got %+v", apiError)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	rule := decodeResponse[storage.PolicyRule](t, create(expiresAt), 201)
+	if rule.ExpiresAt == nil || !rule.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("expected the rule to expire at %v, got %v", expiresAt, rule.ExpiresAt)
+	}
+}
+
+func TestCountRulesBySOA(t *testing.T) {
+	app := newTestApp(t)
+	router := newTestRouter(app)
+	if counts := decodeResponse[map[string]int64](t, performRequest(router, "GET", "/v1/policies/stats/by-soa", testSuperAdmin, ""), 200); len(counts) != 0 {
+		t.Fatalf("expected no counts, got %v", counts)
+	}
+
+	for i, soa := range []string{"a.example.org", "b.example.org", "A.example.org"} {
+		createTestRule(t, app, storage.PolicyRule{ZonePattern: fmt.Sprintf("%%u.zone-%d.example.org", i), ZoneSoa: soa, TargetUserFilter: "*@example.org"})
+	}
+	counts := decodeResponse[map[string]int64](t, performRequest(router, "GET", "/v1/policies/stats/by-soa", testSuperAdmin, ""), 200)
+	if len(counts) != 2 || counts["a.example.org"] != 2 || counts["b.example.org"] != 1 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+
+	if w := performRequest(router, "GET", "/v1/policies/stats/by-soa", "jane@example.org", ""); w.Code != 403 {
+		t.Fatalf("expected 403 for a user, got %d", w.Code)
+	}
+}
+
+func TestImportPolicyRules(t *testing.T) {
+	app := newTestApp(t)
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.old.example.org", ZoneSoa: "old.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+	importStatuses := func(response ImportResponse) []string {
+		statuses := make([]string, len(response.Results))
+		for i, result := range response.Results {
+			statuses[i] = result.Status
+		}
+		return statuses
+	}
+
+	if w := performRequest(router, "POST", "/v1/policies/import", "jane@example.org", `[{}]`); w.Code != 403 {
+		t.Fatalf("expected 403 for a user, got %d", w.Code)
+	}
+	if w := performRequest(router, "POST", "/v1/policies/import?on_conflict=merge", testSuperAdmin, `[{}]`); w.Code != 400 {
+		t.Fatalf("expected 400 for an invalid on_conflict value, got %d", w.Code)
+	}
+
+	// An invalid rule is reported with its fields, and nothing is created
+	body := `[{"zone_pattern":"%u.a.example.org","zone_soa":"a.example.org","target_user_filter":"*@example.org"},{"zone_pattern":"-bad.example.org","zone_soa":"example.org","target_user_filter":"*@example.org"}]`
+	response := decodeResponse[ImportResponse](t, performRequest(router, "POST", "/v1/policies/import", testSuperAdmin, body), 422)
+	if !slices.Equal(importStatuses(response), []string{"aborted", "invalid"}) || len(response.Results[1].Fields) == 0 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	body = `[{"zone_pattern":"%u.a.example.org","zone_soa":"a.example.org","target_user_filter":"*@example.org"},
+		{"zone_pattern":"%u.old.example.org","zone_soa":"old.example.org","target_user_filter":"*@example.org"},
+		{"zone_pattern":"%g.a.example.org","zone_soa":"a.example.org","target_user_filter":"*@example.org"},
+		{"zone_pattern":"shared.a.example.org","zone_soa":"a.example.org","target_user_filter":"*@example.org","access_level":"view"}]`
+
+	// With on_conflict=abort, a duplicate aborts the import
+	response = decodeResponse[ImportResponse](t, performRequest(router, "POST", "/v1/policies/import?on_conflict=abort", testSuperAdmin, body), 409)
+	if response.Created != 0 || response.Results[1].Status != "duplicate" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	if rules, _ := app.Storage.PolicyGetAll(); len(rules) != 1 {
+		t.Fatalf("expected no rule to be created, got %d rules", len(rules))
+	}
+
+	// By default, duplicates and conflicts are skipped and the other rules are created
+	response = decodeResponse[ImportResponse](t, performRequest(router, "POST", "/v1/policies/import", testSuperAdmin, body), 200)
+	if response.Created != 2 || response.Failed != 2 || !slices.Equal(importStatuses(response), []string{"created", "duplicate", "conflict", "created"}) {
+		t.Fatalf("unexpected response %+v", response)
+	}
+	if rule := response.Results[3].Rule; rule == nil || rule.AccessLevel != storage.AccessLevelView || rule.OwnerEmail != testSuperAdmin {
+		t.Fatalf("unexpected created rule %+v", response.Results[3].Rule)
+	}
+}
+
+func TestExportPolicyRules(t *testing.T) {
+	app := newTestApp(t)
+	router := newTestRouter(app)
+
+	w := performRequest(router, "GET", "/v1/policies/export", testSuperAdmin, "")
+	if w.Code != 200 || w.Body.String() != "[]" {
+		t.Fatalf("expected an empty array, got %d: %s", w.Code, w.Body.String())
+	}
+
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.a.example.org", ZoneSoa: "a.example.org", TargetUserFilter: "*@example.org"})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.b.example.org", ZoneSoa: "b.example.org", TargetUserFilter: "*@example.org"})
+	if w := performRequest(router, "GET", "/v1/policies/export", "jane@example.org", ""); w.Code != 403 {
+		t.Fatalf("expected 403 for a user, got %d", w.Code)
+	}
+	w = performRequest(router, "GET", "/v1/policies/export", testSuperAdmin, "")
+	exported := decodeResponse[[]storage.PolicyRule](t, w, 200)
+	if len(exported) != 2 || exported[0].ZonePattern != "%u.a.example.org" || !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("unexpected export %s", w.Body.String())
+	}
+
+	// Re-importing the export only reports duplicates
+	response := decodeResponse[ImportResponse](t, performRequest(router, "POST", "/v1/policies/import", testSuperAdmin, w.Body.String()), 200)
+	if response.Created != 0 || response.Failed != 2 {
+		t.Fatalf("unexpected response %+v", response)
+	}
+}
+
+func TestSearchPolicyRules(t *testing.T) {
+	app := newTestApp(t)
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org", Description: "Personal zones"})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.staff.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@staff.example.org", Description: "Staff zones"})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.com", ZoneSoa: "example.com", TargetUserFilter: "*@example.org", Description: "Personal zones"})
+	router := newTestRouter(app)
+	search := func(query string) []string {
+		t.Helper()
+		response := decodeResponse[struct {
+			Rules []storage.PolicyRule `json:"rules"`
+		}](t, performRequest(router, "GET", "/v1/policies/rules?"+query, testSuperAdmin, ""), 200)
+		patterns := make([]string, len(response.Rules))
+		for i, rule := range response.Rules {
+			patterns[i] = rule.ZonePattern
+		}
+		return patterns
+	}
+
+	if got := search("zone_soa=EXAMPLE.org&target_filter=*@example.org"); !slices.Equal(got, []string{"%u.users.example.org"}) {
+		t.Fatalf("unexpected rules for SOA and target filter: %v", got)
+	}
+	if got := search("zone_soa=example.com&q=personal"); !slices.Equal(got, []string{"%u.users.example.com"}) {
+		t.Fatalf("unexpected rules for SOA and query: %v", got)
+	}
+	if got := search("zone_soa=example.org&target_filter=*@staff.example.org&q=staff"); !slices.Equal(got, []string{"%u.staff.example.org"}) {
+		t.Fatalf("unexpected rules for all filters: %v", got)
+	}
+
+	// An empty result is an empty array
+	w := performRequest(router, "GET", "/v1/policies/rules?zone_soa=example.com&q=staff", testSuperAdmin, "")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"rules":[]`) {
+		t.Fatalf("expected an empty array, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := performRequest(router, "GET", "/v1/policies/rules?q=staff&limit=1", testSuperAdmin, ""); w.Code != 400 {
+		t.Fatalf("expected 400 for a search with pagination, got %d", w.Code)
+	}
+}
+
+func TestUpdatePolicyRuleIfMatch(t *testing.T) {
+	app := newTestApp(t)
+	rule := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+	path := "/v1/policies/rules/" + strconv.FormatInt(rule.ID, 10)
+	update := func(ifMatch string, description string) *httptest.ResponseRecorder {
+		body := `{"zone_pattern":"%u.users.example.org","zone_soa":"example.org","target_user_filter":"*@example.org","description":"` + description + `"}`
+		req := httptest.NewRequest("PUT", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(testUserHeader, testSuperAdmin)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Both admins read the rule with the same ETag
+	w := performRequest(router, "GET", path, testSuperAdmin, "")
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || etag != `"1"` {
+		t.Fatalf("expected ETag \"1\", got %d with '%s'", w.Code, etag)
+	}
+
+	if w := update("", "no version"); w.Code != 428 {
+		t.Fatalf("expected 428 without If-Match, got %d", w.Code)
+	}
+	if w := update(`"latest"`, "invalid version"); w.Code != 400 {
+		t.Fatalf("expected 400 for an invalid If-Match, got %d", w.Code)
+	}
+
+	// The first update wins, the stale one loses
+	w = update(etag, "first")
+	if w.Code != 200 || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("expected the first update to succeed with ETag \"2\", got %d with '%s': %s", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+	if w := update(etag, "second"); w.Code != 412 {
+		t.Fatalf("expected 412 for the stale update, got %d: %s", w.Code, w.Body.String())
+	}
+	stored := decodeResponse[storage.PolicyRule](t, performRequest(router, "GET", path, testSuperAdmin, ""), 200)
+	if stored.Version != 2 || stored.Description != "first" {
+		t.Fatalf("the stale update changed the rule: %+v", stored)
+	}
+}
+
+func TestUpdatePolicyRuleZoneSoa(t *testing.T) {
+	app := newTestApp(t)
+	rule := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+	path := "/v1/policies/rules/" + strconv.FormatInt(rule.ID, 10)
+
+	body := `{"zone_pattern":"%u.users.example.org","zone_soa":"users.example.org","target_user_filter":"*@example.org"}`
+	req := httptest.NewRequest("PUT", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(testUserHeader, testSuperAdmin)
+	req.Header.Set("If-Match", `"1"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if updated := decodeResponse[storage.PolicyRule](t, w, 200); updated.ZoneSoa != "users.example.org" {
+		t.Fatalf("expected the updated SOA in the response, got %+v", updated)
+	}
+
+	stored := decodeResponse[storage.PolicyRule](t, performRequest(router, "GET", path, testSuperAdmin, ""), 200)
+	if stored.ZoneSoa != "users.example.org" {
+		t.Fatalf("expected the updated SOA to be stored, got %+v", stored)
+	}
+}
+
+func TestRuleKeysInUUIDMode(t *testing.T) {
+	app := newTestApp(t)
+	app.Config.DnsPolicyConfig.RuleKeyType = "uuid"
+	app.Config.DnsPolicyConfig.WebhookIncludeRuleID = true
+	users := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "jane@example.org"})
+	team := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.team.example.org", ZoneSoa: "team.example.org", TargetUserFilter: "john@example.org"})
+	router := newTestRouter(app)
+
+	// Neither super admins nor users see the integer IDs
+	for _, user := range []string{testSuperAdmin, "jane@example.org"} {
+		rule := decodeResponse[map[string]any](t, performRequest(router, "GET", "/v1/policies/rules/"+users.UUID, user, ""), 200)
+		if _, ok := rule["id"]; ok || rule["uuid"] != users.UUID {
+			t.Errorf("expected the UUID but no ID for %s, got %v", user, rule)
+		}
+	}
+
+	zones := callWebhook(t, router, "", auth.UserClaims{Email: "jane@example.org"})
+	if len(zones) != 1 || zones[0].RuleID == nil || *zones[0].RuleID != (RuleKey{UUID: users.UUID}) {
+		t.Fatalf("expected the zone of rule %s, got %+v", users.UUID, zones)
+	}
+	if w := performRequest(router, "POST", "/v1/webhook/dns-policy", "", `{"email":"jane@example.org"}`); !strings.Contains(w.Body.String(), `"rule_id":"`+users.UUID+`"`) {
+		t.Fatalf("expected the UUID as rule_id, got %s", w.Body.String())
+	}
+
+	// Body-based endpoints take UUIDs and reject numeric keys
+	body := fmt.Sprintf(`{"ids":[%d],"enabled":false}`, users.ID)
+	if apiError := decodeResponse[helper.APIError](t, performRequest(router, "POST", "/v1/policies/set-enabled", testSuperAdmin, body), 400); apiError.Code != helper.ErrorCodeInvalidRequest {
+		t.Fatalf("expected a numeric key to be rejected, got %+v", apiError)
+	}
+	body = fmt.Sprintf(`{"ids":["%s","00000000-0000-4000-8000-000000000000"],"enabled":false}`, users.UUID)
+	if response := decodeResponse[SetEnabledResponse](t, performRequest(router, "POST", "/v1/policies/set-enabled", testSuperAdmin, body), 200); response.Changed != 1 {
+		t.Fatalf("expected 1 changed rule, got %+v", response)
+	}
+
+	body = fmt.Sprintf(`{"keep_id":%d,"merge_id":%d,"combined_filter":"*@example.org"}`, users.ID, team.ID)
+	if w := performRequest(router, "POST", "/v1/policies/merge", testSuperAdmin, body); w.Code != 400 {
+		t.Fatalf("expected numeric keys to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	body = fmt.Sprintf(`{"keep_id":"%s","merge_id":"00000000-0000-4000-8000-000000000000","combined_filter":"*@example.org"}`, users.UUID)
+	if w := performRequest(router, "POST", "/v1/policies/merge", testSuperAdmin, body); w.Code != 404 {
+		t.Fatalf("expected an unknown UUID to be reported, got %d: %s", w.Code, w.Body.String())
+	}
+	body = fmt.Sprintf(`{"keep_id":"%s","merge_id":"%s","combined_filter":"*@example.org"}`, users.UUID, team.UUID)
+	merged := decodeResponse[map[string]any](t, performRequest(router, "POST", "/v1/policies/merge", testSuperAdmin, body), 200)
+	if _, ok := merged["id"]; ok || merged["uuid"] != users.UUID || merged["target_user_filter"] != "*@example.org" {
+		t.Fatalf("expected the kept rule without ID, got %v", merged)
+	}
+}
+
+func TestCreatePolicyRuleDeletedZonePattern(t *testing.T) {
+	app := newTestApp(t)
+	rule := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+	path := "/v1/policies/rules/" + strconv.FormatInt(rule.ID, 10)
+	if w := performRequest(router, "DELETE", path, testSuperAdmin, ""); w.Code != 200 {
+		t.Fatalf("failed to delete rule: %d %s", w.Code, w.Body.String())
+	}
+
+	// The deleted rule is neither purged nor replaced silently
+	body := `{"zone_pattern":"%u.users.example.org","zone_soa":"users.example.org","target_user_filter":"*@example.org"}`
+	if apiError := decodeResponse[helper.APIError](t, performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, body), 409); apiError.Code != helper.ErrorCodeDeletedZone {
+		t.Fatalf("expected a deleted zone error, got %+v", apiError)
+	}
+	if rules, err := app.Storage.PolicyGetAllIncludingDeleted(); err != nil || len(rules) != 1 || !rules[0].DeletedAt.Valid {
+		t.Fatalf("expected the deleted rule to be kept, got %+v (%v)", rules, err)
+	}
+
+	if w := performRequest(router, "DELETE", path+"?purge=true", testSuperAdmin, ""); w.Code != 200 {
+		t.Fatalf("failed to purge rule: %d %s", w.Code, w.Body.String())
+	}
+	if w := performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, body); w.Code != 201 {
+		t.Fatalf("expected the rule to be created after the purge, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAuditLogRequestBody(t *testing.T) {
+	app := newTestApp(t)
+	router := newTestRouter(app)
+
+	body := `{"zone_pattern":"%u.users.example.org","zone_soa":"users.example.org","target_user_filter":"*@example.org"}`
+	if w := performRequest(router, "POST", "/v1/policies/rules", testSuperAdmin, body); w.Code != 201 {
+		t.Fatalf("failed to create rule: %d %s", w.Code, w.Body.String())
+	}
+	imported := `{"zone_pattern":"%u.staff.example.org","zone_soa":"staff.example.org","target_user_filter":"*@example.org"}`
+	if w := performRequest(router, "POST", "/v1/policies/import", testSuperAdmin, "["+imported+"]"); w.Code != 200 {
+		t.Fatalf("failed to import rule: %d %s", w.Code, w.Body.String())
+	}
+
+	// The request bodies are stored with the audit log entries, newest first
+	response := decodeResponse[AuditLogResponse](t, performRequest(router, "GET", "/v1/policies/audit", testSuperAdmin, ""), 200)
+	if len(response.Entries) != 2 {
+		t.Fatalf("expected 2 audit log entries, got %+v", response.Entries)
+	}
+	for i, want := range []string{imported, body} {
+		entry := response.Entries[i]
+		if entry.RequestBody == nil || *entry.RequestBody != want || entry.RequestBodyTruncated || entry.ActorEmail != testSuperAdmin {
+			t.Errorf("expected entry %d with request body %s, got %+v", i, want, entry)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package routes
 
 import (
+	"encoding/json"
 	"io/fs"
 	"net/http"
+	"strings"
 
 	"github.com/farberg/cloud-self-service-api/internal/config"
 	"github.com/farberg/cloud-self-service-api/internal/generated_docs"
@@ -19,13 +21,58 @@ func CreateStaticFiles(group *gin.RouterGroup, app *config.AppData) *gin.RouterG
 	})
 
 	// Swagger JSON endpoint
+	swaggerJSON := swaggerJSONWithBasePath(generated_docs.SwaggerJSON, app.Config.WebServer.BasePath)
 	group.GET("/swagger.json", func(c *gin.Context) {
 		c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
-		c.String(http.StatusOK, generated_docs.SwaggerJSON)
+		c.String(http.StatusOK, swaggerJSON)
 	})
 
+	// Version endpoint (details are available to super admins at /v1/diagnostics/versions)
+	group.GET("/version", getVersion)
+
 	// Serve JS client
 	subFS, _ := fs.Sub(generated_docs.ClientDist, "client-dist")
 	group.StaticFS("/client", http.FS(subFS))
 	return group
 }
+
+// swaggerJSONWithBasePath sets the basePath of the generated specification so the documented routes
+// resolve when the API is served under a base path. The specification is returned unchanged otherwise.
+func swaggerJSONWithBasePath(swaggerJSON string, basePath string) string {
+	if basePath == "" {
+		return swaggerJSON
+	}
+
+	var spec map[string]any
+	if err := json.Unmarshal([]byte(swaggerJSON), &spec); err != nil {
+		return swaggerJSON
+	}
+	spec["basePath"] = basePath
+
+	patched, err := json.Marshal(spec)
+	if err != nil {
+		return swaggerJSON
+	}
+	return string(patched)
+}
+
+// VersionResponse contains the version of the application.
+type VersionResponse struct {
+	Version string `json:"version"`
+}
+
+// getVersion returns the version of the application.
+// @Summary Get the application version
+// @Description Returns the version of the application. Unauthenticated.
+// @Tags diagnostics
+// @Produce json
+// @Success 200 {object} VersionResponse "The application version"
+// @Router /version [get]
+func getVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, VersionResponse{Version: appVersion()})
+}
+
+// appVersion returns the version of the application as embedded at build time.
+func appVersion() string {
+	return strings.TrimSpace(generated_docs.Version)
+}
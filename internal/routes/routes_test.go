@@ -0,0 +1,137 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/farberg/cloud-self-service-api/internal/auth"
+	"github.com/farberg/cloud-self-service-api/internal/config"
+	"github.com/farberg/cloud-self-service-api/internal/metrics"
+	"github.com/farberg/cloud-self-service-api/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// The webhook API key of the test application
+const testWebhookApiKey = "test-webhook-api-key"
+
+// The super admin of the test application
+const testSuperAdmin = "admin@example.org"
+
+// The header carrying the email of the authenticated user of a test request
+const testUserHeader = "X-Test-User"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestApp creates an application with the default configuration and an in-memory SQLite database
+// that is private to the test. For readable zone names, %u is replaced with the local part of the email.
+func newTestApp(t testing.TB) *config.AppData {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	s, err := storage.NewStorage("sqlite", "file:"+name+"?mode=memory&cache=shared", storage.Options{})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	app := &config.AppData{
+		Config:  config.DefaultAppConfig(),
+		Storage: s,
+		Logger:  zap.NewNop(),
+		Log:     zap.NewNop().Sugar(),
+	}
+	app.Config.DnsPolicyConfig.WebhookApiKey = testWebhookApiKey
+	app.Config.DnsPolicyConfig.SuperAdminEmails = config.StringSet{testSuperAdmin: {}}
+	app.Config.DnsPolicyConfig.UserLabelSource = "local_part"
+	return app
+}
+
+// newTestRouter mounts the webhook and the policy API of the application. Instead of a token, the
+// email of the user of a policy API request is taken from the X-Test-User header.
+func newTestRouter(app *config.AppData) *gin.Engine {
+	router := gin.New()
+	CreateWebhookApiGroup(router.Group("/v1/webhook"), app)
+
+	policyGroup := router.Group("/v1/policies")
+	policyGroup.Use(func(c *gin.Context) {
+		c.Set(auth.UserDataKey, &auth.UserClaims{Email: c.GetHeader(testUserHeader)})
+		c.Next()
+	})
+	CreatePolicyApiGroup(policyGroup, app)
+	return router
+}
+
+// createTestRule stores a rule, failing the test on error.
+func createTestRule(t testing.TB, app *config.AppData, rule storage.PolicyRule) *storage.PolicyRule {
+	t.Helper()
+	created, err := app.Storage.PolicyCreate(&rule)
+	if err != nil {
+		t.Fatalf("failed to create rule '%s': %v", rule.ZonePattern, err)
+	}
+	return created
+}
+
+// performRequest sends a request with a JSON body to the router. The request is sent on behalf of user
+// (if not empty) and authenticated with the webhook API key.
+func performRequest(router *gin.Engine, method string, path string, user string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testWebhookApiKey)
+	if user != "" {
+		req.Header.Set(testUserHeader, user)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// decodeResponse decodes the JSON body of a response, failing the test if the status is not the expected one.
+func decodeResponse[T any](t testing.TB, w *httptest.ResponseRecorder, status int) T {
+	t.Helper()
+	var value T
+	if w.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+		t.Fatalf("failed to decode response %s: %v", w.Body.String(), err)
+	}
+	return value
+}
+
+// callWebhook evaluates the policy for the user via the webhook and returns the zones.
+func callWebhook(t testing.TB, router *gin.Engine, query string, user auth.UserClaims) []ZoneResponse {
+	t.Helper()
+	body, err := json.Marshal(user)
+	if err != nil {
+		t.Fatalf("failed to encode user claims: %v", err)
+	}
+	return decodeResponse[[]ZoneResponse](t, performRequest(router, "POST", "/v1/webhook/dns-policy"+query, "", string(body)), 200)
+}
+
+// zoneNames returns the names of the zones.
+func zoneNames(zones []ZoneResponse) []string {
+	names := make([]string, len(zones))
+	for i, zone := range zones {
+		names[i] = zone.Zone
+	}
+	return names
+}
+
+// scrapeMetrics returns the metrics of the application in the Prometheus text format.
+func scrapeMetrics(t testing.TB, m *metrics.Metrics) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+// newTestMetrics creates metrics with an empty connection pool.
+func newTestMetrics() *metrics.Metrics {
+	return metrics.New(func() (sql.DBStats, error) { return sql.DBStats{}, nil })
+}
@@ -1,23 +1,50 @@
 package routes
 
 import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/farberg/cloud-self-service-api/internal/auth"
 	"github.com/farberg/cloud-self-service-api/internal/config"
 	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"github.com/farberg/cloud-self-service-api/internal/storage"
+	"github.com/farberg/cloud-self-service-api/internal/tracing"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func CreateWebhookApiGroup(group *gin.RouterGroup, app *config.AppData) *gin.RouterGroup {
 	group.POST("/dns-policy", webhookFunc(app))
+	group.POST("/dns-policy/batch", webhookBatchFunc(app))
 
 	return group
 }
 
+// errWebhookApiKeyNotConfigured is returned if neither a webhook API key nor an HMAC secret is configured.
+// The configuration validation already refuses this at startup; requests are rejected regardless, so an
+// empty bearer token can never match an empty key.
+var errWebhookApiKeyNotConfigured = errors.New("the webhook API key is not configured")
+
+// webhookSignatureHeader carries the HMAC-SHA256 signature of the request body ("sha256=<hex>", as sent by GitHub).
+const webhookSignatureHeader = "X-Signature"
+
 func verifyApiKey(c *gin.Context, apiKey string) error {
+	if apiKey == "" {
+		return errWebhookApiKeyNotConfigured
+	}
+
 	// Get the Authorization header
 	authHeader := c.GetHeader("Authorization")
 
@@ -28,57 +55,958 @@ func verifyApiKey(c *gin.Context, apiKey string) error {
 		return errors.New("missing or invalid Authorization Bearer header")
 	}
 
-	// Check if token is an API key (starts with your prefix)
-	if tokenString != apiKey {
+	// Compare in constant time so the key cannot be guessed from response times. ConstantTimeCompare
+	// returns early on a length mismatch, which only leaks the length of the key.
+	if len(tokenString) != len(apiKey) || subtle.ConstantTimeCompare([]byte(tokenString), []byte(apiKey)) != 1 {
 		return errors.New("invalid API key provided in Authorization header")
 	}
 
 	return nil
 }
 
+// verifySignature checks the HMAC-SHA256 signature of the body sent in the X-Signature header.
+// The "sha256=" prefix is optional. The comparison runs in constant time.
+func verifySignature(c *gin.Context, secret string, body []byte) error {
+	signatureHex := strings.TrimPrefix(c.GetHeader(webhookSignatureHeader), "sha256=")
+	if signatureHex == "" {
+		return errors.New("missing " + webhookSignatureHeader + " header")
+	}
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return errors.New("malformed " + webhookSignatureHeader + " header")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return errors.New("invalid signature provided in " + webhookSignatureHeader + " header")
+	}
+
+	return nil
+}
+
+// authenticateWebhook verifies a webhook request and returns its raw body, which is read only once so it
+// can be used for both the signature check and binding. If an HMAC secret is configured, a valid signature
+// is required (and the API key is checked as well if one is configured); otherwise only the API key is checked.
+// On failure, it responds with 401 (or 503 if neither is configured) and returns false.
+func authenticateWebhook(c *gin.Context, app *config.AppData) ([]byte, bool) {
+	log := helper.RequestLogger(c, app.Log)
+	apiKey := app.Config.DnsPolicyConfig.WebhookApiKey
+	hmacSecret := app.Config.DnsPolicyConfig.WebhookHmacSecret
+
+	var err error
+	if hmacSecret == "" || apiKey != "" {
+		err = verifyApiKey(c, apiKey)
+	}
+	if errors.Is(err, errWebhookApiKeyNotConfigured) {
+		log.Error("Rejecting webhook request: neither DNS_POLICY_WEBHOOK_API_KEY nor DNS_POLICY_WEBHOOK_HMAC_SECRET is set")
+		helper.RespondError(c, http.StatusServiceUnavailable, helper.ErrorCodeNotConfigured, "The webhook is not configured")
+		return nil, false
+	}
+
+	var body []byte
+	code := helper.ErrorCodeInvalidApiKey
+	if err == nil {
+		body, err = c.GetRawData()
+		if err != nil {
+			log.Warnf("Failed to read webhook request body: %v", err)
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "invalid request body")
+			return nil, false
+		}
+		if hmacSecret != "" {
+			err = verifySignature(c, hmacSecret, body)
+			code = helper.ErrorCodeInvalidSignature
+		}
+	}
+
+	if err != nil {
+		log.Warnf("Webhook authentication failed: %v", err)
+		helper.AuthFailureDelay(c.Request.Context(), app.Config.WebServer.AuthFailureDelay())
+		helper.RespondError(c, http.StatusUnauthorized, code, err.Error())
+		return nil, false
+	}
+
+	if apiKey != "" {
+		app.AccessLogger.Log(c, auth.SecretPrincipal("api-key", apiKey))
+	} else {
+		app.AccessLogger.Log(c, auth.SecretPrincipal("hmac", hmacSecret))
+	}
+	return body, true
+}
+
+// WebhookDebugResponse is the response of the webhook in debug mode.
+type WebhookDebugResponse struct {
+	// The zones as returned without debug mode (a list, or a map from SOA to zones if grouped by SOA)
+	Zones any `json:"zones"`
+	// How each rule was evaluated for the user, in the default list order of the rules
+	Rules []RuleEvaluation `json:"rules"`
+}
+
+// webhookFunc computes the zones of a user.
+// @Summary Evaluate the DNS policy for a user
+// @Description Computes the zones the user is entitled to. With debug=true (only if DNS_POLICY_WEBHOOK_DEBUG_ENABLED is set), the zones are returned
+// @Description together with how each rule was evaluated for the user (WebhookDebugResponse).
+// @Tags webhook
+// @Accept json
+// @Produce json
+// @Param user body auth.UserClaims true "The user to evaluate"
+// @Param trailing_dot query bool false "Return zones as fully-qualified names with a trailing dot (default: DNS_POLICY_WEBHOOK_TRAILING_DOT_ZONES)"
+// @Param include_description query bool false "Include the description of the generating rule in each zone (default: DNS_POLICY_WEBHOOK_INCLUDE_DESCRIPTION)"
+// @Param level query string false "Only return zones with this access level (manage or view); default: all zones"
+// @Param group_by_soa query bool false "Return the zones as a map from SOA to zones (default: DNS_POLICY_WEBHOOK_GROUP_BY_SOA)"
+// @Param debug query bool false "Additionally return how each rule was evaluated (requires DNS_POLICY_WEBHOOK_DEBUG_ENABLED)"
+// @Param X-Signature header string false "HMAC-SHA256 signature of the body as sha256=<hex> (required if DNS_POLICY_WEBHOOK_HMAC_SECRET is set)"
+// @Success 200 {array} ZoneResponse "The zones of the user"
+// @Failure 400 {object} helper.APIError "Invalid request body or query parameters"
+// @Failure 401 {object} helper.APIError "Invalid API key or signature"
+// @Failure 403 {object} helper.APIError "Debug mode requested but not enabled"
+// @Failure 422 {object} helper.APIError "No DNS label can be derived for the user"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Failure 503 {object} helper.APIError "The webhook API key is not configured or evaluation is paused"
+// @Security ApiKeyAuth
+// @Router /v1/webhook/dns-policy [post]
 func webhookFunc(app *config.AppData) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		app.Log.Debug("Received webhook DNS policy request")
+		log := helper.RequestLogger(c, app.Log)
+		log.Debug("Received webhook DNS policy request")
 
-		// Get the Authorization header
-		err := verifyApiKey(c, app.Config.DnsPolicyConfig.WebhookApiKey)
+		body, ok := authenticateWebhook(c, app)
+		if !ok {
+			return
+		}
+
+		options, err := parseWebhookResponseOptions(c, app)
+		if err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+		groupBySoa, err := webhookBoolOption(c, "group_by_soa", app.Config.DnsPolicyConfig.WebhookGroupBySoa)
+		if err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+		debug, err := webhookBoolOption(c, "debug", false)
 		if err != nil {
-			app.Log.Warnf("Webhook API key verification failed: %v", err)
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+		if debug && !app.Config.DnsPolicyConfig.WebhookDebugEnabled {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "The debug mode of the webhook is not enabled")
+			return
+		}
+
+		if app.WebhookPaused.Load() {
+			if respondWebhookPaused(c, app) {
+				return
+			}
+			if groupBySoa {
+				c.JSON(http.StatusOK, map[string][]ZoneResponse{})
+			} else {
+				c.JSON(http.StatusOK, []ZoneResponse{})
+			}
 			return
 		}
 
 		// Extract JSON body and bind to UserClaimsRequest struct
 		var userClaimsReq auth.UserClaims
-		if err := c.ShouldBindJSON(&userClaimsReq); err != nil {
-			app.Log.Warnf("Failed to bind JSON body: %v", err)
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		if err := binding.JSON.BindBody(body, &userClaimsReq); err != nil {
+			log.Warnf("Failed to bind JSON body: %v", err)
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "invalid request body")
 			return
 		}
-		app.Log.Debugf("Received user claims: %+v", userClaimsReq)
+		log.Debugf("Received user claims: %+v", userClaimsReq)
 
-		// Get user rules
-		rules, err := listUserRules(app, &userClaimsReq, false /* is_super_admin */)
+		// Evaluate the user's rules
+		matches, evaluations, err := resolveUserZones(c.Request.Context(), app, &userClaimsReq, time.Time{})
 		if err != nil {
 			// Return error response
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rules"})
+			respondEvaluationError(c, app, err)
+			return
+		}
+
+		// Return the zones as JSON response (as a map from SOA to zones if requested)
+		zones := webhookZoneResponses(c.Request.Context(), app, matches, options)
+		tracing.Annotate(c.Request.Context(),
+			attribute.String("user.email_domain", emailDomain(userClaimsReq.Email)),
+			attribute.Int("webhook.zones", len(zones)),
+		)
+		var response any = zones
+		if groupBySoa {
+			response = groupZonesBySoa(zones)
+		}
+		if debug {
+			// Only rule data is returned, never the credentials of the request or the configuration
+			log.Infof("Returning the evaluation of %d rules (webhook debug mode)", len(evaluations))
+			response = WebhookDebugResponse{Zones: response, Rules: evaluations}
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// The key of the setting storing whether the webhook is paused
+const webhookPausedSettingKey = "webhook_paused"
+
+// WebhookPausedRequest pauses or resumes webhook evaluation.
+type WebhookPausedRequest struct {
+	Paused *bool `json:"paused" binding:"required"`
+}
+
+// WebhookPausedResponse reports whether webhook evaluation is paused.
+type WebhookPausedResponse struct {
+	Paused bool `json:"paused"`
+	// True if the state survives a restart
+	Persisted bool `json:"persisted"`
+}
+
+// LoadWebhookPaused reads the persisted paused state of the webhook (false if never stored).
+func LoadWebhookPaused(s *storage.Storage) (bool, error) {
+	value, ok, err := s.SettingGet(webhookPausedSettingKey)
+	if err != nil || !ok {
+		return false, err
+	}
+	return strconv.ParseBool(value)
+}
+
+// respondWebhookPaused responds to a webhook request while evaluation is paused. It returns false
+// if the configured response is an empty result, which the caller has to send itself.
+func respondWebhookPaused(c *gin.Context, app *config.AppData) bool {
+	helper.RequestLogger(c, app.Log).Debug("Webhook evaluation is paused; not returning any zones")
+	if app.Config.DnsPolicyConfig.WebhookPausedResponse == "empty" {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(app.Config.DnsPolicyConfig.WebhookPausedRetryAfterSeconds))
+	helper.RespondError(c, http.StatusServiceUnavailable, helper.ErrorCodeWebhookPaused, "Webhook evaluation is paused")
+	return true
+}
+
+// getWebhookPaused returns whether webhook evaluation is paused (super-admin only).
+// @Summary Get the paused state of the webhook
+// @Description Reports whether webhook evaluation is paused. Only SuperAdmins are authorized.
+// @Tags webhook
+// @Produce json
+// @Success 200 {object} WebhookPausedResponse "The paused state"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Security ApiKeyAuth
+// @Router /v1/policies/webhook/paused [get]
+func getWebhookPaused(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can access the webhook state")
+			return
+		}
+
+		c.JSON(http.StatusOK, WebhookPausedResponse{
+			Paused:    app.WebhookPaused.Load(),
+			Persisted: app.Config.DnsPolicyConfig.WebhookPausedPersist,
+		})
+	}
+}
+
+// setWebhookPaused pauses or resumes webhook evaluation (super-admin only).
+// @Summary Pause or resume the webhook
+// @Description Pauses or resumes webhook evaluation. While paused, the webhook returns 503 with Retry-After or an empty result (DNS_POLICY_WEBHOOK_PAUSED_RESPONSE); the policy API is unaffected. Only SuperAdmins are authorized.
+// @Tags webhook
+// @Accept json
+// @Produce json
+// @Param state body WebhookPausedRequest true "The new paused state"
+// @Success 200 {object} WebhookPausedResponse "The new paused state"
+// @Failure 400 {object} helper.APIError "Invalid request payload"
+// @Failure 403 {object} helper.APIError "Forbidden: Not a SuperAdmin"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Security ApiKeyAuth
+// @Router /v1/policies/webhook/paused [put]
+func setWebhookPaused(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := helper.RequestLogger(c, app.Log)
+		user := c.MustGet(auth.UserDataKey).(*auth.UserClaims)
+		if !config.IsSuperAdmin(user, app.Config.DnsPolicyConfig) {
+			helper.RespondError(c, http.StatusForbidden, helper.ErrorCodeForbidden, "Only super admins can pause the webhook")
+			return
+		}
+
+		var req WebhookPausedRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindingError(c, err)
+			return
+		}
+
+		persist := app.Config.DnsPolicyConfig.WebhookPausedPersist
+		if persist {
+			if err := app.Storage.SettingSetCtx(c.Request.Context(), webhookPausedSettingKey, strconv.FormatBool(*req.Paused)); err != nil {
+				log.Errorf("Failed to persist the paused state of the webhook: %v", err)
+				helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to store the paused state")
+				return
+			}
+		}
+
+		app.WebhookPaused.Store(*req.Paused)
+		if *req.Paused {
+			log.Warnf("User '%s' paused webhook evaluation", user.Email)
+		} else {
+			log.Infof("User '%s' resumed webhook evaluation", user.Email)
+		}
+
+		c.JSON(http.StatusOK, WebhookPausedResponse{Paused: *req.Paused, Persisted: persist})
+	}
+}
+
+// WebhookBatchResult holds the zones computed for one user of a batch webhook request.
+type WebhookBatchResult struct {
+	Subject string         `json:"sub"`
+	Email   string         `json:"email,omitempty"`
+	Zones   []ZoneResponse `json:"zones"`
+	// Why no zones could be computed for the user (only set on failure)
+	Error string `json:"error,omitempty"`
+}
+
+// webhookBatchFunc godoc
+// @Summary Evaluate the DNS policy for multiple users
+// @Description Computes the zones for each user in the request. Up to DNS_POLICY_BATCH_CONCURRENCY users are evaluated concurrently; results are returned in request order.
+// @Description The number of users per request is limited by DNS_POLICY_WEBHOOK_MAX_BATCH_SIZE (default 100); larger batches are rejected with 413 and must be split by the caller.
+// @Tags webhook
+// @Accept json
+// @Produce json
+// @Param users body []auth.UserClaims true "The users to evaluate"
+// @Param trailing_dot query bool false "Return zones as fully-qualified names with a trailing dot (default: DNS_POLICY_WEBHOOK_TRAILING_DOT_ZONES)"
+// @Param include_description query bool false "Include the description of the generating rule in each zone (default: DNS_POLICY_WEBHOOK_INCLUDE_DESCRIPTION)"
+// @Param level query string false "Only return zones with this access level (manage or view); default: all zones"
+// @Param X-Signature header string false "HMAC-SHA256 signature of the body as sha256=<hex> (required if DNS_POLICY_WEBHOOK_HMAC_SECRET is set)"
+// @Success 200 {array} WebhookBatchResult "The zones per user"
+// @Failure 400 {object} helper.APIError "Invalid request body"
+// @Failure 401 {object} helper.APIError "Invalid API key or signature"
+// @Failure 413 {object} helper.APIError "Batch exceeds the maximum batch size"
+// @Failure 500 {object} helper.APIError "Internal server error"
+// @Failure 503 {object} helper.APIError "The webhook API key is not configured or evaluation is paused"
+// @Security ApiKeyAuth
+// @Router /v1/webhook/dns-policy/batch [post]
+func webhookBatchFunc(app *config.AppData) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := helper.RequestLogger(c, app.Log)
+		log.Debug("Received batch webhook DNS policy request")
+
+		body, ok := authenticateWebhook(c, app)
+		if !ok {
+			return
+		}
+
+		options, err := parseWebhookResponseOptions(c, app)
+		if err != nil {
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, err.Error())
+			return
+		}
+
+		var users []auth.UserClaims
+		if err := binding.JSON.BindBody(body, &users); err != nil {
+			log.Warnf("Failed to bind JSON body: %v", err)
+			helper.RespondError(c, http.StatusBadRequest, helper.ErrorCodeInvalidRequest, "invalid request body")
+			return
+		}
+
+		// Reject oversized batches instead of building a huge response
+		maxBatchSize := app.Config.DnsPolicyConfig.WebhookMaxBatchSize
+		if len(users) > maxBatchSize {
+			log.Warnf("Rejecting batch webhook request with %d users (maximum is %d)", len(users), maxBatchSize)
+			helper.RespondErrorDetails(c, http.StatusRequestEntityTooLarge, helper.ErrorCodePayloadTooLarge,
+				fmt.Sprintf("batch contains %d users but at most %d are allowed; split the request into smaller batches", len(users), maxBatchSize),
+				gin.H{"max_batch_size": maxBatchSize})
+			return
+		}
+
+		paused := app.WebhookPaused.Load()
+		if paused && respondWebhookPaused(c, app) {
+			return
+		}
+
+		// Evaluate the users concurrently, each worker writing to the position of its user to keep the request order
+		results := make([]WebhookBatchResult, len(users))
+		err = helper.ForEachConcurrently(c.Request.Context(), len(users), app.Config.BatchWorkers(), func(ctx context.Context, i int) error {
+			result := WebhookBatchResult{
+				Subject: users[i].Subject,
+				Email:   users[i].Email,
+				Zones:   []ZoneResponse{},
+			}
+			if !paused {
+				matches, err := evaluateUserZones(ctx, app, &users[i])
+				switch {
+				case errors.Is(err, errMissingUserLabel):
+					// Report users with unusable claims without failing the whole batch
+					result.Error = err.Error()
+				case err != nil:
+					return err
+				default:
+					result.Zones = webhookZoneResponses(ctx, app, matches, options)
+				}
+			}
+			results[i] = result
+			return nil
+		})
+		if err != nil {
+			respondEvaluationError(c, app, err)
 			return
 		}
 
-		// Prepare data for pattern replacement
-		userDnsLabel := helper.DnsMakeCompliant(userClaimsReq.Email)
+		totalZones := 0
+		for _, result := range results {
+			totalZones += len(result.Zones)
+		}
+		tracing.Annotate(c.Request.Context(),
+			attribute.Int("webhook.users", len(users)),
+			attribute.Int("webhook.zones", totalZones),
+		)
+
+		c.JSON(http.StatusOK, results)
+	}
+}
+
+// parseEvaluationTime parses the optional "at" query parameter (RFC 3339), the point in time to evaluate the
+// rules at. It returns the zero time if the parameter is not given.
+func parseEvaluationTime(c *gin.Context) (time.Time, error) {
+	value, ok := c.GetQuery("at")
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid at value '%s' (expected an RFC 3339 timestamp)", value)
+	}
+	if at.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("at value '%s' is in the future", value)
+	}
+	return at, nil
+}
+
+// webhookBoolOption returns the value of a boolean query parameter of the webhook. If the
+// parameter is not given, the configured default is returned.
+func webhookBoolOption(c *gin.Context, name string, defaultValue bool) (bool, error) {
+	value, ok := c.GetQuery(name)
+	if !ok {
+		return defaultValue, nil
+	}
+
+	option, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value '%s' (expected true or false)", name, value)
+	}
+	return option, nil
+}
+
+// webhookLevelOption returns the access level the zones of the webhook are filtered by
+// ("manage" or "view"), or "" if all zones are requested.
+func webhookLevelOption(c *gin.Context) (string, error) {
+	level := c.Query("level")
+	switch level {
+	case "", storage.AccessLevelManage, storage.AccessLevelView:
+		return level, nil
+	default:
+		return "", fmt.Errorf("invalid level value '%s' (expected manage or view)", level)
+	}
+}
 
-		// Iterate over the rules create responses
-		zones := make([]ZoneResponse, 0)
-		for _, rule := range rules {
-			zone := strings.ReplaceAll(rule.ZonePattern, "%u", userDnsLabel)
-			zones = append(zones, ZoneResponse{
-				Zone:    zone,
-				ZoneSOA: rule.ZoneSoa,
+// emailDomain returns the lowercase domain of an email address, or "" if it has none. It identifies the
+// organization of a user in traces without recording the address itself.
+func emailDomain(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
+
+// groupZonesBySoa groups zones by their SOA.
+func groupZonesBySoa(zones []ZoneResponse) map[string][]ZoneResponse {
+	grouped := make(map[string][]ZoneResponse)
+	for _, zone := range zones {
+		grouped[zone.ZoneSOA] = append(grouped[zone.ZoneSOA], zone)
+	}
+	return grouped
+}
+
+// webhookResponseOptions controls which zones the webhook returns and how.
+type webhookResponseOptions struct {
+	// Return the zone and the SOA as fully-qualified names with a trailing dot
+	TrailingDot bool
+	// Only return zones with this access level ("" = all zones)
+	Level string
+	// Include the description of the rule that generated each zone
+	IncludeDescription bool
+}
+
+// parseWebhookResponseOptions reads the response options from the query parameters of a webhook request,
+// falling back to the configured defaults.
+func parseWebhookResponseOptions(c *gin.Context, app *config.AppData) (webhookResponseOptions, error) {
+	var options webhookResponseOptions
+	var err error
+
+	if options.TrailingDot, err = webhookBoolOption(c, "trailing_dot", app.Config.DnsPolicyConfig.WebhookTrailingDotZones); err != nil {
+		return options, err
+	}
+	if options.Level, err = webhookLevelOption(c); err != nil {
+		return options, err
+	}
+	if options.IncludeDescription, err = webhookBoolOption(c, "include_description", app.Config.DnsPolicyConfig.WebhookIncludeDescription); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+// webhookZoneResponses converts the zone matches of resolveUserZones (one per zone, sorted by name) into the
+// zones returned by the webhook according to the options.
+func webhookZoneResponses(ctx context.Context, app *config.AppData, matches []zoneMatch, options webhookResponseOptions) []ZoneResponse {
+	zones := make([]ZoneResponse, 0, len(matches))
+	for _, match := range matches {
+		zone := match.Zone
+		if options.Level != "" && zone.AccessLevel != options.Level {
+			continue
+		}
+		if options.IncludeDescription {
+			zone.Description = match.Description
+		}
+		if options.TrailingDot {
+			zone.Zone = helper.DnsToFqdn(zone.Zone)
+			zone.ZoneSOA = helper.DnsToFqdn(zone.ZoneSOA)
+		}
+		if app.Config.DnsPolicyConfig.WebhookIncludeRuleID {
+			key := match.RuleKey
+			zone.RuleID = &key
+		}
+		zones = append(zones, zone)
+	}
+	app.Metrics.WebhookZonesExpanded(len(zones))
+	return zones
+}
+
+// dedupeZoneMatches keeps one match per zone name (compared case-insensitively) and sorts the matches by
+// zone name. If several rules produce the same zone, the match of the rule with the lowest ID is kept,
+// and a warning is logged if the rules disagree on the SOA.
+func dedupeZoneMatches(ctx context.Context, app *config.AppData, matches []zoneMatch) []zoneMatch {
+	sorted := slices.Clone(matches)
+	slices.SortStableFunc(sorted, func(a, b zoneMatch) int {
+		return cmp.Or(
+			cmp.Compare(strings.ToLower(a.Zone.Zone), strings.ToLower(b.Zone.Zone)),
+			cmp.Compare(a.RuleID, b.RuleID),
+		)
+	})
+
+	deduped := make([]zoneMatch, 0, len(sorted))
+	for _, match := range sorted {
+		if len(deduped) > 0 {
+			kept := deduped[len(deduped)-1]
+			if strings.EqualFold(kept.Zone.Zone, match.Zone.Zone) {
+				if !strings.EqualFold(kept.Zone.ZoneSOA, match.Zone.ZoneSOA) {
+					helper.RequestLogger(ctx, app.Log).Warnf("Rules %d and %d both produce zone '%s' with different SOAs ('%s' and '%s'); using the SOA of rule %d",
+						kept.RuleID, match.RuleID, match.Zone.Zone, kept.Zone.ZoneSOA, match.Zone.ZoneSOA, kept.RuleID)
+				}
+				continue
+			}
+		}
+		deduped = append(deduped, match)
+	}
+	return deduped
+}
+
+// zoneMatch is a zone computed for a user together with the rule that produced it.
+type zoneMatch struct {
+	Zone        ZoneResponse
+	RuleID      int64
+	RuleKey     RuleKey
+	Description string
+}
+
+// RuleEvaluation describes how a rule was evaluated for a user (returned by the webhook in debug mode).
+type RuleEvaluation struct {
+	RuleID      RuleKey `json:"rule_id" swaggertype:"string"`
+	ZonePattern string  `json:"zone_pattern"`
+	// Whether the target user filter of the rule matches the user
+	FilterMatched bool `json:"filter_matched"`
+	// The zones the pattern expanded to for the user (omitted if the rule was rejected before the expansion)
+	ExpandedZones []string `json:"expanded_zones,omitempty"`
+	// Whether the rule produced zones for the user
+	Applied bool `json:"applied"`
+	// Why the rule did not apply (only set if not applied)
+	Reason string `json:"reason,omitempty"`
+}
+
+// RejectedRule is a rule that was considered for a user but did not produce any zones.
+type RejectedRule struct {
+	RuleID      RuleKey `json:"rule_id" swaggertype:"string"`
+	ZonePattern string  `json:"zone_pattern"`
+	// Why the rule did not apply (e.g. "user filter does not match", "rule is disabled")
+	Reason string `json:"reason"`
+}
+
+// evaluateUserZones computes the zones a user is entitled to by matching the user
+// against all rules and expanding the zone patterns of the matching ones. If an
+// authorization hook is configured, it may veto each matching rule. See resolveUserZones.
+func evaluateUserZones(ctx context.Context, app *config.AppData, user *auth.UserClaims) ([]zoneMatch, error) {
+	return evaluateUserZonesAt(ctx, app, user, time.Time{})
+}
+
+// evaluateUserZonesAt works like evaluateUserZones but evaluates the rules as they were at the given time
+// (the current rules if the time is zero). See evaluateRules.
+func evaluateUserZonesAt(ctx context.Context, app *config.AppData, user *auth.UserClaims, at time.Time) ([]zoneMatch, error) {
+	matches, _, err := resolveUserZones(ctx, app, user, at)
+	return matches, err
+}
+
+// resolveUserZones is the zone evaluation of the webhook: it evaluates the rules for the user, adds the
+// fallback zone if no rule applied, and keeps one match per zone (see dedupeZoneMatches). It also returns
+// how each rule was evaluated. The preview and the token evaluation use it as well, so they show exactly
+// the zones the webhook returns.
+func resolveUserZones(ctx context.Context, app *config.AppData, user *auth.UserClaims, at time.Time) ([]zoneMatch, []RuleEvaluation, error) {
+	matches, evaluations, err := evaluateUserZonesWithEvaluations(ctx, app, user, at)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dedupeZoneMatches(ctx, app, matches), evaluations, nil
+}
+
+// evaluateUserZonesWithEvaluations works like resolveUserZones but keeps all matches, also several
+// of the same zone, in rule order.
+func evaluateUserZonesWithEvaluations(ctx context.Context, app *config.AppData, user *auth.UserClaims, at time.Time) ([]zoneMatch, []RuleEvaluation, error) {
+	matches, evaluations, err := evaluateRules(ctx, app, user, at)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Users without any zone get the fallback zone (if configured)
+	if len(matches) == 0 {
+		if fallback, ok := fallbackZone(ctx, app, user); ok {
+			matches = append(matches, fallback)
+		}
+	}
+	return matches, evaluations, nil
+}
+
+// fallbackZone expands the configured fallback zone pattern for a user that matched no rules.
+// The zone is not attributed to any rule (rule ID 0) and can be managed by the user.
+// It returns false if no fallback is configured or the pattern cannot be expanded
+// to a valid zone for the user.
+func fallbackZone(ctx context.Context, app *config.AppData, user *auth.UserClaims) (zoneMatch, bool) {
+	pattern := app.Config.DnsPolicyConfig.FallbackZonePattern
+	if pattern == "" {
+		return zoneMatch{}, false
+	}
+
+	zone, err := ExpandZonePattern(pattern, user, app.Config.DnsPolicyConfig.UserLabelSource)
+	if err != nil {
+		helper.RequestLogger(ctx, app.Log).Debugf("Not applying the fallback zone pattern '%s': %v", pattern, err)
+		return zoneMatch{}, false
+	}
+	if invalidZone, ok := firstInvalidZone([]string{zone}); ok {
+		helper.RequestLogger(ctx, app.Log).Warnf("Not applying the fallback zone pattern '%s' because it expands to the invalid zone '%s'", pattern, invalidZone)
+		return zoneMatch{}, false
+	}
+
+	return zoneMatch{Zone: ZoneResponse{Zone: zone, ZoneSOA: app.Config.DnsPolicyConfig.FallbackZoneSOA, AccessLevel: storage.AccessLevelManage}}, true
+}
+
+// rejectedRules returns the rules that did not apply to the user, each with the reason.
+func rejectedRules(evaluations []RuleEvaluation) []RejectedRule {
+	rejected := make([]RejectedRule, 0)
+	for _, evaluation := range evaluations {
+		if !evaluation.Applied {
+			rejected = append(rejected, RejectedRule{RuleID: evaluation.RuleID, ZonePattern: evaluation.ZonePattern, Reason: evaluation.Reason})
+		}
+	}
+	return rejected
+}
+
+// evaluateRules matches the user against all rules and expands the zone patterns of the matching ones.
+// It returns the zones and how each rule was evaluated, in rule order. If a time is given, the rules are
+// reconstructed as they were at that time from the audit log (in ID order); the configuration is the current one.
+func evaluateRules(ctx context.Context, app *config.AppData, user *auth.UserClaims, at time.Time) ([]zoneMatch, []RuleEvaluation, error) {
+	// Get all rules (as of the given time)
+	var rules []storage.PolicyRule
+	var err error
+	if at.IsZero() {
+		rules, err = app.Storage.PolicyGetAllCtx(ctx)
+	} else {
+		rules, err = app.Storage.PolicyGetAllAtCtx(ctx, at)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Rules expire relative to the evaluated time
+	now := at
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Iterate over the rules create responses
+	matches := make([]zoneMatch, 0, len(rules))
+	evaluations := make([]RuleEvaluation, 0, len(rules))
+	reject := func(evaluation *RuleEvaluation, reason string) {
+		evaluation.Reason = reason
+		evaluations = append(evaluations, *evaluation)
+	}
+
+	skipped := 0
+	for _, rule := range rules {
+		evaluation := RuleEvaluation{RuleID: newRuleKey(app.Config.DnsPolicyConfig, rule), ZonePattern: rule.ZonePattern}
+
+		// Only rules whose user filter matches the user apply
+		if !MatchesUserFilter(rule.TargetUserFilter, user) {
+			reject(&evaluation, "user filter does not match")
+			continue
+		}
+		evaluation.FilterMatched = true
+
+		// Disabled and not (yet) approved rules do not take effect
+		if !rule.Enabled {
+			reject(&evaluation, "rule is disabled")
+			continue
+		}
+		if rule.Status != storage.RuleStatusApproved {
+			reject(&evaluation, "rule is "+rule.Status)
+			continue
+		}
+		if rule.ExpiresAt != nil && !rule.ExpiresAt.After(now) {
+			reject(&evaluation, "rule is expired")
+			continue
+		}
+
+		// Skip rules stored before validation was tightened instead of emitting invalid zones
+		if fieldError := validateZonePatternField(rule.ZonePattern); fieldError != nil {
+			helper.RequestLogger(ctx, app.Log).Warnf("Skipping rule %d with invalid zone pattern '%s': %s", rule.ID, rule.ZonePattern, fieldError.Message)
+			reject(&evaluation, "invalid zone pattern: "+fieldError.Message)
+			skipped++
+			app.Metrics.WebhookRuleSkipped()
+			continue
+		}
+
+		zones, err := expandRuleZones(ctx, app, &rule, user)
+		if errors.Is(err, errMissingUserLabel) {
+			return nil, nil, err
+		}
+		if err != nil {
+			reject(&evaluation, err.Error())
+			continue
+		}
+		zoneNames := make([]string, 0, 2*len(zones))
+		for _, zone := range zones {
+			zoneNames = append(zoneNames, zone)
+			if rule.IncludeWww {
+				zoneNames = append(zoneNames, "www."+zone)
+			}
+		}
+		evaluation.ExpandedZones = zoneNames
+
+		if invalidZone, ok := firstInvalidZone(zoneNames); ok {
+			helper.RequestLogger(ctx, app.Log).Warnf("Skipping rule %d because zone pattern '%s' expands to the invalid zone '%s'", rule.ID, rule.ZonePattern, invalidZone)
+			reject(&evaluation, fmt.Sprintf("zone pattern expands to the invalid zone '%s'", invalidZone))
+			skipped++
+			app.Metrics.WebhookRuleSkipped()
+			continue
+		}
+
+		if !authorizeRule(ctx, app, user, &rule) {
+			reject(&evaluation, "denied by the authorization service")
+			continue
+		}
+
+		evaluation.Applied = true
+		evaluations = append(evaluations, evaluation)
+		for _, zoneName := range zoneNames {
+			matches = append(matches, zoneMatch{
+				Zone: ZoneResponse{
+					Zone:        zoneName,
+					ZoneSOA:     rule.ZoneSoa,
+					AccessLevel: rule.AccessLevel,
+				},
+				RuleID:      rule.ID,
+				RuleKey:     newRuleKey(app.Config.DnsPolicyConfig, rule),
+				Description: rule.Description,
 			})
 		}
+	}
+
+	if skipped > 0 {
+		helper.RequestLogger(ctx, app.Log).Warnf("Skipped %d invalid rule(s) during zone evaluation", skipped)
+	}
+
+	return matches, evaluations, nil
+}
+
+// expandRuleZones expands the zone pattern of a rule for a user. A pattern with the %g placeholder is
+// expanded once per group of the user, skipping groups without a usable label and groups yielding an
+// already expanded zone. At most MaxGroupExpansionsPerRule zones are returned (0 = unlimited); the remaining
+// groups are ignored with a warning. An error is returned if no group yields a zone.
+func expandRuleZones(ctx context.Context, app *config.AppData, rule *storage.PolicyRule, user *auth.UserClaims) ([]string, error) {
+	labelSource := app.Config.DnsPolicyConfig.UserLabelSource
+	if !strings.Contains(rule.ZonePattern, "%g") || len(user.Groups) <= 1 {
+		zone, err := ExpandZonePattern(rule.ZonePattern, user, labelSource)
+		if err != nil {
+			return nil, err
+		}
+		return []string{zone}, nil
+	}
+
+	limit := app.Config.DnsPolicyConfig.MaxGroupExpansionsPerRule
+	zones := make([]string, 0, len(user.Groups))
+	var expandErr error
+	for i, group := range user.Groups {
+		groupClaims := *user
+		groupClaims.Groups = []string{group}
+		zone, err := ExpandZonePattern(rule.ZonePattern, &groupClaims, labelSource)
+		if errors.Is(err, errMissingUserLabel) {
+			return nil, err
+		}
+		if err != nil {
+			expandErr = err
+			continue
+		}
+		if slices.Contains(zones, zone) {
+			continue
+		}
+		if limit > 0 && len(zones) == limit {
+			helper.RequestLogger(ctx, app.Log).Warnf("Rule %d expands to more than %d zones for the groups of the user; ignoring the remaining %d group(s)",
+				rule.ID, limit, len(user.Groups)-i)
+			break
+		}
+		zones = append(zones, zone)
+	}
+
+	if len(zones) == 0 {
+		return nil, expandErr
+	}
+	return zones, nil
+}
 
-		// Return the zones as JSON response
-		c.JSON(http.StatusOK, zones)
+// errMissingUserLabel is returned if the claim selected for %u yields no DNS label for a user.
+var errMissingUserLabel = errors.New("no DNS label can be derived for the user")
+
+var (
+	// errMissingPlaceholderValue is returned if a user has no value for the %d or %g placeholder.
+	errMissingPlaceholderValue = errors.New("the user has no value for a placeholder")
+	// errUnknownPlaceholder is returned if a zone pattern contains a placeholder other than %u, %d, and %g.
+	errUnknownPlaceholder = errors.New("unknown placeholder in zone pattern")
+	// errExpandedZoneTooLong is returned if an expanded zone exceeds the maximum length of a DNS name.
+	errExpandedZoneTooLong = errors.New("expanded zone exceeds 253 characters")
+)
+
+// maxZoneNameLength is the maximum length of a DNS name (without the trailing dot).
+const maxZoneNameLength = 253
+
+// ExpandZonePattern replaces the placeholders of a zone pattern with DNS labels derived from the user:
+// %u is the user label (from the claim selected by userLabelSource), %d the domain of the email,
+// and %g the primary (first) group. Each value passes through DnsMakeCompliant, so it forms
+// a single label (e.g. the domain "dhbw.de" becomes "dhbw-de"). Unknown placeholders, missing
+// values, and results longer than 253 characters are errors; a missing user label is reported
+// as errMissingUserLabel.
+func ExpandZonePattern(pattern string, claims *auth.UserClaims, userLabelSource string) (string, error) {
+	var zone strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			zone.WriteByte(pattern[i])
+			continue
+		}
+
+		i++
+		if i >= len(pattern) {
+			return "", fmt.Errorf("%w: trailing '%%'", errUnknownPlaceholder)
+		}
+
+		switch pattern[i] {
+		case 'u':
+			label, err := userLabel(userLabelSource, claims)
+			if err != nil {
+				return "", err
+			}
+			zone.WriteString(label)
+		case 'd':
+			_, domain, _ := strings.Cut(claims.Email, "@")
+			label := helper.DnsMakeCompliant(domain)
+			if label == "" {
+				return "", fmt.Errorf("%w: %%d requires an email address with a domain", errMissingPlaceholderValue)
+			}
+			zone.WriteString(label)
+		case 'g':
+			label := ""
+			if len(claims.Groups) > 0 {
+				label = helper.DnsMakeCompliant(claims.Groups[0])
+			}
+			if label == "" {
+				return "", fmt.Errorf("%w: %%g requires a group", errMissingPlaceholderValue)
+			}
+			zone.WriteString(label)
+		default:
+			return "", fmt.Errorf("%w: '%%%c'", errUnknownPlaceholder, pattern[i])
+		}
+	}
+
+	if zone.Len() > maxZoneNameLength {
+		return "", fmt.Errorf("%w: '%s'", errExpandedZoneTooLong, zone.String())
+	}
+	return zone.String(), nil
+}
+
+// userLabel derives the DNS label replacing %u from the configured claim of the user.
+func userLabel(source string, user *auth.UserClaims) (string, error) {
+	var value string
+	switch source {
+	case "local_part":
+		value, _, _ = strings.Cut(user.Email, "@")
+	case "sub":
+		value = user.Subject
+	case "preferred_username":
+		value = user.PreferredUsername
+	default:
+		value = user.Email
+	}
+
+	label := helper.DnsMakeCompliant(value)
+	if label == "" {
+		return "", fmt.Errorf("%w: claim '%s' is empty or contains no usable characters", errMissingUserLabel, source)
+	}
+	return label, nil
+}
+
+// respondEvaluationError responds to a failed zone evaluation. A missing user label is an error of
+// the user's claims and a missing rule history an error of the requested time (422); everything else
+// is an internal error.
+func respondEvaluationError(c *gin.Context, app *config.AppData, err error) {
+	if errors.Is(err, errMissingUserLabel) {
+		helper.RespondError(c, http.StatusUnprocessableEntity, helper.ErrorCodeMissingUserLabel, err.Error())
+		return
+	}
+	if errors.Is(err, storage.ErrHistoryUnavailable) {
+		helper.RespondError(c, http.StatusUnprocessableEntity, helper.ErrorCodeHistoryUnavailable, err.Error())
+		return
+	}
+
+	helper.RequestLogger(c, app.Log).Warnf("Failed to evaluate zones: %v", err)
+	helper.RespondError(c, http.StatusInternalServerError, helper.ErrorCodeInternal, "Failed to retrieve rules")
+}
+
+// authorizeRule asks the authorization hook (if any) whether the rule applies to the user.
+// If the hook fails, the configured fail-open/fail-closed behavior decides.
+func authorizeRule(ctx context.Context, app *config.AppData, user *auth.UserClaims, rule *storage.PolicyRule) bool {
+	if app.AuthorizationHook == nil {
+		return true
+	}
+
+	allowed, err := app.AuthorizationHook.Authorize(ctx, user, rule)
+	if err != nil {
+		failOpen := app.Config.DnsPolicyConfig.AuthorizationHookFailOpen
+		helper.RequestLogger(ctx, app.Log).Warnf("Authorization hook failed for rule %d and user '%s' (fail-open: %v): %v", rule.ID, user.Email, failOpen, err)
+		return failOpen
+	}
+	if !allowed {
+		helper.RequestLogger(ctx, app.Log).Debugf("Authorization hook denied rule %d for user '%s'", rule.ID, user.Email)
+	}
+	return allowed
+}
+
+// firstInvalidZone returns the first zone name that is not a valid DNS name.
+func firstInvalidZone(zoneNames []string) (string, bool) {
+	for _, zoneName := range zoneNames {
+		if !helper.DnsValidateName(zoneName) {
+			return zoneName, true
+		}
 	}
+	return "", false
 }
@@ -0,0 +1,521 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/farberg/cloud-self-service-api/internal/auth"
+	"github.com/farberg/cloud-self-service-api/internal/helper"
+	"github.com/farberg/cloud-self-service-api/internal/metrics"
+	"github.com/farberg/cloud-self-service-api/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWebhookRuleID(t *testing.T) {
+	app := newTestApp(t)
+	rule := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+	user := auth.UserClaims{Email: "jane@example.org"}
+
+	// The default response is lean
+	w := performRequest(router, "POST", "/v1/webhook/dns-policy", "", `{"email":"jane@example.org"}`)
+	if w.Code != 200 || strings.Contains(w.Body.String(), "rule_id") {
+		t.Fatalf("expected a response without rule IDs, got %d: %s", w.Code, w.Body.String())
+	}
+
+	app.Config.DnsPolicyConfig.WebhookIncludeRuleID = true
+	zones := callWebhook(t, router, "", user)
+	if len(zones) != 1 || zones[0].Zone != "jane.users.example.org" || zones[0].RuleID == nil || zones[0].RuleID.ID != rule.ID {
+		t.Fatalf("expected zone 'jane.users.example.org' of rule %d, got %+v", rule.ID, zones)
+	}
+}
+
+func TestWebhookSkipsInvalidRules(t *testing.T) {
+	app := newTestApp(t)
+	app.Metrics = newTestMetrics()
+	// Rules stored before validation was tightened: an invalid pattern and a pattern expanding to an overlong label
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u..example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u%u.long.example.org", ZoneSoa: "long.example.org", TargetUserFilter: "*@example.org"})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+
+	user := auth.UserClaims{Email: strings.Repeat("j", 40) + "@example.org"}
+	zones := callWebhook(t, router, "", user)
+	if names := zoneNames(zones); len(names) != 1 || names[0] != strings.Repeat("j", 40)+".users.example.org" {
+		t.Fatalf("expected only the zone of the valid rule, got %v", names)
+	}
+
+	if body := scrapeMetrics(t, app.Metrics); !strings.Contains(body, "dns_api_webhook_rules_skipped_total 2\n") {
+		t.Fatalf("expected 2 skipped rules, got:\n%s", body)
+	}
+}
+
+// batchBody returns the body of a batch webhook request for count users.
+func batchBody(count int) string {
+	users := make([]string, count)
+	for i := range users {
+		users[i] = fmt.Sprintf(`{"sub":"user-%d","email":"user-%d@example.org"}`, i, i)
+	}
+	return "[" + strings.Join(users, ",") + "]"
+}
+
+func TestWebhookBatchSizeLimit(t *testing.T) {
+	app := newTestApp(t)
+	app.Config.DnsPolicyConfig.WebhookMaxBatchSize = 3
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+
+	// A batch at the limit is evaluated
+	results := decodeResponse[[]WebhookBatchResult](t, performRequest(router, "POST", "/v1/webhook/dns-policy/batch", "", batchBody(3)), 200)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	for i, result := range results {
+		if result.Subject != fmt.Sprintf("user-%d", i) || len(result.Zones) != 1 || result.Zones[0].Zone != fmt.Sprintf("user-%d.users.example.org", i) {
+			t.Fatalf("unexpected result %d: %+v", i, result)
+		}
+	}
+
+	// A batch beyond the limit is rejected with the limit
+	w := performRequest(router, "POST", "/v1/webhook/dns-policy/batch", "", batchBody(4))
+	apiError := decodeResponse[helper.APIError](t, w, 413)
+	if apiError.Code != helper.ErrorCodePayloadTooLarge || apiError.Details == nil {
+		t.Fatalf("unexpected error %+v", apiError)
+	}
+	if details := apiError.Details.(map[string]any); details["max_batch_size"] != float64(3) {
+		t.Fatalf("expected the maximum batch size in the details, got %+v", apiError.Details)
+	}
+}
+
+// BenchmarkWebhookBatch compares evaluating a large batch sequentially with evaluating it concurrently.
+// SQLite serializes the queries of the evaluation, so the gain of more workers shows with a database server.
+func BenchmarkWebhookBatch(b *testing.B) {
+	const batchSize = 500
+	body := batchBody(batchSize)
+
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers_%d", workers), func(b *testing.B) {
+			app := newTestApp(b)
+			app.Config.DnsPolicyConfig.WebhookMaxBatchSize = batchSize
+			app.Config.DnsPolicyConfig.BatchConcurrency = workers
+			for i := range 50 {
+				createTestRule(b, app, storage.PolicyRule{ZonePattern: fmt.Sprintf("%%u.zone-%d.example.org", i), ZoneSoa: fmt.Sprintf("zone-%d.example.org", i), TargetUserFilter: "*@example.org"})
+			}
+			router := newTestRouter(app)
+
+			for b.Loop() {
+				if w := performRequest(router, "POST", "/v1/webhook/dns-policy/batch", "", body); w.Code != 200 {
+					b.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookTrailingDot(t *testing.T) {
+	app := newTestApp(t)
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org.", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+	user := auth.UserClaims{Email: "jane@example.org"}
+
+	// By default, zones are returned as stored
+	zones := callWebhook(t, router, "", user)
+	if len(zones) != 1 || zones[0].Zone != "jane.users.example.org" {
+		t.Fatalf("expected the zone without a trailing dot, got %+v", zones)
+	}
+
+	// With a trailing dot, names already ending with a dot do not get a second one
+	zones = callWebhook(t, router, "?trailing_dot=true", user)
+	if len(zones) != 1 || zones[0].Zone != "jane.users.example.org." || zones[0].ZoneSOA != "users.example.org." {
+		t.Fatalf("expected fully-qualified names, got %+v", zones)
+	}
+
+	app.Config.DnsPolicyConfig.WebhookTrailingDotZones = true
+	zones = callWebhook(t, router, "", user)
+	if len(zones) != 1 || zones[0].Zone != "jane.users.example.org." {
+		t.Fatalf("expected fully-qualified names by default, got %+v", zones)
+	}
+
+	// The query parameter overrides the configured default
+	zones = callWebhook(t, router, "?trailing_dot=false", user)
+	if len(zones) != 1 || zones[0].Zone != "jane.users.example.org" {
+		t.Fatalf("expected the zone without a trailing dot, got %+v", zones)
+	}
+
+	if w := performRequest(router, "POST", "/v1/webhook/dns-policy?trailing_dot=maybe", "", `{"email":"jane@example.org"}`); w.Code != 400 {
+		t.Fatalf("expected 400 for an invalid trailing_dot value, got %d", w.Code)
+	}
+}
+
+func TestWebhookMaxGroupExpansionsPerRule(t *testing.T) {
+	app := newTestApp(t)
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%g.groups.example.org", ZoneSoa: "groups.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+	// Groups without a usable label and groups yielding the same zone do not count towards the limit
+	user := auth.UserClaims{Email: "jane@example.org", Groups: []string{"Admins", "!!!", "admins", "Dev Ops", "staff"}}
+	all := []string{"admins.groups.example.org", "dev-ops.groups.example.org", "staff.groups.example.org"}
+
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{0, all},
+		{1, all[:1]},
+		{2, all[:2]},
+		{3, all},
+		{4, all},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("limit %d", test.limit), func(t *testing.T) {
+			app.Config.DnsPolicyConfig.MaxGroupExpansionsPerRule = test.limit
+			core, logs := observer.New(zap.WarnLevel)
+			app.Log = zap.New(core).Sugar()
+
+			names := zoneNames(callWebhook(t, router, "", user))
+			if !slices.Equal(names, test.want) {
+				t.Fatalf("expected zones %v, got %v", test.want, names)
+			}
+			if truncated := len(test.want) < len(all); logs.FilterMessageSnippet("ignoring the remaining").Len() != map[bool]int{false: 0, true: 1}[truncated] {
+				t.Fatalf("expected a warning only when truncating, got %v", logs.All())
+			}
+		})
+	}
+
+	// A user without any usable group gets no zone from the rule
+	zones := callWebhook(t, router, "", auth.UserClaims{Email: "jane@example.org", Groups: []string{"!!!", "???"}})
+	if len(zones) != 0 {
+		t.Fatalf("expected no zones, got %v", zoneNames(zones))
+	}
+}
+
+func TestWebhookGroupBySoa(t *testing.T) {
+	app := newTestApp(t)
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.a.example.org", ZoneSoa: "a.example.org", TargetUserFilter: "*@example.org", IncludeWww: true})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.b.example.org", ZoneSoa: "b.example.org", TargetUserFilter: "*@example.org"})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.c.example.org", ZoneSoa: "c.example.org", TargetUserFilter: "*@other.org"})
+	router := newTestRouter(app)
+	body := `{"email":"jane@example.org"}`
+
+	// The flat list is the default
+	zones := decodeResponse[[]ZoneResponse](t, performRequest(router, "POST", "/v1/webhook/dns-policy", "", body), 200)
+	if names := zoneNames(zones); !slices.Equal(names, []string{"jane.a.example.org", "jane.b.example.org", "www.jane.a.example.org"}) {
+		t.Fatalf("unexpected zones %v", names)
+	}
+
+	grouped := decodeResponse[map[string][]ZoneResponse](t, performRequest(router, "POST", "/v1/webhook/dns-policy?group_by_soa=true", "", body), 200)
+	if len(grouped) != 2 {
+		t.Fatalf("expected the zones of 2 SOAs, got %v", grouped)
+	}
+	if names := zoneNames(grouped["a.example.org"]); !slices.Equal(names, []string{"jane.a.example.org", "www.jane.a.example.org"}) {
+		t.Fatalf("unexpected zones of a.example.org: %v", names)
+	}
+	if names := zoneNames(grouped["b.example.org"]); !slices.Equal(names, []string{"jane.b.example.org"}) {
+		t.Fatalf("unexpected zones of b.example.org: %v", names)
+	}
+
+	// The SOAs are grouped by their returned (fully-qualified) names
+	grouped = decodeResponse[map[string][]ZoneResponse](t, performRequest(router, "POST", "/v1/webhook/dns-policy?group_by_soa=true&trailing_dot=true", "", body), 200)
+	if len(grouped["a.example.org."]) != 2 || len(grouped["b.example.org."]) != 1 {
+		t.Fatalf("expected fully-qualified SOAs, got %v", grouped)
+	}
+
+	// The configured default can be overridden per request
+	app.Config.DnsPolicyConfig.WebhookGroupBySoa = true
+	grouped = decodeResponse[map[string][]ZoneResponse](t, performRequest(router, "POST", "/v1/webhook/dns-policy", "", body), 200)
+	if len(grouped) != 2 {
+		t.Fatalf("expected grouped zones by default, got %v", grouped)
+	}
+	zones = decodeResponse[[]ZoneResponse](t, performRequest(router, "POST", "/v1/webhook/dns-policy?group_by_soa=false", "", body), 200)
+	if len(zones) != 3 {
+		t.Fatalf("expected the flat list, got %v", zones)
+	}
+
+	// A user matching no rules gets an empty map
+	grouped = decodeResponse[map[string][]ZoneResponse](t, performRequest(router, "POST", "/v1/webhook/dns-policy", "", `{"email":"jane@nowhere.org"}`), 200)
+	if grouped == nil || len(grouped) != 0 {
+		t.Fatalf("expected an empty map, got %v", grouped)
+	}
+}
+
+func TestExpandZonePatternUserLabelSource(t *testing.T) {
+	claims := &auth.UserClaims{Subject: "0a1b-2c3d", Email: "Jane.Doe@Example.org", PreferredUsername: "jdoe"}
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"email", "jane-doe-at-example-org.users.example.org"},
+		{"local_part", "jane-doe.users.example.org"},
+		{"sub", "0a1b-2c3d.users.example.org"},
+		{"preferred_username", "jdoe.users.example.org"},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			zone, err := ExpandZonePattern("%u.users.example.org", claims, test.source)
+			if err != nil || zone != test.want {
+				t.Fatalf("expected '%s', got '%s' (%v)", test.want, zone, err)
+			}
+
+			// A claim without usable characters is an error of the user
+			_, err = ExpandZonePattern("%u.users.example.org", &auth.UserClaims{Subject: "!!!"}, test.source)
+			if !errors.Is(err, errMissingUserLabel) {
+				t.Fatalf("expected errMissingUserLabel, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWebhookMissingUserLabel(t *testing.T) {
+	app := newTestApp(t)
+	app.Config.DnsPolicyConfig.UserLabelSource = "preferred_username"
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+
+	zones := callWebhook(t, router, "", auth.UserClaims{Email: "jane@example.org", PreferredUsername: "jdoe"})
+	if names := zoneNames(zones); !slices.Equal(names, []string{"jdoe.users.example.org"}) {
+		t.Fatalf("unexpected zones %v", names)
+	}
+
+	w := performRequest(router, "POST", "/v1/webhook/dns-policy", "", `{"email":"jane@example.org"}`)
+	if apiError := decodeResponse[helper.APIError](t, w, 422); apiError.Code != helper.ErrorCodeMissingUserLabel {
+		t.Fatalf("unexpected error %+v", apiError)
+	}
+}
+
+func TestWebhookEmptyApiKey(t *testing.T) {
+	app := newTestApp(t)
+	app.Config.DnsPolicyConfig.WebhookApiKey = ""
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+
+	// Without a configured key, no token authenticates, not even an empty one
+	for _, authorization := range []string{"", "Bearer ", "Bearer " + testWebhookApiKey} {
+		for _, path := range []string{"/v1/webhook/dns-policy", "/v1/webhook/dns-policy/batch"} {
+			req := httptest.NewRequest("POST", path, strings.NewReader(`{"email":"jane@example.org"}`))
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if apiError := decodeResponse[helper.APIError](t, w, 503); apiError.Code != helper.ErrorCodeNotConfigured {
+				t.Fatalf("%s with '%s': unexpected error %+v", path, authorization, apiError)
+			}
+		}
+	}
+}
+
+func TestWebhookRuleExpiry(t *testing.T) {
+	app := newTestApp(t)
+	app.Config.DnsPolicyConfig.WebhookDebugEnabled = true
+	expired, later := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	expiredRule := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.expired.example.org", ZoneSoa: "expired.example.org", TargetUserFilter: "*@example.org", ExpiresAt: &expired})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.later.example.org", ZoneSoa: "later.example.org", TargetUserFilter: "*@example.org", ExpiresAt: &later})
+	router := newTestRouter(app)
+
+	type debugResponse struct {
+		Zones []ZoneResponse   `json:"zones"`
+		Rules []RuleEvaluation `json:"rules"`
+	}
+	response := decodeResponse[debugResponse](t, performRequest(router, "POST", "/v1/webhook/dns-policy?debug=true", "", `{"email":"jane@example.org"}`), 200)
+	if names := zoneNames(response.Zones); !slices.Equal(names, []string{"jane.later.example.org"}) {
+		t.Fatalf("expected only the zone of the rule that has not expired, got %v", names)
+	}
+	for _, evaluation := range response.Rules {
+		if evaluation.RuleID == (RuleKey{ID: expiredRule.ID}) && (evaluation.Applied || evaluation.Reason != "rule is expired") {
+			t.Fatalf("unexpected evaluation of the expired rule: %+v", evaluation)
+		}
+	}
+}
+
+func TestWebhookFallbackZone(t *testing.T) {
+	app := newTestApp(t)
+	app.Config.DnsPolicyConfig.FallbackZonePattern = "%u.guests.example.org"
+	app.Config.DnsPolicyConfig.FallbackZoneSOA = "guests.example.org"
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+
+	// Users matching a rule do not get the fallback zone
+	zones := callWebhook(t, router, "", auth.UserClaims{Email: "jane@example.org"})
+	if names := zoneNames(zones); !slices.Equal(names, []string{"jane.users.example.org"}) {
+		t.Fatalf("expected only the zone of the rule for a matched user, got %v", names)
+	}
+
+	// Users matching no rule get the fallback zone as if it were a rule
+	zones = callWebhook(t, router, "", auth.UserClaims{Email: "bob@example.com"})
+	if len(zones) != 1 || zones[0].Zone != "bob.guests.example.org" || zones[0].ZoneSOA != "guests.example.org" || zones[0].AccessLevel != storage.AccessLevelManage {
+		t.Fatalf("expected the fallback zone for an unmatched user, got %+v", zones)
+	}
+
+	// Without a fallback, unmatched users get no zones
+	app.Config.DnsPolicyConfig.FallbackZonePattern = ""
+	app.Config.DnsPolicyConfig.FallbackZoneSOA = ""
+	if zones := callWebhook(t, router, "", auth.UserClaims{Email: "bob@example.com"}); len(zones) != 0 {
+		t.Fatalf("expected no zones without a fallback, got %+v", zones)
+	}
+}
+
+func TestWebhookAccessLevel(t *testing.T) {
+	app := newTestApp(t)
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org", AccessLevel: storage.AccessLevelManage})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.readonly.example.org", ZoneSoa: "readonly.example.org", TargetUserFilter: "*@example.org", AccessLevel: storage.AccessLevelView})
+	router := newTestRouter(app)
+	user := auth.UserClaims{Email: "jane@example.org"}
+
+	tests := []struct {
+		query string
+		want  map[string]string
+	}{
+		{"", map[string]string{"jane.users.example.org": storage.AccessLevelManage, "jane.readonly.example.org": storage.AccessLevelView}},
+		{"?level=manage", map[string]string{"jane.users.example.org": storage.AccessLevelManage}},
+		{"?level=view", map[string]string{"jane.readonly.example.org": storage.AccessLevelView}},
+	}
+	for _, test := range tests {
+		zones := callWebhook(t, router, test.query, user)
+		if len(zones) != len(test.want) {
+			t.Fatalf("%s: expected zones %v, got %+v", test.query, test.want, zones)
+		}
+		for _, zone := range zones {
+			if test.want[zone.Zone] != zone.AccessLevel {
+				t.Fatalf("%s: expected zones %v, got %+v", test.query, test.want, zones)
+			}
+		}
+	}
+
+	w := performRequest(router, "POST", "/v1/webhook/dns-policy?level=admin", "", `{"email":"jane@example.org"}`)
+	if apiError := decodeResponse[helper.APIError](t, w, 400); apiError.Code != helper.ErrorCodeInvalidRequest {
+		t.Fatalf("unexpected error %+v", apiError)
+	}
+}
+
+func TestWebhookDescription(t *testing.T) {
+	app := newTestApp(t)
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org", Description: "Personal zones of all staff"})
+	router := newTestRouter(app)
+	user := auth.UserClaims{Email: "jane@example.org"}
+
+	// The default response is lean
+	w := performRequest(router, "POST", "/v1/webhook/dns-policy", "", `{"email":"jane@example.org"}`)
+	if w.Code != 200 || strings.Contains(w.Body.String(), "description") {
+		t.Fatalf("expected a response without descriptions, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The query parameter enables the descriptions per request
+	zones := callWebhook(t, router, "?include_description=true", user)
+	if len(zones) != 1 || zones[0].Description != "Personal zones of all staff" {
+		t.Fatalf("expected the description of the rule, got %+v", zones)
+	}
+
+	// The configuration enables them by default, which the query parameter overrides
+	app.Config.DnsPolicyConfig.WebhookIncludeDescription = true
+	if zones := callWebhook(t, router, "", user); len(zones) != 1 || zones[0].Description != "Personal zones of all staff" {
+		t.Fatalf("expected the description of the rule, got %+v", zones)
+	}
+	w = performRequest(router, "POST", "/v1/webhook/dns-policy?include_description=false", "", `{"email":"jane@example.org"}`)
+	if w.Code != 200 || strings.Contains(w.Body.String(), "description") {
+		t.Fatalf("expected a response without descriptions, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWebhookDeduplicatesZones(t *testing.T) {
+	app := newTestApp(t)
+	core, logs := observer.New(zap.WarnLevel)
+	app.Log = zap.New(core).Sugar()
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "zz.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"})
+	users := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "jane.users.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"})
+	router := newTestRouter(app)
+	app.Config.DnsPolicyConfig.WebhookIncludeRuleID = true
+
+	// The zone produced by two overlapping rules is returned once with the SOA of the lower rule ID,
+	// and the zones are sorted by name
+	zones := callWebhook(t, router, "", auth.UserClaims{Email: "jane@example.org"})
+	if names := zoneNames(zones); !slices.Equal(names, []string{"jane.users.example.org", "zz.example.org"}) {
+		t.Fatalf("expected a single sorted zone list, got %v", names)
+	}
+	if zones[0].ZoneSOA != "users.example.org" || zones[0].RuleID == nil || zones[0].RuleID.ID != users.ID {
+		t.Fatalf("expected the zone of rule %d, got %+v", users.ID, zones[0])
+	}
+	if logs.FilterMessageSnippet("with different SOAs").Len() != 1 {
+		t.Fatalf("expected a warning about the differing SOAs, got %v", logs.All())
+	}
+}
+
+func TestWebhookMetrics(t *testing.T) {
+	app := newTestApp(t)
+	app.Metrics = newTestMetrics()
+	createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org", IncludeWww: true})
+
+	// Mount the webhook and the metrics like the application does
+	router := gin.New()
+	router.Use(app.Metrics.Middleware())
+	router.GET("/metrics", gin.WrapH(app.Metrics.Handler()))
+	CreateWebhookApiGroup(router.Group("/v1/webhook"), app)
+
+	zones := callWebhook(t, router, "", auth.UserClaims{Email: "jane@example.org"})
+	if len(zones) != 2 {
+		t.Fatalf("expected 2 zones, got %+v", zones)
+	}
+
+	w := performRequest(router, "GET", "/metrics", "", "")
+	if w.Code != 200 {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	for _, sample := range []string{
+		metrics.Namespace + `_http_requests_total{method="POST",route="/v1/webhook/dns-policy",status="200"} 1`,
+		metrics.Namespace + `_webhook_zone_expansions_total 2`,
+	} {
+		if !strings.Contains(w.Body.String(), sample) {
+			t.Fatalf("expected '%s' in the metrics:\n%s", sample, w.Body.String())
+		}
+	}
+}
+
+func TestWebhookDebug(t *testing.T) {
+	app := newTestApp(t)
+	app.Config.WebServer.SessionSecret = "test-session-secret"
+	matched := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	unmatched := createTestRule(t, app, storage.PolicyRule{ZonePattern: "%u.staff.example.org", ZoneSoa: "staff.example.org", TargetUserFilter: "*@staff.example.org"})
+	router := newTestRouter(app)
+	body := `{"email":"jane@example.org"}`
+
+	// Without the debug flag, debug mode is rejected and the response keeps its shape
+	if w := performRequest(router, "POST", "/v1/webhook/dns-policy?debug=true", "", body); w.Code != 403 {
+		t.Fatalf("expected 403 with debug mode disabled, got %d", w.Code)
+	}
+	app.Config.DnsPolicyConfig.WebhookDebugEnabled = true
+	if zones := callWebhook(t, router, "", auth.UserClaims{Email: "jane@example.org"}); !slices.Equal(zoneNames(zones), []string{"jane.users.example.org"}) {
+		t.Fatalf("expected the plain zone list without debug=true, got %+v", zones)
+	}
+
+	w := performRequest(router, "POST", "/v1/webhook/dns-policy?debug=true", "", body)
+	response := decodeResponse[struct {
+		Zones []ZoneResponse   `json:"zones"`
+		Rules []RuleEvaluation `json:"rules"`
+	}](t, w, 200)
+	if !slices.Equal(zoneNames(response.Zones), []string{"jane.users.example.org"}) {
+		t.Fatalf("unexpected zones %+v", response.Zones)
+	}
+	if len(response.Rules) != 2 {
+		t.Fatalf("expected the evaluation of both rules, got %+v", response.Rules)
+	}
+	if got := response.Rules[0]; got.RuleID != (RuleKey{ID: matched.ID}) || got.ZonePattern != matched.ZonePattern || !got.FilterMatched || !got.Applied || !slices.Equal(got.ExpandedZones, []string{"jane.users.example.org"}) {
+		t.Fatalf("unexpected evaluation of the matched rule %+v", got)
+	}
+	if got := response.Rules[1]; got.RuleID != (RuleKey{ID: unmatched.ID}) || got.ZonePattern != unmatched.ZonePattern || got.FilterMatched || got.Applied || len(got.ExpandedZones) != 0 || got.Reason == "" {
+		t.Fatalf("unexpected evaluation of the unmatched rule %+v", got)
+	}
+
+	// The debug output never contains the webhook API key or other secrets
+	for _, secret := range []string{testWebhookApiKey, app.Config.WebServer.SessionSecret} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Fatalf("the debug output leaks a secret: %s", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+)
+
+// ErrHistoryUnavailable is returned when the rules as of a point in time cannot be reconstructed, as the audit log
+// does not reach back that far.
+var ErrHistoryUnavailable = errors.New("the rule history is not available for this point in time")
+
+// auditLogBatchSize is the number of audit log entries inserted per statement by bulk mutations.
+const auditLogBatchSize = 500
+
+// AuditRequestBodyMaxBytes is the maximum number of bytes of a request body recorded in the audit log.
+const AuditRequestBodyMaxBytes = 16 * 1024
+
+// AuditLog records a single mutation of a PolicyRule. Entries are written in the same transaction as the
+// mutation, so every committed change has exactly one entry per affected rule.
+type AuditLog struct {
+	ID        int64     `gorm:"primaryKey" json:"id"`
+	Timestamp time.Time `gorm:"not null;index" json:"timestamp"`
+	// Email of the user who performed the mutation (empty for mutations without a user, e.g. dummy data)
+	ActorEmail string `gorm:"type:varchar(255);index" json:"actor_email"`
+	// The kind of mutation (create, update, or delete); restoring a deleted rule is recorded as an update
+	Action string `gorm:"type:varchar(16);not null" json:"action"`
+	RuleID int64  `gorm:"not null;index" json:"rule_id"`
+	// The rule as JSON before the mutation (null for creates)
+	BeforeJSON *string `gorm:"type:text" json:"before_json"`
+	// The rule as JSON after the mutation (null for deletes)
+	AfterJSON *string `gorm:"type:text" json:"after_json"`
+	// The raw body of the request causing the mutation, capped at AuditRequestBodyMaxBytes (null if not recorded)
+	RequestBody *string `gorm:"type:text" json:"request_body"`
+	// Whether the request body was longer than recorded
+	RequestBodyTruncated bool `gorm:"not null;default:false" json:"request_body_truncated"`
+}
+
+// actorKey is the context key of the email of the user performing mutations.
+type actorKey struct{}
+
+// WithActor returns a context recording the email of the user performing the mutations run with it.
+// The email is written to the audit log entries of these mutations.
+func WithActor(ctx context.Context, email string) context.Context {
+	return context.WithValue(ctx, actorKey{}, email)
+}
+
+// actorFromContext returns the email set by WithActor, or an empty string if none is set.
+func actorFromContext(ctx context.Context) string {
+	email, _ := ctx.Value(actorKey{}).(string)
+	return email
+}
+
+// auditRequestBody is a request body recorded in the audit log.
+type auditRequestBody struct {
+	body      string
+	truncated bool
+}
+
+// newAuditRequestBody caps the body at AuditRequestBodyMaxBytes. Invalid UTF-8 (e.g. a rune cut by the cap)
+// and NUL bytes are dropped, as not all databases store them in text columns.
+func newAuditRequestBody(body []byte, truncated bool) auditRequestBody {
+	if len(body) > AuditRequestBodyMaxBytes {
+		body, truncated = body[:AuditRequestBodyMaxBytes], true
+	}
+	text := strings.ReplaceAll(strings.ToValidUTF8(string(body), ""), "\x00", "")
+	return auditRequestBody{body: text, truncated: truncated}
+}
+
+// requestBodyKey is the context key of the request body of the mutations.
+type requestBodyKey struct{}
+
+// WithRequestBody returns a context recording the raw body of the request performing the mutations run with it.
+// The body is written to the audit log entries of these mutations; truncated reports whether the caller
+// already cut the body short.
+func WithRequestBody(ctx context.Context, body []byte, truncated bool) context.Context {
+	return context.WithValue(ctx, requestBodyKey{}, newAuditRequestBody(body, truncated))
+}
+
+// requestBodiesKey is the context key of the request bodies of the rules of a bulk create.
+type requestBodiesKey struct{}
+
+// WithRequestBodies returns a context recording the raw request body of each rule of a bulk create (see
+// PolicyBulkCreateCtx), in input order. Each body is written to the audit log entry of its rule.
+func WithRequestBodies(ctx context.Context, bodies [][]byte) context.Context {
+	recorded := make([]auditRequestBody, len(bodies))
+	for i, body := range bodies {
+		recorded[i] = newAuditRequestBody(body, false)
+	}
+	return context.WithValue(ctx, requestBodiesKey{}, recorded)
+}
+
+// setRequestBody sets the request body of the audit log entry.
+func (entry *AuditLog) setRequestBody(requestBody auditRequestBody) {
+	entry.RequestBody = &requestBody.body
+	entry.RequestBodyTruncated = requestBody.truncated
+}
+
+// newAuditLog creates an audit log entry of a mutation by the actor of the context, including the request body
+// of the context (see WithRequestBody). before is nil for creates and after is nil for deletes.
+func newAuditLog(ctx context.Context, action string, ruleID int64, before *PolicyRule, after *PolicyRule) (AuditLog, error) {
+	entry := AuditLog{Timestamp: time.Now(), ActorEmail: actorFromContext(ctx), Action: action, RuleID: ruleID}
+	if requestBody, ok := ctx.Value(requestBodyKey{}).(auditRequestBody); ok {
+		entry.setRequestBody(requestBody)
+	}
+
+	var err error
+	if entry.BeforeJSON, err = auditSnapshot(before); err != nil {
+		return entry, err
+	}
+	if entry.AfterJSON, err = auditSnapshot(after); err != nil {
+		return entry, err
+	}
+	return entry, nil
+}
+
+// auditSnapshot serializes the rule for the audit log. It returns nil for a nil rule.
+func auditSnapshot(rule *PolicyRule) (*string, error) {
+	if rule == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize rule %d for the audit log: %w", rule.ID, err)
+	}
+	snapshot := string(data)
+	return &snapshot, nil
+}
+
+// writeAuditLog writes an audit log entry of a mutation within the transaction of the mutation.
+func writeAuditLog(tx *gorm.DB, action string, ruleID int64, before *PolicyRule, after *PolicyRule) error {
+	entry, err := newAuditLog(tx.Statement.Context, action, ruleID, before, after)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&entry).Error
+}
+
+// AuditGetPage wraps AuditGetPageCtx using context.Background.
+func (s *Storage) AuditGetPage(offset int, limit int) ([]AuditLog, int64, error) {
+	return s.AuditGetPageCtx(context.Background(), offset, limit)
+}
+
+// AuditGetPageCtx retrieves up to limit audit log entries after skipping offset entries, newest first.
+// It also returns the total number of entries.
+func (s *Storage) AuditGetPageCtx(ctx context.Context, offset int, limit int) ([]AuditLog, int64, error) {
+	s = s.withContext(ctx)
+
+	var total int64
+	if result := s.db.Model(&AuditLog{}).Count(&total); result.Error != nil {
+		return nil, 0, fmt.Errorf("storage.AuditGetPage: Failed to count audit log entries: %w", result.Error)
+	}
+
+	var entries []AuditLog
+	result := s.db.Order("timestamp desc, id desc").Offset(offset).Limit(limit).Find(&entries)
+	if result.Error != nil {
+		return nil, 0, fmt.Errorf("storage.AuditGetPage: Failed to retrieve audit log entries: %w", result.Error)
+	}
+	return entries, total, nil
+}
+
+// PolicyGetAllAt wraps PolicyGetAllAtCtx using context.Background.
+func (s *Storage) PolicyGetAllAt(at time.Time) ([]PolicyRule, error) {
+	return s.PolicyGetAllAtCtx(context.Background(), at)
+}
+
+// PolicyGetAllAtCtx reconstructs the PolicyRules as they were at the given time from the audit log, in ID order.
+// The state of a rule is the state after its last change at or before that time (a delete removes the rule), or,
+// if it only changed later, the state before its first later change. Rules that never changed since the audit log
+// began are returned in their current state if they existed at that time. Since changes before the first audit log
+// entry were not recorded, ErrHistoryUnavailable is returned for times before it (or if the audit log is empty).
+func (s *Storage) PolicyGetAllAtCtx(ctx context.Context, at time.Time) ([]PolicyRule, error) {
+	s = s.withContext(ctx)
+
+	var first AuditLog
+	if err := s.db.Order("timestamp asc, id asc").First(&first).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrHistoryUnavailable
+		}
+		return nil, fmt.Errorf("storage.GetAllAt: Failed to retrieve the first audit log entry: %w", err)
+	}
+	if at.Before(first.Timestamp) {
+		return nil, ErrHistoryUnavailable
+	}
+
+	// Stream the audit log per rule in chronological order, keeping the state of each changed rule at that time
+	rows, err := s.db.Model(&AuditLog{}).Order("rule_id asc, timestamp asc, id asc").Rows()
+	if err != nil {
+		return nil, fmt.Errorf("storage.GetAllAt: Failed to query the audit log: %w", err)
+	}
+	defer rows.Close()
+
+	snapshots := make(map[int64]*string)
+	for rows.Next() {
+		var entry AuditLog
+		if err := s.db.ScanRows(rows, &entry); err != nil {
+			return nil, fmt.Errorf("storage.GetAllAt: Failed to scan audit log entry: %w", err)
+		}
+
+		if !entry.Timestamp.After(at) {
+			snapshots[entry.RuleID] = entry.AfterJSON
+		} else if _, known := snapshots[entry.RuleID]; !known {
+			snapshots[entry.RuleID] = entry.BeforeJSON
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage.GetAllAt: Failed to iterate the audit log: %w", err)
+	}
+
+	var rules []PolicyRule
+	for ruleID, snapshot := range snapshots {
+		if snapshot == nil {
+			continue
+		}
+		var rule PolicyRule
+		if err := json.Unmarshal([]byte(*snapshot), &rule); err != nil {
+			return nil, fmt.Errorf("storage.GetAllAt: Failed to parse the audit log snapshot of rule %d: %w", ruleID, err)
+		}
+		// A state before a later change may stem from a rule that did not exist yet or was already deleted then
+		if existedAt(rule, at) {
+			rules = append(rules, rule)
+		}
+	}
+
+	// Rules without changes since the audit log began are unchanged since then
+	var unchanged []PolicyRule
+	if err := s.db.Where("created_at <= ?", at).Order("id asc").Find(&unchanged).Error; err != nil {
+		return nil, fmt.Errorf("storage.GetAllAt: Failed to retrieve rules: %w", err)
+	}
+	for _, rule := range unchanged {
+		if _, changed := snapshots[rule.ID]; !changed {
+			rules = append(rules, rule)
+		}
+	}
+
+	slices.SortFunc(rules, func(a, b PolicyRule) int { return cmp.Compare(a.ID, b.ID) })
+	return rules, nil
+}
+
+// existedAt reports whether the rule was created at or before the given time and not deleted at that time.
+func existedAt(rule PolicyRule, at time.Time) bool {
+	return !rule.CreatedAt.After(at) && (!rule.DeletedAt.Valid || rule.DeletedAt.Time.After(at))
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tick returns the current time, making sure later changes get a later timestamp.
+func tick() time.Time {
+	now := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	return now
+}
+
+// ruleDescriptions maps the IDs of the rules to their descriptions.
+func ruleDescriptions(rules []PolicyRule) map[int64]string {
+	descriptions := make(map[int64]string, len(rules))
+	for _, rule := range rules {
+		descriptions[rule.ID] = rule.Description
+	}
+	return descriptions
+}
+
+func TestPolicyGetAllAt(t *testing.T) {
+	s := newTestStorage(t)
+
+	// A rule created before the audit log began (inserted without an audit log entry)
+	legacy := PolicyRule{ZonePattern: "%u.legacy.example.org", ZoneSoa: "legacy.example.org", TargetUserFilter: "*@example.org", Description: "legacy", CreatedAt: time.Now().Add(-time.Hour)}
+	if err := s.db.Create(&legacy).Error; err != nil {
+		t.Fatalf("failed to insert legacy rule: %v", err)
+	}
+	if _, err := s.PolicyGetAllAt(time.Now()); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Fatalf("expected ErrHistoryUnavailable without audit log, got %v", err)
+	}
+
+	beforeAudit := tick()
+	first, err := s.PolicyCreate(&PolicyRule{ZonePattern: "%u.first.example.org", ZoneSoa: "first.example.org", TargetUserFilter: "*@example.org", Description: "created"})
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	afterCreate := tick()
+
+	first.Description = "updated"
+	if _, err := s.PolicyUpdate(first); err != nil {
+		t.Fatalf("failed to update rule: %v", err)
+	}
+	afterUpdate := tick()
+
+	second, err := s.PolicyCreate(&PolicyRule{ZonePattern: "%u.second.example.org", ZoneSoa: "second.example.org", TargetUserFilter: "*@example.org", Description: "second"})
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	legacy.Description = "legacy updated"
+	if _, err := s.PolicyUpdate(&legacy); err != nil {
+		t.Fatalf("failed to update legacy rule: %v", err)
+	}
+	afterSecond := tick()
+
+	if err := s.PolicyDelete(first.ID); err != nil {
+		t.Fatalf("failed to delete rule: %v", err)
+	}
+	afterDelete := tick()
+
+	if _, err := s.PolicyGetAllAt(beforeAudit); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Fatalf("expected ErrHistoryUnavailable before the first audit log entry, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want map[int64]string
+	}{
+		{"after create", afterCreate, map[int64]string{legacy.ID: "legacy", first.ID: "created"}},
+		{"after update", afterUpdate, map[int64]string{legacy.ID: "legacy", first.ID: "updated"}},
+		{"after second create", afterSecond, map[int64]string{legacy.ID: "legacy updated", first.ID: "updated", second.ID: "second"}},
+		{"after delete", afterDelete, map[int64]string{legacy.ID: "legacy updated", second.ID: "second"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := s.PolicyGetAllAt(test.at)
+			if err != nil {
+				t.Fatalf("PolicyGetAllAt failed: %v", err)
+			}
+			for i := 1; i < len(rules); i++ {
+				if rules[i].ID <= rules[i-1].ID {
+					t.Fatalf("rules are not in ID order: %d after %d", rules[i].ID, rules[i-1].ID)
+				}
+			}
+			got := ruleDescriptions(rules)
+			if len(got) != len(test.want) {
+				t.Fatalf("expected rules %v, got %v", test.want, got)
+			}
+			for id, description := range test.want {
+				if got[id] != description {
+					t.Fatalf("expected rules %v, got %v", test.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditLogRequestBody(t *testing.T) {
+	s := newTestStorage(t)
+
+	// Mutations without a request body record none
+	if _, err := s.PolicyCreate(&PolicyRule{ZonePattern: "%u.plain.example.org", ZoneSoa: "plain.example.org", TargetUserFilter: "*@example.org"}); err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+
+	body := `{"zone_pattern":"%u.users.example.org","description":"` + strings.Repeat("ä", AuditRequestBodyMaxBytes) + `"}`
+	ctx := WithRequestBody(context.Background(), []byte(body), false)
+	if _, err := s.PolicyCreateCtx(ctx, &PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"}); err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+
+	bulkBodies := [][]byte{[]byte(`{"zone_pattern":"%u.a.example.org"}`), []byte(`{"zone_pattern":"%u.b.example.org"}`)}
+	ctx = WithRequestBodies(context.Background(), bulkBodies)
+	if _, err := s.PolicyBulkCreateCtx(ctx, []PolicyRule{
+		{ZonePattern: "%u.a.example.org", ZoneSoa: "a.example.org", TargetUserFilter: "*@example.org"},
+		{ZonePattern: "%u.b.example.org", ZoneSoa: "b.example.org", TargetUserFilter: "*@example.org"},
+	}); err != nil {
+		t.Fatalf("failed to bulk create rules: %v", err)
+	}
+
+	entries, _, err := s.AuditGetPage(0, 10)
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("expected 4 audit log entries, got %d (%v)", len(entries), err)
+	}
+	// Newest first
+	if plain := entries[3]; plain.RequestBody != nil || plain.RequestBodyTruncated {
+		t.Errorf("expected no request body, got %+v", plain)
+	}
+	capped := entries[2]
+	if capped.RequestBody == nil || !capped.RequestBodyTruncated || len(*capped.RequestBody) > AuditRequestBodyMaxBytes || !strings.HasPrefix(body, *capped.RequestBody) {
+		t.Errorf("expected the request body to be capped at %d bytes at a rune boundary, got %+v", AuditRequestBodyMaxBytes, capped)
+	}
+	for i, entry := range []AuditLog{entries[1], entries[0]} {
+		if entry.RequestBody == nil || *entry.RequestBody != string(bulkBodies[i]) || entry.RequestBodyTruncated {
+			t.Errorf("expected the request body of rule %d, got %+v", i, entry)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+	"gorm.io/gorm"
+)
+
+// Database error codes reported when a unique constraint is violated
+const (
+	postgresUniqueViolationCode = "23505"
+	mysqlDuplicateEntryNumber   = 1062
+	// SQL Server reports violated unique constraints and unique indexes with different numbers
+	sqlserverUniqueConstraintNumber = 2627
+	sqlserverUniqueIndexNumber      = 2601
+)
+
+// isUniqueViolation reports whether the error was caused by a violated unique constraint.
+// Each driver reports the violation differently, so the driver-specific errors are checked.
+func isUniqueViolation(err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == postgresUniqueViolationCode
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDuplicateEntryNumber
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.Number == sqlserverUniqueConstraintNumber || mssqlErr.Number == sqlserverUniqueIndexNumber
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+
+	return false
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+	"gorm.io/gorm"
+)
+
+func TestPolicyDuplicateZonePattern(t *testing.T) {
+	s := newTestStorage(t)
+	first := createTestRules(t, s, 2)[0]
+
+	// The unique constraint of SQLite is reported as ErrDuplicateZonePattern
+	duplicate := PolicyRule{ZonePattern: first.ZonePattern, ZoneSoa: "other.example.org", TargetUserFilter: "*@example.org"}
+	if _, err := s.PolicyCreate(&duplicate); !errors.Is(err, ErrDuplicateZonePattern) {
+		t.Fatalf("expected ErrDuplicateZonePattern on create, got %v", err)
+	}
+
+	second, err := s.PolicyGetByID(first.ID + 1)
+	if err != nil {
+		t.Fatalf("PolicyGetByID failed: %v", err)
+	}
+	second.ZonePattern = first.ZonePattern
+	if _, err := s.PolicyUpdate(second); !errors.Is(err, ErrDuplicateZonePattern) {
+		t.Fatalf("expected ErrDuplicateZonePattern on update, got %v", err)
+	}
+
+	// Other failures are not reported as duplicates
+	missing := PolicyRule{ID: first.ID + 10, ZonePattern: "%u.missing.example.org"}
+	if _, err := s.PolicyUpdate(&missing); errors.Is(err, ErrDuplicateZonePattern) {
+		t.Fatalf("expected another error than ErrDuplicateZonePattern, got %v", err)
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{gorm.ErrDuplicatedKey, true},
+		{&pgconn.PgError{Code: postgresUniqueViolationCode}, true},
+		{&pgconn.PgError{Code: postgresDeadlockCode}, false},
+		{&mysql.MySQLError{Number: mysqlDuplicateEntryNumber}, true},
+		{&mysql.MySQLError{Number: mysqlDeadlockNumber}, false},
+		{mssql.Error{Number: sqlserverUniqueConstraintNumber}, true},
+		{mssql.Error{Number: sqlserverUniqueIndexNumber}, true},
+		{mssql.Error{Number: sqlserverDeadlockNumber}, false},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, true},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintNotNull}, false},
+		{fmt.Errorf("storage: %w", &pgconn.PgError{Code: postgresUniqueViolationCode}), true},
+		{errors.New("UNIQUE constraint failed"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if got := isUniqueViolation(test.err); got != test.want {
+			t.Errorf("isUniqueViolation(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Schema management modes reported by SchemaStatus
+const (
+	SchemaModeMigrations  = "migrations"
+	SchemaModeAutoMigrate = "automigrate"
+)
+
+// SchemaMigration records an applied migration in the schema_migrations table.
+type SchemaMigration struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"type:varchar(255);not null" json:"name"`
+	AppliedAt time.Time `gorm:"not null" json:"applied_at"`
+}
+
+// migration is a versioned change of the database schema (and its data).
+type migration struct {
+	Version int64
+	Name    string
+	Migrate func(tx *gorm.DB) error
+}
+
+// migrations lists all migrations in the order they are applied. Applied migrations must never be changed
+// or removed; each schema change is added as a new migration with the next version. Migrations must not
+// use the current models, as these change over time, but snapshots of the models at their version.
+var migrations = []migration{
+	{Version: 1, Name: "initial schema", Migrate: migrateInitialSchema},
+	{Version: 2, Name: "add the rule expiry", Migrate: migrateAddRuleExpiry},
+	{Version: 3, Name: "add the audit request body", Migrate: migrateAddAuditRequestBody},
+}
+
+// latestMigrationVersion returns the version of the last migration.
+func latestMigrationVersion() int64 {
+	return migrations[len(migrations)-1].Version
+}
+
+// migrate applies the pending migrations in version order. Each migration runs in its own transaction
+// together with the record of its version, so a failed migration is not recorded and is retried on the
+// next start. Note that MySQL commits schema changes implicitly, so they are not rolled back there.
+func migrate(db *gorm.DB, log *zap.SugaredLogger) error {
+	if err := adaptColumnTypes(db, []any{&SchemaMigration{}}); err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return fmt.Errorf("failed to create the schema_migrations table: %w", err)
+	}
+
+	var appliedVersions []int64
+	if err := db.Model(&SchemaMigration{}).Pluck("version", &appliedVersions).Error; err != nil {
+		return fmt.Errorf("failed to retrieve the applied migrations: %w", err)
+	}
+	applied := make(map[int64]struct{}, len(appliedVersions))
+	for _, version := range appliedVersions {
+		applied[version] = struct{}{}
+		if version > latestMigrationVersion() {
+			log.Warnf("storage.migrate: The database contains migration %d, which is newer than the latest known migration %d", version, latestMigrationVersion())
+		}
+	}
+
+	for _, m := range migrations {
+		if _, done := applied[m.Version]; done {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Migrate(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		log.Infof("storage.migrate: Applied migration %d (%s)", m.Version, m.Name)
+	}
+	return nil
+}
+
+// appliedMigrationVersion returns the version of the last applied migration (0 if none was applied).
+func appliedMigrationVersion(db *gorm.DB) (int64, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return 0, nil
+	}
+
+	var version int64
+	if err := db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// --- Migration 1: initial schema
+
+// policyRuleV1 is the PolicyRule model at migration 1.
+type policyRuleV1 struct {
+	ID               int64     `gorm:"primaryKey"`
+	UUID             string    `gorm:"type:varchar(36);uniqueIndex"`
+	ZonePattern      string    `gorm:"type:varchar(255);uniqueIndex"`
+	ZoneSoa          string    `gorm:"type:varchar(255);not null"`
+	TargetUserFilter string    `gorm:"type:varchar(255);not null"`
+	Description      string    `gorm:"type:text;default:null"`
+	IncludeWww       bool      `gorm:"not null;default:false"`
+	AccessLevel      string    `gorm:"type:varchar(16);not null;default:manage"`
+	Enabled          bool      `gorm:"not null;default:true"`
+	Status           string    `gorm:"type:varchar(16);not null;default:approved"`
+	Version          int       `gorm:"not null;default:1"`
+	OwnerEmail       string    `gorm:"type:varchar(255);index:idx_policy_rules_owner_email"`
+	CreatedAt        time.Time `gorm:"index:idx_policy_rules_created_at"`
+	UpdatedAt        time.Time
+	DeletedAt        gorm.DeletedAt `gorm:"index"`
+}
+
+func (policyRuleV1) TableName() string { return "policy_rules" }
+
+// settingV1 is the Setting model at migration 1.
+type settingV1 struct {
+	Key       string `gorm:"type:varchar(255);primaryKey"`
+	Value     string `gorm:"type:text;not null"`
+	UpdatedAt time.Time
+}
+
+func (settingV1) TableName() string { return "settings" }
+
+// auditLogV1 is the AuditLog model at migration 1.
+type auditLogV1 struct {
+	ID         int64     `gorm:"primaryKey"`
+	Timestamp  time.Time `gorm:"not null;index"`
+	ActorEmail string    `gorm:"type:varchar(255);index"`
+	Action     string    `gorm:"type:varchar(16);not null"`
+	RuleID     int64     `gorm:"not null;index"`
+	BeforeJSON *string   `gorm:"type:text"`
+	AfterJSON  *string   `gorm:"type:text"`
+}
+
+func (auditLogV1) TableName() string { return "audit_logs" }
+
+// migrateInitialSchema creates the tables of the models at migration 1. Databases set up by AutoMigrate
+// before migrations were introduced already have (most of) these tables, so only the missing tables,
+// columns, and indexes are added, and rules created before UpdatedAt existed get their creation time.
+func migrateInitialSchema(tx *gorm.DB) error {
+	models := []any{&policyRuleV1{}, &settingV1{}, &auditLogV1{}}
+	if err := adaptColumnTypes(tx, models); err != nil {
+		return err
+	}
+	if err := tx.AutoMigrate(models...); err != nil {
+		return err
+	}
+	return tx.Model(&policyRuleV1{}).Where("updated_at IS NULL").UpdateColumn("updated_at", gorm.Expr("created_at")).Error
+}
+
+// --- Migration 2: add the rule expiry
+
+// policyRuleV2 holds the PolicyRule fields added by migration 2.
+type policyRuleV2 struct {
+	ExpiresAt *time.Time `gorm:"index:idx_policy_rules_expires_at"`
+}
+
+func (policyRuleV2) TableName() string { return "policy_rules" }
+
+// migrateAddRuleExpiry adds the expiry time of rules. Existing rules do not expire.
+func migrateAddRuleExpiry(tx *gorm.DB) error {
+	if !tx.Migrator().HasColumn(&policyRuleV2{}, "ExpiresAt") {
+		if err := tx.Migrator().AddColumn(&policyRuleV2{}, "ExpiresAt"); err != nil {
+			return err
+		}
+	}
+	if !tx.Migrator().HasIndex(&policyRuleV2{}, "idx_policy_rules_expires_at") {
+		return tx.Migrator().CreateIndex(&policyRuleV2{}, "idx_policy_rules_expires_at")
+	}
+	return nil
+}
+
+// --- Migration 3: add the audit request body
+
+// auditLogV3 holds the AuditLog fields added by migration 3.
+type auditLogV3 struct {
+	RequestBody          *string `gorm:"type:text"`
+	RequestBodyTruncated bool    `gorm:"not null;default:false"`
+}
+
+func (auditLogV3) TableName() string { return "audit_logs" }
+
+// migrateAddAuditRequestBody adds the request body to the audit log. Existing entries have no request body.
+func migrateAddAuditRequestBody(tx *gorm.DB) error {
+	for _, field := range []string{"RequestBody", "RequestBodyTruncated"} {
+		if tx.Migrator().HasColumn(&auditLogV3{}, field) {
+			continue
+		}
+		if err := tx.Migrator().AddColumn(&auditLogV3{}, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDSN returns the connection string of an in-memory SQLite database that is private to the test.
+func testDSN(t testing.TB) string {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	return "file:" + name + "?mode=memory&cache=shared"
+}
+
+// openMigratedTo opens the database and applies the migrations up to (and including) the given version.
+// The connection is kept open until the end of the test, so the in-memory database survives.
+func openMigratedTo(t testing.TB, dsn string, version int64) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		t.Fatalf("failed to create the schema_migrations table: %v", err)
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		if err := m.Migrate(db); err != nil {
+			t.Fatalf("migration %d failed: %v", m.Version, err)
+		}
+		db.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()})
+	}
+	return db
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	s := newTestStorage(t)
+
+	status, err := s.SchemaStatus()
+	if err != nil {
+		t.Fatalf("SchemaStatus failed: %v", err)
+	}
+	if !status.UpToDate || status.Mode != SchemaModeMigrations || status.AppliedMigration != latestMigrationVersion() {
+		t.Fatalf("unexpected schema status %+v", status)
+	}
+	// Neither the webhook nor the search can use an index on the target user filter
+	if s.db.Migrator().HasIndex(&PolicyRule{}, "idx_policy_rules_target_user_filter") {
+		t.Fatal("expected no index on the target user filter")
+	}
+}
+
+func TestMigrateAddsRuleExpiry(t *testing.T) {
+	dsn := testDSN(t)
+	db := openMigratedTo(t, dsn, 1)
+	if err := db.Create(&policyRuleV1{ZonePattern: "%u.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"}).Error; err != nil {
+		t.Fatalf("failed to insert rule: %v", err)
+	}
+
+	s, err := NewStorage("sqlite", dsn, Options{})
+	if err != nil {
+		t.Fatalf("failed to apply the pending migrations: %v", err)
+	}
+	defer s.Close()
+
+	if !db.Migrator().HasColumn(&policyRuleV2{}, "ExpiresAt") || !db.Migrator().HasIndex(&policyRuleV2{}, "idx_policy_rules_expires_at") {
+		t.Fatal("migration 2 did not add the expiry column and its index")
+	}
+	// Existing rules do not expire
+	rules, err := s.PolicyGetAll()
+	if err != nil || len(rules) != 1 || rules[0].ExpiresAt != nil {
+		t.Fatalf("expected the existing rule without expiry, got %+v (%v)", rules, err)
+	}
+}
+
+func TestMigrateAddsAuditRequestBody(t *testing.T) {
+	dsn := testDSN(t)
+	db := openMigratedTo(t, dsn, 2)
+	if err := db.Create(&auditLogV1{Timestamp: time.Now(), Action: AuditActionCreate, RuleID: 1}).Error; err != nil {
+		t.Fatalf("failed to insert audit log entry: %v", err)
+	}
+
+	s, err := NewStorage("sqlite", dsn, Options{})
+	if err != nil {
+		t.Fatalf("failed to apply the pending migrations: %v", err)
+	}
+	defer s.Close()
+
+	if !db.Migrator().HasColumn(&auditLogV3{}, "RequestBody") || !db.Migrator().HasColumn(&auditLogV3{}, "RequestBodyTruncated") {
+		t.Fatal("migration 3 did not add the request body columns")
+	}
+	// Existing entries have no request body
+	entries, _, err := s.AuditGetPage(0, 10)
+	if err != nil || len(entries) != 1 || entries[0].RequestBody != nil || entries[0].RequestBodyTruncated {
+		t.Fatalf("expected the existing entry without request body, got %+v (%v)", entries, err)
+	}
+}
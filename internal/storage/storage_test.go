@@ -0,0 +1,617 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"gorm.io/gorm"
+)
+
+// newTestStorage creates a storage backed by an in-memory SQLite database that is private to the test.
+func newTestStorage(t testing.TB) *Storage {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	s, err := NewStorage("sqlite", "file:"+name+"?mode=memory&cache=shared", Options{})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// createTestRules creates count rules with the zone patterns '%u.zone-<i>.example.org'.
+func createTestRules(t testing.TB, s *Storage, count int) []PolicyRule {
+	t.Helper()
+	rules := make([]PolicyRule, count)
+	for i := range rules {
+		rules[i] = PolicyRule{
+			ZonePattern:      fmt.Sprintf("%%u.zone-%d.example.org", i),
+			ZoneSoa:          fmt.Sprintf("zone-%d.example.org", i),
+			TargetUserFilter: "*@example.org",
+		}
+	}
+	created, err := s.PolicyBulkCreate(rules)
+	if err != nil {
+		t.Fatalf("failed to create rules: %v", err)
+	}
+	return created
+}
+
+func TestPolicyStream(t *testing.T) {
+	s := newTestStorage(t)
+	createTestRules(t, s, 300)
+
+	var ids []int64
+	err := s.PolicyStream(func(rule PolicyRule) error {
+		ids = append(ids, rule.ID)
+		if rule.ZonePattern != fmt.Sprintf("%%u.zone-%d.example.org", len(ids)-1) {
+			t.Errorf("unexpected rule %d: %s", len(ids), rule.ZonePattern)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("PolicyStream failed: %v", err)
+	}
+	if len(ids) != 300 {
+		t.Fatalf("expected 300 rules, got %d", len(ids))
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("rules are not streamed in ID order: %d after %d", ids[i], ids[i-1])
+		}
+	}
+}
+
+func TestPolicyStreamStopsOnError(t *testing.T) {
+	s := newTestStorage(t)
+	createTestRules(t, s, 10)
+
+	errStop := errors.New("stop")
+	visited := 0
+	err := s.PolicyStream(func(rule PolicyRule) error {
+		visited++
+		if visited == 3 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expected the callback error, got %v", err)
+	}
+	if visited != 3 {
+		t.Fatalf("expected the iteration to stop after 3 rules, visited %d", visited)
+	}
+}
+
+func TestPolicyInsertDummyDataTwice(t *testing.T) {
+	s := newTestStorage(t)
+
+	inserted, skipped, err := s.PolicyInsertDummyData()
+	if err != nil || inserted == 0 || skipped != 0 {
+		t.Fatalf("first insertion: inserted %d, skipped %d, error %v", inserted, skipped, err)
+	}
+
+	// Inserting again skips all existing rules instead of failing
+	insertedAgain, skippedAgain, err := s.PolicyInsertDummyData()
+	if err != nil || insertedAgain != 0 || skippedAgain != inserted {
+		t.Fatalf("second insertion: inserted %d, skipped %d, error %v", insertedAgain, skippedAgain, err)
+	}
+
+	rules, err := s.PolicyGetAll()
+	if err != nil || len(rules) != inserted {
+		t.Fatalf("expected %d rules, got %d (%v)", inserted, len(rules), err)
+	}
+}
+
+// BenchmarkPolicyMatchingQueries compares the queries of the webhook (all rules, matched in memory) and of the
+// search by user filter with and without an index on the target user filter. Neither query can use the index,
+// so the column is not indexed.
+func BenchmarkPolicyMatchingQueries(b *testing.B) {
+	for _, indexed := range []bool{false, true} {
+		name := "without_index"
+		if indexed {
+			name = "with_index"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := newTestStorage(b)
+			rules := make([]PolicyRule, 3000)
+			for i := range rules {
+				rules[i] = PolicyRule{
+					ZonePattern:      fmt.Sprintf("%%u.zone-%d.example.org", i),
+					ZoneSoa:          "example.org",
+					TargetUserFilter: fmt.Sprintf("*@org-%d.example.org", i%300),
+				}
+			}
+			if _, err := s.PolicyBulkCreate(rules); err != nil {
+				b.Fatalf("failed to create rules: %v", err)
+			}
+			if indexed {
+				if err := s.db.Exec("CREATE INDEX idx_benchmark_target_user_filter ON policy_rules (target_user_filter)").Error; err != nil {
+					b.Fatalf("failed to create index: %v", err)
+				}
+			}
+
+			b.Run("all_rules", func(b *testing.B) {
+				for b.Loop() {
+					if _, err := s.PolicyGetAll(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run("search_by_user_filter", func(b *testing.B) {
+				for b.Loop() {
+					if _, err := s.PolicySearch(PolicyFilter{TargetUserFilter: "*@org-42.example.org"}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkPolicyOwnerQueries compares the owner-scoped query of the rule submission limit and the listing sorted by
+// creation time with and without the indexes on owner_email and created_at.
+func BenchmarkPolicyOwnerQueries(b *testing.B) {
+	for _, indexed := range []bool{true, false} {
+		name := "with_indexes"
+		if !indexed {
+			name = "without_indexes"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := newTestStorage(b)
+			rules := make([]PolicyRule, 5000)
+			start := time.Now().Add(-time.Duration(len(rules)) * time.Minute)
+			for i := range rules {
+				rules[i] = PolicyRule{
+					ZonePattern:      fmt.Sprintf("%%u.zone-%d.example.org", i),
+					ZoneSoa:          "example.org",
+					TargetUserFilter: "*@example.org",
+					OwnerEmail:       fmt.Sprintf("owner-%d@example.org", i%500),
+					CreatedAt:        start.Add(time.Duration(i) * time.Minute),
+				}
+			}
+			if _, err := s.PolicyBulkCreate(rules); err != nil {
+				b.Fatalf("failed to create rules: %v", err)
+			}
+			if !indexed {
+				for _, index := range []string{"idx_policy_rules_owner_email", "idx_policy_rules_created_at"} {
+					if err := s.db.Migrator().DropIndex(&PolicyRule{}, index); err != nil {
+						b.Fatalf("failed to drop index %s: %v", index, err)
+					}
+				}
+			}
+
+			b.Run("count_created_by_owner", func(b *testing.B) {
+				since := time.Now().Add(-24 * time.Hour)
+				for b.Loop() {
+					if _, _, err := s.PolicyCountCreatedByOwnerSince("owner-42@example.org", since); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+			b.Run("page_by_creation_time", func(b *testing.B) {
+				for b.Loop() {
+					if _, err := s.PolicyGetPage(0, 50, ListOrderCreatedDesc); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestPolicyUpdateBumpsUpdatedAt(t *testing.T) {
+	s := newTestStorage(t)
+	created := createTestRules(t, s, 1)[0]
+
+	// An update without changes still counts as a modification of the rule
+	time.Sleep(5 * time.Millisecond)
+	unchanged := created
+	updated, err := s.PolicyUpdate(&unchanged)
+	if err != nil {
+		t.Fatalf("PolicyUpdate failed: %v", err)
+	}
+	if !updated.UpdatedAt.After(created.UpdatedAt) {
+		t.Fatalf("expected the update time to advance from %v, got %v", created.UpdatedAt, updated.UpdatedAt)
+	}
+	if !updated.CreatedAt.Equal(created.CreatedAt) || updated.ZonePattern != created.ZonePattern {
+		t.Fatalf("the update changed the rule: %+v", updated)
+	}
+
+	stored, err := s.PolicyGetByID(created.ID)
+	if err != nil {
+		t.Fatalf("PolicyGetByID failed: %v", err)
+	}
+	if !stored.UpdatedAt.Equal(updated.UpdatedAt) {
+		t.Fatalf("expected the stored update time %v, got %v", updated.UpdatedAt, stored.UpdatedAt)
+	}
+
+	missing := PolicyRule{ID: created.ID + 1, ZonePattern: "%u.missing.example.org"}
+	if _, err := s.PolicyUpdate(&missing); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected gorm.ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestPolicyUpdateIfStaleVersion(t *testing.T) {
+	s := newTestStorage(t)
+	created := createTestRules(t, s, 1)[0]
+	if created.Version != 1 {
+		t.Fatalf("expected version 1 of a new rule, got %d", created.Version)
+	}
+
+	// Two admins read version 1, the first update wins and the stale one is rejected
+	first := created
+	first.Description = "first"
+	updated, err := s.PolicyUpdateIf(created.ID, PolicyRule{Version: 1}, first)
+	if err != nil {
+		t.Fatalf("PolicyUpdateIf failed: %v", err)
+	}
+	if updated.Version != 2 || updated.Description != "first" {
+		t.Fatalf("unexpected updated rule %+v", updated)
+	}
+	second := created
+	second.Description = "second"
+	if _, err := s.PolicyUpdateIf(created.ID, PolicyRule{Version: 1}, second); !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected ErrPreconditionFailed for a stale version, got %v", err)
+	}
+	stored, err := s.PolicyGetByID(created.ID)
+	if err != nil {
+		t.Fatalf("PolicyGetByID failed: %v", err)
+	}
+	if stored.Version != 2 || stored.Description != "first" {
+		t.Fatalf("the stale update changed the rule: %+v", stored)
+	}
+
+	if _, err := s.PolicyUpdateIf(created.ID+1, PolicyRule{Version: 1}, second); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected gorm.ErrRecordNotFound, got %v", err)
+	}
+}
+
+func TestPolicyUpdateIfConcurrent(t *testing.T) {
+	s := newTestStorage(t)
+	created := createTestRules(t, s, 1)[0]
+
+	// Of several concurrent updates of the same version, exactly one succeeds
+	const updaters = 8
+	errs := make([]error, updaters)
+	var wg sync.WaitGroup
+	for i := range updaters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rule := created
+			rule.Description = fmt.Sprintf("updater %d", i)
+			_, errs[i] = s.PolicyUpdateIf(created.ID, PolicyRule{Version: created.Version}, rule)
+		}()
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		switch {
+		case err == nil && winner == -1:
+			winner = i
+		case err == nil:
+			t.Fatalf("expected a single update to succeed, but updaters %d and %d did", winner, i)
+		case !errors.Is(err, ErrPreconditionFailed):
+			t.Fatalf("expected ErrPreconditionFailed for updater %d, got %v", i, err)
+		}
+	}
+	if winner == -1 {
+		t.Fatal("expected one update to succeed")
+	}
+
+	stored, err := s.PolicyGetByID(created.ID)
+	if err != nil {
+		t.Fatalf("PolicyGetByID failed: %v", err)
+	}
+	if stored.Version != created.Version+1 || stored.Description != fmt.Sprintf("updater %d", winner) {
+		t.Fatalf("expected the rule of updater %d with version %d, got %+v", winner, created.Version+1, stored)
+	}
+}
+
+func TestStorageContextCancellation(t *testing.T) {
+	s := newTestStorage(t)
+	createTestRules(t, s, 300)
+
+	// A canceled context fails the query before it reaches the database
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.PolicyGetAllCtx(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := s.PolicyGetByIDCtx(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Canceling the context while rules are read stops the running query
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	visited := 0
+	err := s.PolicyStreamCtx(ctx, func(rule PolicyRule) error {
+		visited++
+		if visited == 1 {
+			cancel()
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if visited >= 300 {
+		t.Fatalf("expected the query to stop after the cancellation, but all %d rules were read", visited)
+	}
+}
+
+func TestPolicyGetExpiringAfter(t *testing.T) {
+	s := newTestStorage(t)
+	rules := createTestRules(t, s, 4)
+	now := time.Now()
+	expired, later, soon := now.Add(-time.Minute), now.Add(2*time.Hour), now.Add(time.Hour)
+	expiries := []*time.Time{nil, &expired, &later, &soon}
+	for i := range rules {
+		rules[i].ExpiresAt = expiries[i]
+		if _, err := s.PolicyUpdate(&rules[i]); err != nil {
+			t.Fatalf("PolicyUpdate failed: %v", err)
+		}
+	}
+
+	// Only rules expiring after the time are returned, the next expiring first
+	expiring, err := s.PolicyGetExpiringAfter(now)
+	if err != nil {
+		t.Fatalf("PolicyGetExpiringAfter failed: %v", err)
+	}
+	if len(expiring) != 2 || expiring[0].ID != rules[3].ID || expiring[1].ID != rules[2].ID {
+		t.Fatalf("expected rules %d and %d, got %+v", rules[3].ID, rules[2].ID, expiring)
+	}
+
+	// Clearing the expiry is persisted as well
+	rules[3].ExpiresAt = nil
+	if _, err := s.PolicyUpdate(&rules[3]); err != nil {
+		t.Fatalf("PolicyUpdate failed: %v", err)
+	}
+	if expiring, err := s.PolicyGetExpiringAfter(now); err != nil || len(expiring) != 1 || expiring[0].ID != rules[2].ID {
+		t.Fatalf("expected rule %d only, got %+v (%v)", rules[2].ID, expiring, err)
+	}
+}
+
+func TestPolicyCountBySOA(t *testing.T) {
+	s := newTestStorage(t)
+	if counts, err := s.PolicyCountBySOA(); err != nil || counts == nil || len(counts) != 0 {
+		t.Fatalf("expected an empty map without rules, got %v (%v)", counts, err)
+	}
+
+	// SOAs differing only in case are counted together; deleted rules are not counted
+	soas := []string{"a.example.org", "A.Example.org", "b.example.org", "a.example.org", "c.example.org"}
+	for i, soa := range soas {
+		if _, err := s.PolicyCreate(&PolicyRule{ZonePattern: fmt.Sprintf("%%u.zone-%d.example.org", i), ZoneSoa: soa, TargetUserFilter: "*@example.org"}); err != nil {
+			t.Fatalf("PolicyCreate failed: %v", err)
+		}
+	}
+	if err := s.PolicyDelete(5); err != nil {
+		t.Fatalf("PolicyDelete failed: %v", err)
+	}
+
+	counts, err := s.PolicyCountBySOA()
+	if err != nil {
+		t.Fatalf("PolicyCountBySOA failed: %v", err)
+	}
+	if len(counts) != 2 || counts["a.example.org"] != 3 || counts["b.example.org"] != 1 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+}
+
+func TestPolicyFindConflicts(t *testing.T) {
+	s := newTestStorage(t)
+	literal, err := s.PolicyCreate(&PolicyRule{ZonePattern: "project.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"})
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+	users, err := s.PolicyCreate(&PolicyRule{ZonePattern: "%u.users.example.org", ZoneSoa: "users.example.org", TargetUserFilter: "*@example.org"})
+	if err != nil {
+		t.Fatalf("failed to create rule: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		rule PolicyRule
+		want []int64
+	}{
+		{"literal duplicate with another SOA", PolicyRule{ZonePattern: "project.example.org", ZoneSoa: "project.example.org"}, []int64{literal.ID}},
+		{"literal duplicate in another case with a trailing dot", PolicyRule{ZonePattern: "Project.Example.org."}, []int64{literal.ID}},
+		{"same placeholder", PolicyRule{ZonePattern: "%u.users.example.org"}, []int64{users.ID}},
+		{"other placeholder", PolicyRule{ZonePattern: "%g.users.example.org"}, []int64{users.ID}},
+		{"rule itself", PolicyRule{ID: users.ID, ZonePattern: "%u.users.example.org"}, nil},
+		{"literal within a placeholder pattern", PolicyRule{ZonePattern: "jane.users.example.org"}, nil},
+		{"other zone", PolicyRule{ZonePattern: "%u.staff.example.org"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conflicts, err := s.PolicyFindConflicts(&test.rule)
+			if err != nil {
+				t.Fatalf("PolicyFindConflicts failed: %v", err)
+			}
+			if len(conflicts) != len(test.want) {
+				t.Fatalf("expected conflicts %v, got %+v", test.want, conflicts)
+			}
+			for i, conflict := range conflicts {
+				if conflict.ID != test.want[i] {
+					t.Fatalf("expected conflicts %v, got %+v", test.want, conflicts)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyBulkCreate(t *testing.T) {
+	s := newTestStorage(t)
+	existing := createTestRules(t, s, 1)[0]
+	rules := []PolicyRule{
+		{ZonePattern: "%u.new.example.org", ZoneSoa: "new.example.org", TargetUserFilter: "*@example.org"},
+		{ZonePattern: existing.ZonePattern, ZoneSoa: existing.ZoneSoa, TargetUserFilter: "*@example.org"},
+		{ZonePattern: "%g.new.example.org", ZoneSoa: "new.example.org", TargetUserFilter: "*@example.org"},
+		{ZonePattern: "shared.new.example.org", ZoneSoa: "new.example.org", TargetUserFilter: "*@example.org"},
+	}
+
+	// A duplicate aborts the whole batch
+	_, err := s.PolicyBulkCreate(rules)
+	var bulkErr *BulkCreateError
+	if !errors.As(err, &bulkErr) || bulkErr.Index != 1 || !errors.Is(err, ErrDuplicateZonePattern) {
+		t.Fatalf("expected a duplicate zone pattern at index 1, got %v", err)
+	}
+	if all, _ := s.PolicyGetAll(); len(all) != 1 {
+		t.Fatalf("expected no rule to be created, got %d rules", len(all))
+	}
+
+	// Duplicates and conflicts (also with earlier rules of the batch) are skipped and reported in input order
+	created, skipped, err := s.PolicyBulkCreateSkipConflicts(rules)
+	if err != nil {
+		t.Fatalf("PolicyBulkCreateSkipConflicts failed: %v", err)
+	}
+	if len(created) != 2 || created[0].ZonePattern != rules[0].ZonePattern || created[1].ZonePattern != rules[3].ZonePattern {
+		t.Fatalf("expected rules 0 and 3 to be created, got %+v", created)
+	}
+	if len(skipped) != 2 || skipped[0].Index != 1 || !errors.Is(skipped[0].Err, ErrDuplicateZonePattern) ||
+		skipped[1].Index != 2 || !errors.Is(skipped[1].Err, ErrConflictingZonePattern) {
+		t.Fatalf("expected a duplicate at index 1 and a conflict at index 2, got %+v", skipped)
+	}
+	if all, _ := s.PolicyGetAll(); len(all) != 3 {
+		t.Fatalf("expected 3 rules, got %d", len(all))
+	}
+}
+
+func TestPolicyDeletedZonePattern(t *testing.T) {
+	s := newTestStorage(t)
+	rules := createTestRules(t, s, 2)
+	deleted, other := rules[0], rules[1]
+	if err := s.PolicyDelete(deleted.ID); err != nil {
+		t.Fatalf("PolicyDelete failed: %v", err)
+	}
+
+	// The pattern of the deleted rule cannot be taken over
+	reuse := PolicyRule{ZonePattern: deleted.ZonePattern, ZoneSoa: deleted.ZoneSoa, TargetUserFilter: "*@example.org"}
+	if _, err := s.PolicyCreate(&reuse); !errors.Is(err, ErrDeletedZonePattern) {
+		t.Fatalf("expected ErrDeletedZonePattern on create, got %v", err)
+	}
+	update := other
+	update.ZonePattern = deleted.ZonePattern
+	if _, err := s.PolicyUpdate(&update); !errors.Is(err, ErrDeletedZonePattern) {
+		t.Fatalf("expected ErrDeletedZonePattern on update, got %v", err)
+	}
+	if _, err := s.PolicyUpdateIf(other.ID, PolicyRule{Version: other.Version}, update); !errors.Is(err, ErrDeletedZonePattern) {
+		t.Fatalf("expected ErrDeletedZonePattern on conditional update, got %v", err)
+	}
+	if _, err := s.PolicyRenamePattern(other.ID, deleted.ZonePattern); !errors.Is(err, ErrDeletedZonePattern) {
+		t.Fatalf("expected ErrDeletedZonePattern on rename, got %v", err)
+	}
+	if _, err := s.PolicyBulkCreate([]PolicyRule{reuse}); !errors.Is(err, ErrDeletedZonePattern) {
+		t.Fatalf("expected ErrDeletedZonePattern on bulk create, got %v", err)
+	}
+	if _, skipped, err := s.PolicyBulkCreateSkipConflicts([]PolicyRule{reuse}); err != nil || len(skipped) != 1 || !errors.Is(skipped[0].Err, ErrDeletedZonePattern) {
+		t.Fatalf("expected the rule to be skipped, got %+v (%v)", skipped, err)
+	}
+
+	// The deleted rule is kept and can still be restored
+	if restored, err := s.PolicyRestore(deleted.ID); err != nil || restored.ZonePattern != deleted.ZonePattern {
+		t.Fatalf("expected the deleted rule to be restorable, got %+v (%v)", restored, err)
+	}
+
+	// Once purged, the pattern is free again
+	if err := s.PolicyPurge(deleted.ID); err != nil {
+		t.Fatalf("PolicyPurge failed: %v", err)
+	}
+	if _, err := s.PolicyCreate(&reuse); err != nil {
+		t.Fatalf("expected the pattern of the purged rule to be reusable, got %v", err)
+	}
+}
+
+func TestPolicySearch(t *testing.T) {
+	s := newTestStorage(t)
+	for _, rule := range []PolicyRule{
+		{ZonePattern: "%u.users.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org", Description: "Personal zones"},
+		{ZonePattern: "%u.staff.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@staff.example.org", Description: "Staff zones"},
+		{ZonePattern: "%u.users.example.com", ZoneSoa: "example.com", TargetUserFilter: "*@example.org", Description: "100% personal"},
+	} {
+		if _, err := s.PolicyCreate(&rule); err != nil {
+			t.Fatalf("failed to create rule: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter PolicyFilter
+		want   []string
+	}{
+		{"no filter", PolicyFilter{}, []string{"%u.users.example.org", "%u.staff.example.org", "%u.users.example.com"}},
+		{"soa", PolicyFilter{ZoneSoa: "EXAMPLE.org"}, []string{"%u.users.example.org", "%u.staff.example.org"}},
+		{"target filter", PolicyFilter{TargetUserFilter: "*@Example.org"}, []string{"%u.users.example.org", "%u.users.example.com"}},
+		{"query in the pattern", PolicyFilter{Query: "USERS"}, []string{"%u.users.example.org", "%u.users.example.com"}},
+		{"query in the description", PolicyFilter{Query: "staff zones"}, []string{"%u.staff.example.org"}},
+		{"soa and target filter", PolicyFilter{ZoneSoa: "example.org", TargetUserFilter: "*@example.org"}, []string{"%u.users.example.org"}},
+		{"soa and query", PolicyFilter{ZoneSoa: "example.com", Query: "personal"}, []string{"%u.users.example.com"}},
+		{"all filters", PolicyFilter{ZoneSoa: "example.org", TargetUserFilter: "*@staff.example.org", Query: "staff"}, []string{"%u.staff.example.org"}},
+		{"percent in the query is literal", PolicyFilter{Query: "100%"}, []string{"%u.users.example.com"}},
+		{"underscore in the query is literal", PolicyFilter{Query: "_"}, []string{}},
+		{"no match of combined filters", PolicyFilter{ZoneSoa: "example.com", TargetUserFilter: "*@staff.example.org"}, []string{}},
+		{"no match of the query", PolicyFilter{Query: "' OR '1'='1"}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := s.PolicySearch(test.filter)
+			if err != nil {
+				t.Fatalf("PolicySearch failed: %v", err)
+			}
+			patterns := make([]string, len(rules))
+			for i, rule := range rules {
+				patterns[i] = rule.ZonePattern
+			}
+			if !slices.Equal(patterns, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, patterns)
+			}
+		})
+	}
+}
+
+// unreachableDSN points to a PostgreSQL server on a port where nothing listens, so connecting fails fast.
+const unreachableDSN = "host=127.0.0.1 port=1 user=app dbname=app sslmode=disable connect_timeout=1"
+
+func TestNewStorageRetriesUnreachableDatabase(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	options := Options{ConnectRetries: 3, ConnectRetryBackoff: time.Millisecond, Log: zap.New(core).Sugar()}
+
+	_, err := NewStorage("postgres", unreachableDSN, options)
+	if err == nil || !strings.Contains(err.Error(), "giving up after 4 attempt(s)") {
+		t.Fatalf("expected an error after 4 attempts, got: %v", err)
+	}
+	// Each failed attempt but the last one is logged before its retry
+	if retries := logs.FilterMessageSnippet("Database connection attempt").Len(); retries != 3 {
+		t.Fatalf("expected 3 retries, got %d", retries)
+	}
+
+	// Without retries, the first failure is returned
+	core, logs = observer.New(zap.WarnLevel)
+	options = Options{Log: zap.New(core).Sugar()}
+	if _, err := NewStorage("postgres", unreachableDSN, options); err == nil || !strings.Contains(err.Error(), "giving up after 1 attempt(s)") {
+		t.Fatalf("expected an error after 1 attempt, got: %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("expected no retries, got %d log entries", logs.Len())
+	}
+
+	// The retries stop before the maximum wait time would be exceeded
+	options = Options{ConnectRetries: 10, ConnectRetryBackoff: 50 * time.Millisecond, ConnectMaxWait: 120 * time.Millisecond}
+	if _, err := NewStorage("postgres", unreachableDSN, options); err == nil || !strings.Contains(err.Error(), "giving up after 2 attempt(s), as the next one would exceed the maximum wait time") {
+		t.Fatalf("expected an error after 2 attempts, got: %v", err)
+	}
+}
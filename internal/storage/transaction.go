@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	mssql "github.com/microsoft/go-mssqldb"
+	"gorm.io/gorm"
+)
+
+// Database error codes reported when a transaction was aborted to resolve a deadlock
+const (
+	postgresDeadlockCode    = "40P01"
+	mysqlDeadlockNumber     = 1213
+	sqlserverDeadlockNumber = 1205
+)
+
+// transaction runs fn in a database transaction. If the transaction is aborted because of a
+// deadlock, it is retried up to the configured number of times with a growing backoff. Other
+// errors are returned immediately, and the context error if the context of the storage is done
+// while waiting for a retry.
+func (s *Storage) transaction(fn func(tx *gorm.DB) error) error {
+	ctx := s.db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	backoff := s.options.DeadlockRetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := s.db.Transaction(fn)
+		if err == nil || !isDeadlock(err) || attempt >= s.options.DeadlockRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff * time.Duration(attempt+1)):
+		}
+	}
+}
+
+// WithTransaction runs fn with a Storage whose operations all take part in one database transaction.
+// The transaction is committed if fn returns nil and rolled back if it returns an error (which is
+// returned) or panics. fn may be called again if the transaction is retried after a deadlock, so it
+// must not have side effects outside the database.
+func (s *Storage) WithTransaction(fn func(*Storage) error) error {
+	return s.transaction(func(tx *gorm.DB) error {
+		// Deadlocks abort the whole transaction, so only the outermost transaction retries
+		txOptions := s.options
+		txOptions.DeadlockRetries = 0
+		return fn(&Storage{db: tx, options: txOptions})
+	})
+}
+
+// isDeadlock reports whether the error was caused by a deadlock (PostgreSQL, MySQL, and SQL Server only).
+func isDeadlock(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == postgresDeadlockCode
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDeadlockNumber
+	}
+
+	var mssqlErr mssql.Error
+	if errors.As(err, &mssqlErr) {
+		return mssqlErr.Number == sqlserverDeadlockNumber
+	}
+
+	return false
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	mssql "github.com/microsoft/go-mssqldb"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// failingDialector is a SQLite dialector whose inserts of rules fail with err until failures
+// inserts have failed. It counts the attempted inserts.
+type failingDialector struct {
+	gorm.Dialector
+	err      error
+	failures int
+	attempts int
+}
+
+func (d *failingDialector) Initialize(db *gorm.DB) error {
+	if err := d.Dialector.Initialize(db); err != nil {
+		return err
+	}
+	return db.Callback().Create().Before("gorm:create").Register("test:fail_rule_inserts", func(tx *gorm.DB) {
+		if tx.Statement.Schema == nil || tx.Statement.Schema.Table != "policy_rules" {
+			return
+		}
+		d.attempts++
+		if d.attempts <= d.failures {
+			tx.AddError(d.err)
+		}
+	})
+}
+
+// newFailingStorage creates a storage on the failing dialector that retries deadlocked transactions twice.
+func newFailingStorage(t *testing.T, dialector *failingDialector) *Storage {
+	t.Helper()
+	dialector.Dialector = sqlite.Open(testDSN(t))
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := migrate(db, Options{}.logger()); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	return &Storage{db: db, options: Options{DeadlockRetries: 2, DeadlockRetryBackoff: time.Millisecond}}
+}
+
+func TestTransactionDeadlockRetry(t *testing.T) {
+	postgresDeadlock := &pgconn.PgError{Code: postgresDeadlockCode}
+	mysqlDeadlock := &mysql.MySQLError{Number: mysqlDeadlockNumber}
+	otherError := errors.New("disk full")
+
+	tests := []struct {
+		name         string
+		err          error
+		failures     int
+		wantErr      error
+		wantAttempts int
+	}{
+		{"no failure", postgresDeadlock, 0, nil, 1},
+		{"postgres deadlock retried", postgresDeadlock, 2, nil, 3},
+		{"mysql deadlock retried", mysqlDeadlock, 1, nil, 2},
+		{"retries exhausted", postgresDeadlock, 3, postgresDeadlock, 3},
+		{"other error not retried", otherError, 1, otherError, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dialector := &failingDialector{err: test.err, failures: test.failures}
+			s := newFailingStorage(t, dialector)
+
+			_, err := s.PolicyCreate(&PolicyRule{ZonePattern: "%u.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"})
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil) != (err == nil) {
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			if dialector.attempts != test.wantAttempts {
+				t.Fatalf("expected %d attempt(s), got %d", test.wantAttempts, dialector.attempts)
+			}
+
+			// Failed attempts are rolled back, so the rule exists once if the transaction succeeded
+			var count int64
+			s.db.Model(&PolicyRule{}).Count(&count)
+			if want := map[bool]int64{true: 1, false: 0}[err == nil]; count != want {
+				t.Fatalf("expected %d rule(s), got %d", want, count)
+			}
+		})
+	}
+}
+
+func TestTransactionDeadlockRetryCancelled(t *testing.T) {
+	dialector := &failingDialector{err: &pgconn.PgError{Code: postgresDeadlockCode}, failures: 3}
+	s := newFailingStorage(t, dialector)
+	s.options.DeadlockRetryBackoff = time.Hour
+
+	// The backoff is abandoned when the request is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := s.PolicyCreateCtx(ctx, &PolicyRule{ZonePattern: "%u.example.org", ZoneSoa: "example.org", TargetUserFilter: "*@example.org"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected the retry wait to end with the context, took %s", elapsed)
+	}
+	if dialector.attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", dialector.attempts)
+	}
+}
+
+func TestIsDeadlock(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: postgresDeadlockCode}, true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{&mysql.MySQLError{Number: mysqlDeadlockNumber}, true},
+		{&mysql.MySQLError{Number: 1062}, false},
+		{mssql.Error{Number: sqlserverDeadlockNumber}, true},
+		{mssql.Error{Number: 2627}, false},
+		{fmt.Errorf("storage: %w", &pgconn.PgError{Code: postgresDeadlockCode}), true},
+		{errors.New("deadlock detected"), false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		if got := isDeadlock(test.err); got != test.want {
+			t.Errorf("isDeadlock(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestWithTransactionRollback(t *testing.T) {
+	s := newTestStorage(t)
+	rule := createTestRules(t, s, 1)[0]
+	errAbort := errors.New("abort")
+	var auditEntriesBefore int64
+	s.db.Model(&AuditLog{}).Count(&auditEntriesBefore)
+
+	// A failure after some writes rolls back all of them
+	err := s.WithTransaction(func(tx *Storage) error {
+		if _, err := tx.PolicyCreate(&PolicyRule{ZonePattern: "%u.new.example.org", ZoneSoa: "new.example.org", TargetUserFilter: "*@example.org"}); err != nil {
+			return err
+		}
+		updated := rule
+		updated.Description = "changed in the transaction"
+		if _, err := tx.PolicyUpdate(&updated); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+
+	rules, err := s.PolicyGetAll()
+	if err != nil {
+		t.Fatalf("PolicyGetAll failed: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Description != rule.Description || rules[0].Version != rule.Version {
+		t.Fatalf("expected the transaction to be rolled back, got %+v", rules)
+	}
+	var auditEntries int64
+	s.db.Model(&AuditLog{}).Count(&auditEntries)
+	if auditEntries != auditEntriesBefore {
+		t.Fatalf("expected the audit log entries to be rolled back, got %d instead of %d", auditEntries, auditEntriesBefore)
+	}
+
+	// A panic rolls back as well
+	func() {
+		defer func() { recover() }()
+		_ = s.WithTransaction(func(tx *Storage) error {
+			if err := tx.PolicyDelete(rule.ID); err != nil {
+				return err
+			}
+			panic("abort")
+		})
+	}()
+	if _, err := s.PolicyGetByID(rule.ID); err != nil {
+		t.Fatalf("expected the delete to be rolled back, got %v", err)
+	}
+
+	// Without an error, all writes are committed
+	err = s.WithTransaction(func(tx *Storage) error {
+		_, err := tx.PolicyCreate(&PolicyRule{ZonePattern: "%u.new.example.org", ZoneSoa: "new.example.org", TargetUserFilter: "*@example.org"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction failed: %v", err)
+	}
+	if rules, _ := s.PolicyGetAll(); len(rules) != 2 {
+		t.Fatalf("expected 2 rules after the commit, got %d", len(rules))
+	}
+}